@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	vc "github.com/ethan-du-toit/hyperproofs-go/vcs"
+)
+
+// Checks the public parameters in a folder before a node trusts them.
+// Usage: hyperproofs-go audit [-ell 16] [-folder pkvk-16] [-ncores 16]
+// Exits with status 1 and the failing level if the UPK tree is not consistent with the VRK.
+func auditCommand(args []string) {
+
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	ell := fs.Uint("ell", 16, "Vector has 2^ell entries")
+	folder := fs.String("folder", "", "Folder with the public parameters (default pkvk-<ell>)")
+	ncores := fs.Uint("ncores", 16, "Maximum number of threads")
+	fs.Parse(args)
+
+	folderPath := *folder
+	if folderPath == "" {
+		folderPath = fmt.Sprintf("pkvk-%02d", *ell)
+	}
+	vc.NCORES = uint8(*ncores)
+
+	vcs := vc.VCS{}
+	err := vcs.TryInit(uint8(*ell), folderPath, 1)
+	if err == nil {
+		err = vcs.TryLoadVrk(uint8(*ell))
+	}
+	if err == nil {
+		err = vcs.TryUpkLoadDriver()
+	}
+	if err == nil {
+		err = vcs.AuditParams()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "audit:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Audit of", folderPath, "... OK")
+}
@@ -46,6 +46,24 @@ func BenchmarkVCSOpenAll(L uint8, txnLimit uint64) string {
 	return out
 }
 
+// Same as BenchmarkVCSOpenAll, on all the cores given to KeyGenLoad.
+func BenchmarkVCSOpenAllParallel(L uint8, txnLimit uint64) string {
+	N := uint64(1) << L
+	K := txnLimit
+	vcs := vc.VCS{}
+	vcs.KeyGenLoad(16, L, FOLDER, K)
+
+	aFr := vc.GenerateVector(N)
+	dt := time.Now()
+	vcs.OpenAllParallel(aFr)
+	duration := time.Since(dt)
+	out := fmt.Sprintf("BenchmarkVCS/%d/OpenAllParallel;%d%40d ns/op", L, txnLimit, duration.Nanoseconds())
+	fmt.Println(vc.SEP)
+	fmt.Println(out)
+	fmt.Println(vc.SEP)
+	return out
+}
+
 func Benchmark() {
 	var ell []uint8
 	var txns []uint64
@@ -65,6 +83,8 @@ func Benchmark() {
 		for i := range ell {
 			l := BenchmarkVCSOpenAll(ell[i], txnLimit)
 			logs = append(logs, l)
+			l = BenchmarkVCSOpenAllParallel(ell[i], txnLimit)
+			logs = append(logs, l)
 		}
 	}
 	fmt.Println(vc.SEP)
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	vc "github.com/ethan-du-toit/hyperproofs-go/vcs"
+)
+
+// Multi-party setup ceremony.
+// Usage:
+//
+//	hyperproofs-go ceremony new -ell 16 [-mn 524288] -out ceremony.data
+//	hyperproofs-go ceremony contribute -in ceremony.data -out ceremony.data
+//	hyperproofs-go ceremony verify -in ceremony.data
+//	hyperproofs-go ceremony finalize -in ceremony.data [-folder pkvk-16]
+//
+// Each participant runs contribute once and passes the file on. Anyone can run verify.
+// finalize writes the public parameters that KeyGenLoad reads.
+func ceremonyCommand(args []string) {
+
+	if len(args) == 0 {
+		ceremonyFail(fmt.Errorf("missing subcommand: new, contribute, verify or finalize"))
+	}
+	fs := flag.NewFlagSet("ceremony "+args[0], flag.ExitOnError)
+	ell := fs.Uint("ell", 16, "Vector has 2^ell entries")
+	mn := fs.Uint64("mn", vc.MAX_AGG_SIZE, "Size of the aggregation keys, a power of 2. Blocks with ell * (txns + 1) <= mn can be aggregated")
+	in := fs.String("in", "ceremony.data", "Ceremony file to read")
+	out := fs.String("out", "ceremony.data", "Ceremony file to write")
+	folder := fs.String("folder", "", "Output folder of finalize (default pkvk-<ell>)")
+	ncores := fs.Uint("ncores", 16, "Maximum number of threads")
+	fs.Parse(args[1:])
+	vc.NCORES = uint8(*ncores)
+
+	switch args[0] {
+	case "new":
+		c, err := vc.NewCeremony(uint8(*ell), *mn)
+		if err != nil {
+			ceremonyFail(err)
+		}
+		if err = c.Save(*out); err != nil {
+			ceremonyFail(err)
+		}
+	case "contribute":
+		c := ceremonyLoad(*in)
+		c.Contribute()
+		if err := c.Save(*out); err != nil {
+			ceremonyFail(err)
+		}
+		fmt.Println("Contribution", len(c.Contributions), "saved to", *out)
+	case "verify":
+		c := ceremonyLoad(*in)
+		if err := c.Verify(); err != nil {
+			ceremonyFail(err)
+		}
+		fmt.Println("Ceremony with", len(c.Contributions), "contributions ... OK")
+	case "finalize":
+		c := ceremonyLoad(*in)
+		folderPath := *folder
+		if folderPath == "" {
+			folderPath = fmt.Sprintf("pkvk-%02d", c.L)
+		}
+		if err := c.Finalize(folderPath); err != nil {
+			ceremonyFail(err)
+		}
+	default:
+		ceremonyFail(fmt.Errorf("unknown subcommand %q", args[0]))
+	}
+}
+
+func ceremonyLoad(fileName string) *vc.Ceremony {
+	c, err := vc.LoadCeremony(fileName)
+	if err != nil {
+		ceremonyFail(err)
+	}
+	return c
+}
+
+func ceremonyFail(err error) {
+	fmt.Fprintln(os.Stderr, "ceremony:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	vc "github.com/ethan-du-toit/hyperproofs-go/vcs"
+)
+
+// One-time trusted setup.
+// Usage: hyperproofs-go setup [-ell 16] [-txn 128] [-folder pkvk-16] [-ncores 16] [-keep-trapdoors]
+// Writes the public parameters to the folder and destroys the trapdoors, unless -keep-trapdoors is given.
+// Provers, verifiers and proof serving nodes then load the folder with KeyGenLoad.
+func setupCommand(args []string) {
+
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	ell := fs.Uint("ell", 16, "Vector has 2^ell entries")
+	txnLimit := fs.Uint64("txn", 128, "Number of txns in a block that needs to be aggregated")
+	folder := fs.String("folder", "", "Output folder (default pkvk-<ell>)")
+	ncores := fs.Uint("ncores", 16, "Maximum number of threads")
+	keep := fs.Bool("keep-trapdoors", false, "Keep trapdoors.data. Only for benchmarks with fake keys")
+	fs.Parse(args)
+
+	folderPath := *folder
+	if folderPath == "" {
+		folderPath = fmt.Sprintf("pkvk-%02d", *ell)
+	}
+
+	vcs := vc.VCS{}
+	if *keep {
+		vcs.KeyGen(uint8(*ncores), uint8(*ell), folderPath, *txnLimit)
+	} else if err := vcs.Setup(uint8(*ncores), uint8(*ell), folderPath, *txnLimit); err != nil {
+		fmt.Fprintln(os.Stderr, "setup:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Setup ... Done:", folderPath)
+}
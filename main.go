@@ -11,7 +11,6 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"strconv"
 	"testing"
 	"time"
 
@@ -23,10 +22,8 @@ const FOLDER = "./pkvk-02"
 
 var transactionData = make(map[int][]int)
 
-const addrOffset int = 43
-const nonceOffset int = 22
-const valOffset int = 1
-const padding int = 0x0
+// Entries of the state vector are account records [address, nonce, value, padding].
+var accounts = vc.NewAccountCodec(vc.DEFAULT_ACCOUNT_LAYOUT...)
 
 func main() {
 	testing.Init()
@@ -40,6 +37,19 @@ func main() {
 
 	args := os.Args
 
+	if len(args) > 1 && args[1] == "setup" {
+		setupCommand(args[2:])
+		return
+	}
+	if len(args) > 1 && args[1] == "ceremony" {
+		ceremonyCommand(args[2:])
+		return
+	}
+	if len(args) > 1 && args[1] == "audit" {
+		auditCommand(args[2:])
+		return
+	}
+
 	if len(args) == 1 {
 		//L := uint8(4)
 		//_ =  hyperGenerateKeys(L, false)
@@ -75,7 +85,7 @@ func slicingVCS(L uint8, txnLimit uint64) {
 		fmt.Println("Before")
 		for i := uint64(0); i < 3; i++ {
 			fmt.Println(valueVec[i])
-			extractField(valueVec, i, valOffset, "Value")
+			extractField(valueVec, i, "value")
 		}
 
 		// 3. Verify transactions
@@ -97,7 +107,7 @@ func slicingVCS(L uint8, txnLimit uint64) {
 		fmt.Println("After")
 		for i := uint64(0); i < 3; i++ {
 			fmt.Println(valueVec[i])
-			extractField(valueVec, i, valOffset, "Value")
+			extractField(valueVec, i, "value")
 		}
 
 		fmt.Println(vc.SEP)
@@ -108,6 +118,7 @@ func slicingVCS(L uint8, txnLimit uint64) {
 // This function generates transactions.
 func generateTransactions(vcs vc.VCS, aFr []mcl.Fr, K uint64, N uint64) ([]uint64, [][]mcl.G1, []mcl.Fr, []mcl.Fr) {
 	const fixedSeed = 42
+	const maxDraws = 100
 	r := rand.New(rand.NewSource(fixedSeed))
 
 	indexVec := make([]uint64, K)   // List of indices that changed.
@@ -116,16 +127,27 @@ func generateTransactions(vcs vc.VCS, aFr []mcl.Fr, K uint64, N uint64) ([]uint6
 	valueVec := make([]mcl.Fr, K)   // Current value in that position.
 
 	for k := uint64(0); k < K; k++ {
-		indexVec[k] = uint64(r.Intn(int(N)))
-		// proofVec[k] = vcs.GetProofPath(indexVec[k])
-		valDelta := rand.Intn(1000) << valOffset
-		addressChange := 0x0 << addrOffset
-		incrementNonce := 0x1 << nonceOffset
-		delta := int64(addressChange ^ incrementNonce ^ valDelta ^ padding)
+		// A transaction that would overflow a field of the account is dropped and drawn again,
+		// so deltaVec and transactionData always describe the same updates.
+		var valDelta int
+		var delta mcl.Fr
+		for draw := 0; ; draw++ {
+			if draw == maxDraws {
+				panic(fmt.Sprintf("generateTransactions: no valid transaction in %d draws", maxDraws))
+			}
+			indexVec[k] = uint64(r.Intn(int(N)))
+			// proofVec[k] = vcs.GetProofPath(indexVec[k])
+			valDelta = rand.Intn(1000)
+			var err error
+			delta, _, err = accounts.Delta(aFr[indexVec[k]], vc.AddTo("value", uint64(valDelta)), vc.Increment("nonce"))
+			if err == nil {
+				break
+			}
+			fmt.Println("Dropped transaction:", err)
+		}
 
-		deltaVec[k].SetInt64(delta)
+		deltaVec[k] = delta
 		valueVec[k] = aFr[indexVec[k]]
-		valDelta = valDelta >> valOffset
 
 		index := int(indexVec[k])
 		for i := 0; i < 4; i++ {
@@ -379,34 +401,54 @@ func printFullState(aFr []mcl.Fr) {
 	fmt.Println("Full State Snapshot:")
 	for i := uint64(0); i < uint64(len(aFr)); i++ {
 		fmt.Printf("Account[%d]: ", i)
-		extractField(aFr, i, addrOffset, "Address")
-		extractField(aFr, i, nonceOffset, "Nonce")
-		extractField(aFr, i, valOffset, "Value")
+		extractField(aFr, i, "address")
+		extractField(aFr, i, "nonce")
+		extractField(aFr, i, "value")
 		fmt.Println("---")
 	}
 }
 
 // This function extracts a field from the state vector.
-// The structure of the state vector is [address, nonce, value, padding].
-//
-//	21       21     21     1
-func extractField(aFr []mcl.Fr, index uint64, offset int, fieldName string) {
-	var mask int64 = ((1 << 21) - 1) << offset
-
-	val, err := strconv.ParseInt(aFr[index].GetString(10), 10, 64)
+// The structure of the state vector is [address, nonce, value, padding], see vc.DEFAULT_ACCOUNT_LAYOUT.
+func extractField(aFr []mcl.Fr, index uint64, fieldName string) {
+	val, err := accounts.Get(aFr[index], fieldName)
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
 	}
-	fmt.Printf("%s: %d\n", fieldName, (val&mask)>>offset)
+	fmt.Printf("%s: %d\n", fieldName, val)
 }
 
-// This function initializes the VCS and generates a random vector.
+// This function generates a vector of accounts with random values.
+func generateAccounts(N uint64) []mcl.Fr {
+	aFr := make([]mcl.Fr, N)
+	for i := uint64(0); i < N; i++ {
+		var err error
+		aFr[i], err = accounts.Encode([]uint64{i & (1<<21 - 1), 0, uint64(rand.Intn(1000)), 0})
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+	return aFr
+}
+
+// This function writes the vector next to the keys.
+func saveVector(aFr []mcl.Fr) {
+	store, err := vc.CreateVectorStore(FOLDER, aFr, vc.NFILES)
+	if err == nil {
+		err = store.Close()
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// This function initializes the VCS and generates a vector of accounts.
 func initializeVCS(L uint8, K uint64, N uint64) (vc.VCS, []mcl.Fr) {
 	vcs := vc.VCS{}
 	vcs.KeyGenLoad(16, L, FOLDER, K)
-	aFr := vc.GenerateVector(N)
-	vc.SaveVector(N, aFr)
+	aFr := generateAccounts(N)
+	saveVector(aFr)
 	vcs.OpenAll(aFr)
 	return vcs, aFr
 }
@@ -452,7 +494,7 @@ func BenchmarkVCSCommit(L uint8, txnLimit uint64) string {
 	vcs.KeyGenLoad(16, L, FOLDER, K)
 
 	aFr := vc.GenerateVector(N)
-	vc.SaveVector(N, aFr)
+	saveVector(aFr)
 	dt := time.Now()
 	vcs.Commit(aFr, uint64(L))
 
@@ -497,92 +539,3 @@ func hyperLoadKeys(L uint8) *vc.VCS {
 	fmt.Println("KeyGenLoad ... Done")
 	return &vcs
 }
-
-// Input: si: the state to be queried, k: the index of the account to be queried
-// Output: the value in account k at state si
-// Assume snapshots are sorted in lexicographic order which represents the order of the states.
-// func RetrieveStateData(si []mcl.Fr, k uint64, snapshots [][]mcl.Fr, indexVec []uint64, deltaVec []mcl.Fr) mcl.Fr {
-// 	// Step 1: Finds the state sj
-// 	sj := findClosestSnapshot(si, snapshots)
-
-// 	// Step 2: Recovers all transactions between si and sj, generates a snapshot for the intermidiate state
-// 	T_delta := recoverTransactions(si, sj, indexVec, deltaVec)
-
-// 	// Step 3: Computes the value of the kth position
-// 	var result mcl.Fr
-// 	mcl.FrAdd(&result, &sj[k], &T_delta[k])
-// 	return result
-// }
-
-// // This function uses binary search to find the closest snapshot to the given state.
-// func findClosestSnapshot(si []mcl.Fr, snapshots [][]mcl.Fr) []mcl.Fr {
-// 	low, high := 0, len(snapshots)-1
-// 	closest := snapshots[0]
-// 	for low <= high {
-// 		mid := low + (high-low)/2
-// 		if compareSnapshots(snapshots[mid], si) <= 0 {
-// 			closest = snapshots[mid]
-// 			low = mid + 1
-// 		} else {
-// 			high = mid - 1
-// 		}
-// 	}
-// 	return closest
-// }
-
-// This function comapres two snapshots according to the lexicographic order.
-// func compareSnapshots(a, b []mcl.Fr) int {
-// 	n := len(a)
-// 	for i := 0; i < n; i++ {
-// 		s1 := a[i].GetString(10)
-// 		s2 := b[i].GetString(10)
-// 		if s1 < s2 {
-// 			return -1
-// 		} else if s1 > s2 {
-// 			return 1
-// 		}
-// 	}
-// 	return 0
-// }
-
-// This function recovers all transactions between two states.
-// How to check whether a transacton happened between two states?
-// func recoverTransactions(si, sj []mcl.Fr, indexVec []uint64, deltaVec []mcl.Fr) []mcl.Fr {
-// 	T_delta := make([]mcl.Fr, len(sj))
-// 	for i := range T_delta {
-// 		T_delta[i].SetInt64(0)
-// 	}
-
-// 	for i := 0; i < len(indexVec); i++ {
-// 		account := indexVec[i]
-// 		mcl.FrAdd(&T_delta[account], &T_delta[account], &deltaVec[i])
-// 	}
-// 	return T_delta
-// }
-
-// Input: si: a state si to be queried of the verifier's choice
-// Output: A state proof for the query
-// func GetStateProof(vcs vcs.VCS, si []mcl.Fr, snapshots [][]mcl.Fr, indexVec []uint64, deltaVec []mcl.Fr) {
-// 	// Step1: Chooses a random position k
-// 	k := uint64(rand.Intn(len(si)))
-
-// 	// Step2: Finds the closest snapshot and calculates the delta between two states
-// 	sj := findClosestSnapshot(si, snapshots)
-// 	T_delta := recoverTransactions(si, sj, indexVec, deltaVec)
-
-// 	// Step3: Computes the proof path for the kth position
-// 	si_prime := make([]mcl.Fr, len(si))
-// 	for i := range si {
-// 		mcl.FrAdd(&si_prime[i], &sj[i], &T_delta[i])
-// 	}
-// 	digest := vcs.Commit(si_prime, uint64(len(si_prime)))
-// 	proofPath := vcs.GetProofPath(k)
-
-// 	// Step4: The verifier verifies the proof
-// 	status := vcs.Verify(digest, k, si_prime[k], proofPath)
-// 	if status {
-// 		fmt.Println("State proof is valid ✅")
-// 	} else {
-// 		fmt.Println("State proof is invalid ❌")
-// 	}
-// }
@@ -14,7 +14,7 @@ func (vcs *VCS) PrkGen(index uint8, start uint64, stop uint64, wg *sync.WaitGrou
 
 	os.MkdirAll(vcs.folderPath, os.ModePerm)
 	fileName := vcs.folderPath + fmt.Sprintf(PRKNAME, index)
-	f, err := os.Create(fileName)
+	f, err := createKeyFile(fileName, vcs.newFileHeader(FILE_PRK, NFILES, index, start, stop))
 	check(err)
 
 	var result mcl.G1
@@ -28,8 +28,8 @@ func (vcs *VCS) PrkGen(index uint8, start uint64, stop uint64, wg *sync.WaitGrou
 		}
 		// fmt.Println(i)
 	}
+	check(f.Close())
 	fmt.Println("Dumped ", fileName, BoundsPrint(start, stop))
-	defer f.Close()
 	defer wg.Done()
 }
 
@@ -43,13 +43,14 @@ func (vcs *VCS) PrkGenDriver() {
 	step := uint64(math.Ceil(float64(vcs.N) / float64(NFILES))) // Maximum size of each file.
 
 	start := uint64(0)
-	stop := step
+	stop := minUint64(step, vcs.N)
 	for i := uint8(0); i < NFILES; i++ {
 		wg.Add(1)
 		go vcs.PrkGen(i, start, stop, &wg)
 
-		start += step
-		stop += step
+		// Chunk ranges are recorded in the file headers, so keep them within [0, N).
+		start = minUint64(start+step, vcs.N)
+		stop = minUint64(stop+step, vcs.N)
 		if (i+1)%NCORES == 0 {
 			wg.Wait()
 		}
@@ -61,7 +62,7 @@ func (vcs *VCS) UpkGen(index uint8, start uint64, stop uint64, wg *sync.WaitGrou
 
 	os.MkdirAll(vcs.folderPath, os.ModePerm)
 	fileName := vcs.folderPath + fmt.Sprintf(UPKNAME, index)
-	f, err := os.Create(fileName)
+	f, err := createKeyFile(fileName, vcs.newFileHeader(FILE_UPK, NFILES, index, start, stop))
 	check(err)
 	// fmt.Println(fileName)
 	var result mcl.G1
@@ -78,8 +79,8 @@ func (vcs *VCS) UpkGen(index uint8, start uint64, stop uint64, wg *sync.WaitGrou
 		// fmt.Println(i, k, exponent.IsZero(), result.IsZero(), vcs.PRK[i][k].IsZero())
 	}
 
+	check(f.Close())
 	fmt.Println("Dumped ", fileName, BoundsPrint(start, stop))
-	defer f.Close()
 	defer wg.Done()
 }
 
@@ -95,16 +96,15 @@ func (vcs *VCS) UpkGenDriver() {
 	numUPK := (uint64(1) << (vcs.L + 1)) - 1 // Number of nodes in the UPK tree
 	step := uint64(math.Ceil(float64(numUPK) / float64(NFILES)))
 	start := uint64(0)
-	stop := step
+	stop := minUint64(step, numUPK)
 
 	for i := uint8(0); i < NFILES; i++ {
 		// fmt.Println(i, start, stop)
 		wg.Add(1)
 		go vcs.UpkGen(i, start, stop, &wg)
 
-		start += step
-		stop += step
-		stop = minUint64(stop, numUPK)
+		start = minUint64(start+step, numUPK)
+		stop = minUint64(stop+step, numUPK)
 
 		if (i+1)%NCORES == 0 {
 			wg.Wait()
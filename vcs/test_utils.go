@@ -80,6 +80,16 @@ func GenerateVector(N uint64) []mcl.Fr {
 	return aFr
 }
 
+// Vector of field elements derived from seed. Same seed, same vector.
+func GenerateVectorSeeded(N uint64, seed []byte) []mcl.Fr {
+	aFr := make([]mcl.Fr, N)
+	for i := uint64(0); i < N; i++ {
+		aFr[i] = hashToFr(SEEDED_SETUP_DST, seed, "vector", i)
+	}
+	return aFr
+}
+
+// Deprecated: SaveVector always writes to pkvk/Vec.data. Use CreateVectorStore.
 func SaveVector(N uint64, aFr []mcl.Fr) {
 	folderPath := "pkvk/"
 	os.MkdirAll(folderPath, os.ModePerm)
@@ -102,6 +112,7 @@ func SaveVector(N uint64, aFr []mcl.Fr) {
 	defer f.Close()
 }
 
+// Deprecated: LoadVector reads the whole vector into memory. Use OpenVectorStore.
 func LoadVector(N uint64, folderPath string) []mcl.Fr {
 
 	fileName := folderPath + "/Vec.data"
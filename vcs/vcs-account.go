@@ -0,0 +1,192 @@
+package vcs
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/alinush/go-mcl"
+)
+
+// At most this many bits are packed in one Fr. Every integer below 2^254 is an element of Fr,
+// so an encoded account never wraps around the modulus.
+const ACCOUNT_MAX_BITS = 254
+
+// A field of an account record: a name and its width in bits, at most 64.
+type AccountField struct {
+	Name string
+	Bits uint
+}
+
+// Layout main.go uses: [address, nonce, value, padding] with 21, 21, 21 and 1 bits.
+var DEFAULT_ACCOUNT_LAYOUT = []AccountField{
+	{"address", 21},
+	{"nonce", 21},
+	{"value", 21},
+	{"padding", 1},
+}
+
+// Packs account records in one entry of the vector.
+// The fields are listed from the most significant one, and the record is the integer
+//
+//	field[0] || field[1] || ... || field[n-1]
+//
+// Changes to a record are turned into the delta that UpdateCom, UpdateComVec and UpdateProofTree take,
+// see Delta. Each field is checked for overflow, so a change never spills into the next field.
+type AccountCodec struct {
+	fields []AccountField
+	shift  []uint // Position of the least significant bit of each field
+	index  map[string]int
+	bits   uint
+}
+
+// Operation of an AccountChange.
+const (
+	ACCOUNT_ADD = 0
+	ACCOUNT_SUB = 1
+	ACCOUNT_SET = 2
+)
+
+// A change to one field of an account record. See AddTo, SubFrom, SetTo and Increment.
+type AccountChange struct {
+	Field  string
+	Op     uint8
+	Amount uint64
+}
+
+func AddTo(field string, amount uint64) AccountChange {
+	return AccountChange{field, ACCOUNT_ADD, amount}
+}
+
+func SubFrom(field string, amount uint64) AccountChange {
+	return AccountChange{field, ACCOUNT_SUB, amount}
+}
+
+func SetTo(field string, value uint64) AccountChange {
+	return AccountChange{field, ACCOUNT_SET, value}
+}
+
+// For nonces.
+func Increment(field string) AccountChange {
+	return AddTo(field, 1)
+}
+
+func NewAccountCodec(fields ...AccountField) *AccountCodec {
+	c, err := TryNewAccountCodec(fields...)
+	check(err)
+	return c
+}
+
+// Same as NewAccountCodec, but an invalid layout is returned as an error.
+func TryNewAccountCodec(fields ...AccountField) (*AccountCodec, error) {
+	c := AccountCodec{fields: fields, shift: make([]uint, len(fields)), index: make(map[string]int)}
+	for i := len(fields) - 1; i >= 0; i-- {
+		f := fields[i]
+		if f.Name == "" || f.Bits == 0 || f.Bits > 64 {
+			return nil, fmt.Errorf("%w: account field %d %q of %d bits", ErrInvalidParam, i, f.Name, f.Bits)
+		}
+		if _, ok := c.index[f.Name]; ok {
+			return nil, fmt.Errorf("%w: account field %q is declared twice", ErrInvalidParam, f.Name)
+		}
+		c.index[f.Name] = i
+		c.shift[i] = c.bits
+		c.bits += f.Bits
+	}
+	if c.bits > ACCOUNT_MAX_BITS {
+		return nil, fmt.Errorf("%w: account layout of %d bits, at most %d fit in Fr", ErrInvalidParam, c.bits, ACCOUNT_MAX_BITS)
+	}
+	return &c, nil
+}
+
+func (c *AccountCodec) Fields() []AccountField {
+	return c.fields
+}
+
+// Packs values, one per field in layout order.
+func (c *AccountCodec) Encode(values []uint64) (mcl.Fr, error) {
+	var x mcl.Fr
+	if len(values) != len(c.fields) {
+		return x, fmt.Errorf("%w: %d values for %d account fields", ErrInvalidParam, len(values), len(c.fields))
+	}
+	acc := new(big.Int)
+	v := new(big.Int)
+	for i, f := range c.fields {
+		if f.Bits < 64 && values[i]>>f.Bits != 0 {
+			return x, fmt.Errorf("%w: %s is %d, at most %d bits", ErrFieldOverflow, f.Name, values[i], f.Bits)
+		}
+		acc.Or(acc, v.Lsh(v.SetUint64(values[i]), c.shift[i]))
+	}
+	if err := x.SetString(acc.Text(16), 16); err != nil {
+		return x, err
+	}
+	return x, nil
+}
+
+// Unpacks a record. An element of Fr that is not below 2^bits of the layout is not a record.
+func (c *AccountCodec) Decode(x mcl.Fr) ([]uint64, error) {
+	acc := x.ToBigInt()
+	if acc.BitLen() > int(c.bits) {
+		return nil, fmt.Errorf("%w: entry of %d bits, account layout of %d bits", ErrInvalidEncoding, acc.BitLen(), c.bits)
+	}
+	values := make([]uint64, len(c.fields))
+	v := new(big.Int)
+	mask := new(big.Int)
+	for i, f := range c.fields {
+		mask.Sub(mask.Lsh(big.NewInt(1), f.Bits), big.NewInt(1))
+		values[i] = v.And(v.Rsh(acc, c.shift[i]), mask).Uint64()
+	}
+	return values, nil
+}
+
+// One field of a record.
+func (c *AccountCodec) Get(x mcl.Fr, field string) (uint64, error) {
+	i, ok := c.index[field]
+	if !ok {
+		return 0, fmt.Errorf("%w: no account field %q", ErrInvalidParam, field)
+	}
+	values, err := c.Decode(x)
+	if err != nil {
+		return 0, err
+	}
+	return values[i], nil
+}
+
+// Applies changes to the record current, in order, and returns the delta to hand to UpdateCom and UpdateProofTree
+// together with the new record. A change that leaves a field out of range fails with ErrFieldOverflow.
+func (c *AccountCodec) Delta(current mcl.Fr, changes ...AccountChange) (mcl.Fr, mcl.Fr, error) {
+	var delta, updated mcl.Fr
+	values, err := c.Decode(current)
+	if err != nil {
+		return delta, updated, err
+	}
+	for _, ch := range changes {
+		i, ok := c.index[ch.Field]
+		if !ok {
+			return delta, updated, fmt.Errorf("%w: no account field %q", ErrInvalidParam, ch.Field)
+		}
+		limit := ^uint64(0) >> (64 - c.fields[i].Bits)
+		switch ch.Op {
+		case ACCOUNT_ADD:
+			if ch.Amount > limit-values[i] {
+				return delta, updated, fmt.Errorf("%w: %s + %d exceeds %d bits", ErrFieldOverflow, ch.Field, ch.Amount, c.fields[i].Bits)
+			}
+			values[i] += ch.Amount
+		case ACCOUNT_SUB:
+			if ch.Amount > values[i] {
+				return delta, updated, fmt.Errorf("%w: %s - %d is negative", ErrFieldOverflow, ch.Field, ch.Amount)
+			}
+			values[i] -= ch.Amount
+		case ACCOUNT_SET:
+			if ch.Amount > limit {
+				return delta, updated, fmt.Errorf("%w: %s is %d, at most %d bits", ErrFieldOverflow, ch.Field, ch.Amount, c.fields[i].Bits)
+			}
+			values[i] = ch.Amount
+		default:
+			return delta, updated, fmt.Errorf("%w: account change %d", ErrInvalidParam, ch.Op)
+		}
+	}
+	if updated, err = c.Encode(values); err != nil {
+		return delta, updated, err
+	}
+	mcl.FrSub(&delta, &updated, &current)
+	return delta, updated, nil
+}
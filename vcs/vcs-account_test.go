@@ -0,0 +1,93 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestAccountCodec(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestVCS(t, L, 2)
+	accounts := NewAccountCodec(AccountField{"address", 64}, AccountField{"nonce", 64}, AccountField{"value", 64})
+
+	t.Run(fmt.Sprintf("%d/RoundTrip;", L), func(t *testing.T) {
+		for _, values := range [][]uint64{{0, 0, 0}, {1, 2, 3}, {^uint64(0), ^uint64(0), ^uint64(0)}} {
+			x, err := accounts.Encode(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := accounts.Decode(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range values {
+				if got[i] != values[i] {
+					t.Errorf("Field %d is %d, want %d", i, got[i], values[i])
+				}
+			}
+		}
+		var x mcl.Fr
+		x.SetInt64(-1)
+		if _, err := accounts.Decode(x); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("Entry beyond the layout: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Layout;", L), func(t *testing.T) {
+		bad := [][]AccountField{
+			{{"a", 0}},
+			{{"a", 65}},
+			{{"a", 8}, {"a", 8}},
+			{{"a", 64}, {"b", 64}, {"c", 64}, {"d", 63}},
+		}
+		for i := range bad {
+			if _, err := TryNewAccountCodec(bad[i]...); !errors.Is(err, ErrInvalidParam) {
+				t.Errorf("Layout %d: got %v", i, err)
+			}
+		}
+		small := NewAccountCodec(DEFAULT_ACCOUNT_LAYOUT...)
+		if _, err := small.Encode([]uint64{0, 0, 1 << 21, 0}); !errors.Is(err, ErrFieldOverflow) {
+			t.Errorf("Value of 22 bits: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Delta;", L), func(t *testing.T) {
+		aFr := make([]mcl.Fr, vcs.N)
+		for i := range aFr {
+			aFr[i], _ = accounts.Encode([]uint64{uint64(i), 0, 1000})
+		}
+		digest := vcs.Commit(aFr, uint64(L))
+		vcs.OpenAll(aFr)
+
+		index := uint64(6)
+		delta, updated, err := accounts.Delta(aFr[index], SubFrom("value", 250), Increment("nonce"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, _ := accounts.Decode(updated)
+		if values[0] != index || values[1] != 1 || values[2] != 750 {
+			t.Errorf("Updated account is %v", values)
+		}
+		digest = vcs.UpdateCom(digest, index, delta)
+		vcs.UpdateProofTree(index, delta)
+		proof := vcs.GetProofPath(vcs.ProofTree, index, L)
+		if !vcs.Verify(digest, index, updated, proof) {
+			t.Errorf("Verification failed after the account change")
+		}
+
+		if _, _, err := accounts.Delta(updated, SubFrom("value", 751)); !errors.Is(err, ErrFieldOverflow) {
+			t.Errorf("Negative value: got %v", err)
+		}
+		full, _ := accounts.Encode([]uint64{0, ^uint64(0), 0})
+		if _, _, err := accounts.Delta(full, Increment("nonce")); !errors.Is(err, ErrFieldOverflow) {
+			t.Errorf("Nonce overflow: got %v", err)
+		}
+		if _, _, err := accounts.Delta(updated, SetTo("balance", 1)); !errors.Is(err, ErrInvalidParam) {
+			t.Errorf("Unknown field: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package vcs
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+	"github.com/hyperproofs/gipa-go/cm"
+	"github.com/hyperproofs/gipa-go/gipakzg"
+	"github.com/hyperproofs/gipa-go/utils"
+	"golang.org/x/crypto/blake2b"
+)
+
+// An aggregated proof from AggProve, with the digest and the entries it is checked against.
+type AggInstance struct {
+	Proof   batch.Proof
+	Digest  mcl.G1
+	Indices []uint64
+	Values  []mcl.Fr
+}
+
+// Pairing terms of the checks of AggVerifyBatch, each check weighted by its own random scalar.
+// The GIPA checks of every instance are equations in GT: gt holds the part given by the proofs,
+// and (ps, qs) the pairings, whose Miller loops are all done at the end with a single final exponentiation.
+type aggBatch struct {
+	gt  mcl.GT
+	ps  []mcl.G1
+	qs  []mcl.G2
+	B   []mcl.G2 // Sum of the scaled B of every instance, paired with ck.W
+	Z   mcl.G1   // Sum of the scaled P of every instance, paired with H
+	W1  mcl.G1   // KZG1 openings, paired with kzg1.VK[0]
+	Pi1 mcl.G1   // Paired with -kzg1.VK[1]
+	V2  mcl.G2   // KZG2 openings, paired with kzg2.VK[0]
+	Pi2 mcl.G2   // Paired with -kzg2.VK[1]
+	PK1 mcl.G1   // kzg1.PK[0]
+	PK2 mcl.G2   // kzg2.PK[0]
+}
+
+// Checks many aggregated proofs at once, e.g. the proofs of the blocks a node syncs.
+//
+// Every instance is checked as in AggVerify, but the checks are combined with random scalars,
+// so there is one multi-pairing and one final exponentiation for all of them.
+// The keys of a smaller instance are a prefix of the keys of a larger one, so the pairings with ck.W,
+// the bulk of the work of AggVerify, are done once for the largest instance.
+// If the combined check fails, the instances are checked one by one, and the index of the first one that fails is returned.
+// Otherwise, the index is -1.
+func (vcs *VCS) AggVerifyBatch(instances []AggInstance) (bool, int) {
+
+	if len(instances) == 0 {
+		panic("AggVerifyBatch: No instances")
+	}
+	var maxMN uint64
+	for k := range instances {
+		n := len(instances[k].Indices)
+		if n == 0 || len(instances[k].Values) != n {
+			panic(fmt.Sprintf("AggProof: Vectors of instance %d are not of the expected size", k))
+		}
+		if mn := vcs.aggSize(n); mn > maxMN {
+			maxMN = mn
+		}
+	}
+	ck, kzg1, kzg2, err := vcs.aggKeys(maxMN)
+	check(err)
+
+	ab := aggBatch{B: make([]mcl.G2, maxMN), PK1: kzg1.PK[0], PK2: kzg2.PK[0]}
+	ab.gt.SetInt64(1)
+	for k := range instances {
+		vcs.aggBatchAdd(&ab, &instances[k])
+	}
+
+	var p mcl.G1
+	ab.ps = append(ab.ps, ck.W...)
+	ab.qs = append(ab.qs, ab.B...)
+	ab.ps = append(ab.ps, ab.Z, ab.W1)
+	ab.qs = append(ab.qs, vcs.H, kzg1.VK[0])
+	mcl.G1Neg(&p, &ab.Pi1)
+	ab.ps = append(ab.ps, p, kzg2.VK[0])
+	ab.qs = append(ab.qs, kzg1.VK[1], ab.V2)
+	mcl.G1Neg(&p, &kzg2.VK[1])
+	ab.ps = append(ab.ps, p)
+	ab.qs = append(ab.qs, ab.Pi2)
+
+	var e mcl.GT
+	millerLoopParallel(&e, ab.ps, ab.qs, int(NCORES))
+	mcl.FinalExp(&e, &e)
+	mcl.GTMul(&e, &e, &ab.gt)
+	if e.IsOne() {
+		return true, -1
+	}
+
+	for k := range instances {
+		inst := &instances[k]
+		if !vcs.AggVerify(inst.Proof, inst.Digest, inst.Indices, inst.Values) {
+			return false, k
+		}
+	}
+	return true, -1
+}
+
+// Adds the checks of inst to ab. The transcript is the one of batch.Verifier.VerifyEdrax, and the checks are:
+//   - the folded commitment (T, U, Z) against (e(A, V), e(W, B), e(A, B)) from the last round, and
+//   - the KZG openings of W at a and of V at b.
+func (vcs *VCS) aggBatchAdd(ab *aggBatch, inst *AggInstance) {
+
+	n := len(inst.Indices)
+	L := int(vcs.L)
+	mn := vcs.aggSize(n)
+	proof := &inst.Proof
+	p := &proof.GipaKzgProof
+
+	rho := make([]mcl.Fr, 5)
+	for i := range rho {
+		rho[i].Random()
+	}
+
+	var bv batch.Verifier
+	r := bv.FiatShamir(bv.Verifier.Transcript[:], proof.T)
+
+	// U = <W, B> with B as in AggVerifyMultiDigest, entry t in block t + 1 after the dummy row
+	B := make([]mcl.G2, L*(n+1))
+	for t := range inst.Indices {
+		copy(B[(t+1)*L:], vcs.aggRowB(inst.Indices[t]))
+	}
+	B = g2VecRandExpoScaled(B, r, rho[1], L, int(NCORES))
+	parallelRange(uint64(len(B)), func(start, stop uint64) {
+		for i := start; i < stop; i++ {
+			mcl.G2Add(&ab.B[i], &ab.B[i], &B[i])
+		}
+	})
+
+	// Z = e(sum of P, H), with P[0] the dummy row
+	var q, psum mcl.G1
+	P := make([]mcl.G1, n+1)
+	for t := range inst.Values {
+		mcl.G1Mul(&q, &vcs.G, &inst.Values[t])
+		mcl.G1Sub(&P[t+1], &inst.Digest, &q)
+	}
+	P = utils.G1VecRandExpo(P, r, 1)
+	for t := range P {
+		mcl.G1Add(&psum, &psum, &P[t])
+	}
+	mcl.G1Mul(&q, &psum, &rho[2])
+	mcl.G1Add(&ab.Z, &ab.Z, &q)
+
+	// The rounds, folding the part of the commitment given by the proof: (T, 1, 1)
+	gv := gipakzg.Verifier{Transcript: bv.Verifier.Transcript}
+	com := cm.Com{}
+	com.Com[0] = proof.T
+	com.Com[1].SetInt64(1)
+	com.Com[2].SetInt64(1)
+	rounds := bits.Len64(mn - 1)
+	challenges := make([]mcl.Fr, rounds)
+	for i := range challenges {
+		ComL, ComR := p.At(uint64(i))
+		gv.Update(ComL, ComR)
+		x := gv.FiatShamir()
+		var y mcl.Fr
+		mcl.FrInv(&y, &x)
+		com = cm.ComFold(x, y, &ComL, &com, &ComR)
+		challenges[i] = x
+	}
+	var e mcl.GT
+	for i := range com.Com {
+		mcl.GTPow(&e, &com.Com[i], &rho[i])
+		mcl.GTMul(&ab.gt, &ab.gt, &e)
+	}
+
+	// e(A, V)^-rho0 * e(rho2 * A + rho1 * W, B)^-1
+	var a0, w mcl.G1
+	mcl.G1Mul(&a0, &p.A[0], &rho[0])
+	mcl.G1Neg(&a0, &a0)
+	mcl.G1Mul(&q, &p.A[0], &rho[2])
+	mcl.G1Mul(&w, &p.W, &rho[1])
+	mcl.G1Add(&q, &q, &w)
+	mcl.G1Neg(&q, &q)
+	ab.ps = append(ab.ps, a0, q)
+	ab.qs = append(ab.qs, p.V, p.B[0])
+
+	// Hash(transcript || A || B) and Hash(transcript || proof.Pi1), as gipakzg.Verifier.Verify
+	var a, b mcl.Fr
+	data := append(append(gv.Transcript[:], p.A[0].Serialize()...), p.B[0].Serialize()...)
+	hash := blake2b.Sum256(data)
+	a.SetHashOf(hash[:])
+	data = append(hash[:], p.Pi1.Serialize()...)
+	hash = blake2b.Sum256(data)
+	b.SetHashOf(hash[:])
+	yw := gipakzg.EvaluateHaloPoly(challenges, a, false)
+	yv := gipakzg.EvaluateHaloPoly(challenges, b, true)
+
+	// KZG1: e(W + a * Pi1 - yw * G, VK[0]) = e(Pi1, VK[1])
+	mcl.G1Mul(&q, &p.Pi1, &a)
+	mcl.G1Add(&q, &q, &p.W)
+	mcl.G1Mul(&w, &ab.PK1, &yw)
+	mcl.G1Sub(&q, &q, &w)
+	mcl.G1Mul(&q, &q, &rho[3])
+	mcl.G1Add(&ab.W1, &ab.W1, &q)
+	mcl.G1Mul(&q, &p.Pi1, &rho[3])
+	mcl.G1Add(&ab.Pi1, &ab.Pi1, &q)
+
+	// KZG2: e(VK[0], V - yv * H + b * Pi2) = e(VK[1], Pi2)
+	var v, u mcl.G2
+	mcl.G2Mul(&v, &p.Pi2, &b)
+	mcl.G2Add(&v, &v, &p.V)
+	mcl.G2Mul(&u, &ab.PK2, &yv)
+	mcl.G2Sub(&v, &v, &u)
+	mcl.G2Mul(&v, &v, &rho[4])
+	mcl.G2Add(&ab.V2, &ab.V2, &v)
+	mcl.G2Mul(&v, &p.Pi2, &rho[4])
+	mcl.G2Add(&ab.Pi2, &ab.Pi2, &v)
+}
@@ -0,0 +1,95 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+)
+
+func TestAggVerifyBatch(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestAggVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+
+	// One instance per block, of different sizes, each against the digest of its block.
+	var instances []AggInstance
+	for block, n := range []int{3, 1, 5, 3, 9} {
+		inst := AggInstance{Digest: digest, Indices: make([]uint64, n), Values: make([]mcl.Fr, n)}
+		proofVec := make([][]mcl.G1, n)
+		for t := range inst.Indices {
+			inst.Indices[t] = uint64(3*t+block) % vcs.N
+			inst.Values[t] = aFr[inst.Indices[t]]
+			proofVec[t] = vcs.GetProofPath(vcs.ProofTree, inst.Indices[t], L)
+		}
+		inst.Proof = vcs.AggProve(inst.Indices, proofVec)
+		instances = append(instances, inst)
+
+		updateindexVec := []uint64{uint64(block), 7}
+		deltaVec := GenerateVectorSeeded(uint64(len(updateindexVec)), []byte(fmt.Sprintf("deltas-%d", block)))
+		for t := range updateindexVec {
+			mcl.FrAdd(&aFr[updateindexVec[t]], &aFr[updateindexVec[t]], &deltaVec[t])
+		}
+		digest = vcs.UpdateComVec(digest, updateindexVec, deltaVec)
+		vcs.UpdateProofTreeBulkInPlace(vcs.ProofTree, updateindexVec, deltaVec)
+	}
+
+	t.Run(fmt.Sprintf("%d/Verify;%d", L, len(instances)), func(t *testing.T) {
+		status, failed, err := vcs.TryAggVerifyBatch(instances)
+		if err != nil || !status || failed != -1 {
+			t.Errorf("Batch of %d aggregated proofs failed at %d: %v", len(instances), failed, err)
+		}
+		status, failed = vcs.AggVerifyBatch(instances[1:2])
+		if !status || failed != -1 {
+			t.Errorf("Batch of one aggregated proof failed")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Tamper;", L), func(t *testing.T) {
+		// Instance 1 has a single entry.
+		var one mcl.Fr
+		one.SetInt64(1)
+		for _, k := range []int{0, 1, 2, 4} {
+			for _, u := range []int{0, len(instances[k].Values) - 1} {
+				tampered := append([]AggInstance{}, instances...)
+				tampered[k].Values = append([]mcl.Fr{}, tampered[k].Values...)
+				mcl.FrAdd(&tampered[k].Values[u], &tampered[k].Values[u], &one)
+				if status, failed := vcs.AggVerifyBatch(tampered); status || failed != k {
+					t.Errorf("Wrong value at entry %d of instance %d: got %v at %d", u, k, status, failed)
+				}
+			}
+		}
+		tampered := append([]AggInstance{}, instances...)
+		tampered[3].Digest = tampered[2].Digest
+		if status, failed := vcs.AggVerifyBatch(tampered); status || failed != 3 {
+			t.Errorf("Digest of another block: got %v at %d", status, failed)
+		}
+		// Instances 0 and 3 have the same size, so their proofs have as many rounds.
+		tampered = append([]AggInstance{}, instances...)
+		tampered[0].Proof, tampered[3].Proof = tampered[3].Proof, tampered[0].Proof
+		if status, failed := vcs.AggVerifyBatch(tampered); status || failed != 0 {
+			t.Errorf("Swapped proofs: got %v at %d", status, failed)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Errors;", L), func(t *testing.T) {
+		if _, _, err := vcs.TryAggVerifyBatch(nil); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("No instances: got %v", err)
+		}
+		malformed := append([]AggInstance{}, instances...)
+		malformed[2].Proof = batch.Proof{}
+		if _, _, err := vcs.TryAggVerifyBatch(malformed); !errors.Is(err, ErrBadProofLength) {
+			t.Errorf("Proof without rounds: got %v", err)
+		}
+		malformed = append([]AggInstance{}, instances...)
+		malformed[1].Values = nil
+		if _, _, err := vcs.TryAggVerifyBatch(malformed); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Missing values: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package vcs
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+	"github.com/hyperproofs/gipa-go/cm"
+	"github.com/hyperproofs/gipa-go/gipakzg"
+	"github.com/hyperproofs/gipa-go/utils"
+	"github.com/hyperproofs/kzg-go/fft"
+	"github.com/hyperproofs/kzg-go/kzg"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Same as AggProve, but spread over the given number of workers.
+// A and B are built in parallel, and so are the inner products, the folds and the KZG openings of every GIPA round.
+// The rounds follow batch.Prover and gipakzg.Prover step by step with the same transcript, so the proof is the same as the one of AggProve.
+func (vcs *VCS) AggProveParallel(indexVec []uint64, proofVec [][]mcl.G1, workers int) batch.Proof {
+
+	n := len(indexVec)
+	L := int(vcs.L)
+
+	if n == 0 || len(proofVec) != n {
+		panic("AggProof: Vectors are not of the expected size")
+	}
+	for t := range proofVec {
+		if len(proofVec[t]) != L {
+			panic(fmt.Sprintf("Bad proof: %d", t))
+		}
+	}
+	mn := vcs.aggSize(n)
+	ck, kzg1, kzg2, err := vcs.aggKeys(mn)
+	check(err)
+
+	A := make([]mcl.G1, mn) // Zero in the dummy row and past L * (n + 1)
+	B := make([]mcl.G2, mn)
+	parallelChunks(uint64(n), workers, func(chunk int, start, stop uint64) {
+		for t := start; t < stop; t++ {
+			copy(A[(t+1)*uint64(L):], proofVec[t])
+			copy(B[(t+1)*uint64(L):], vcs.aggRowB(indexVec[t]))
+		}
+	})
+
+	T := innerProdParallel(A, ck.V, workers)
+	return aggProveParallel(vcs.L, ck, kzg1, kzg2, A, B, T, workers)
+}
+
+// e(A[0], B[0]) * ... * e(A[m-1], B[m-1]), with the Miller loops split in at most workers chunks.
+func innerProdParallel(A []mcl.G1, B []mcl.G2, workers int) mcl.GT {
+	var prod mcl.GT
+	millerLoopParallel(&prod, A, B, workers)
+	mcl.FinalExp(&prod, &prod)
+	return prod
+}
+
+// Product of the Miller loops of A[i] and B[i], before the final exponentiation.
+func millerLoopParallel(out *mcl.GT, A []mcl.G1, B []mcl.G2, workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	partial := make([]mcl.GT, workers)
+	for i := range partial {
+		partial[i].SetInt64(1)
+	}
+	parallelChunks(uint64(len(A)), workers, func(chunk int, start, stop uint64) {
+		mcl.MillerLoopVec(&partial[chunk], A[start:stop], B[start:stop])
+	})
+	out.SetInt64(1)
+	for i := range partial {
+		mcl.GTMul(out, out, &partial[i])
+	}
+}
+
+// x * vec1[i] + vec2[i] for every i, as utils.G1Fold.
+func g1FoldParallel(x mcl.Fr, vec1 []mcl.G1, vec2 []mcl.G1, workers int) []mcl.G1 {
+	result := make([]mcl.G1, len(vec1))
+	parallelChunks(uint64(len(vec1)), workers, func(chunk int, start, stop uint64) {
+		for i := start; i < stop; i++ {
+			mcl.G1Mul(&result[i], &vec1[i], &x)
+			mcl.G1Add(&result[i], &result[i], &vec2[i])
+		}
+	})
+	return result
+}
+
+// x * vec1[i] + vec2[i] for every i, as utils.G2Fold.
+func g2FoldParallel(x mcl.Fr, vec1 []mcl.G2, vec2 []mcl.G2, workers int) []mcl.G2 {
+	result := make([]mcl.G2, len(vec1))
+	parallelChunks(uint64(len(vec1)), workers, func(chunk int, start, stop uint64) {
+		for i := start; i < stop; i++ {
+			mcl.G2Mul(&result[i], &vec1[i], &x)
+			mcl.G2Add(&result[i], &result[i], &vec2[i])
+		}
+	})
+	return result
+}
+
+// Same as utils.G2VecRandExpo: block k of m elements is multiplied by r^(2k).
+// Block 0 gets r^0 = 1 and is left as zero like in utils.G2VecRandExpo. It is the dummy row of aggSize, so no entry is lost.
+func g2VecRandExpoParallel(B []mcl.G2, r mcl.Fr, m int, workers int) []mcl.G2 {
+	var one mcl.Fr
+	one.SetInt64(1)
+	return g2VecRandExpoScaled(B, r, one, m, workers)
+}
+
+// Same as g2VecRandExpoParallel, with every block also multiplied by scale.
+func g2VecRandExpoScaled(B []mcl.G2, r mcl.Fr, scale mcl.Fr, m int, workers int) []mcl.G2 {
+	var step mcl.Fr
+	mcl.FrSqr(&step, &r)
+	blocks := uint64(len(B) / m)
+	result := make([]mcl.G2, len(B))
+	parallelChunks(blocks, workers, func(chunk int, start, stop uint64) {
+		if start == 0 {
+			start = 1
+		}
+		base := utils.FrPow(step, int64(start))
+		mcl.FrMul(&base, &base, &scale)
+		for k := start; k < stop; k++ {
+			for i := k * uint64(m); i < (k+1)*uint64(m); i++ {
+				mcl.G2Mul(&result[i], &B[i], &base)
+			}
+			mcl.FrMul(&base, &base, &step)
+		}
+	})
+	return result
+}
+
+// GIPA with KZG of A and B under the keys ck, from T = <A, ck.V>.
+// Follows batch.Prover.Prove and gipakzg.Prover.Prove, with the transcript hashed by their FiatShamir methods.
+func aggProveParallel(L uint8, ck cm.Ck, kzg1 kzg.KZG1Settings, kzg2 kzg.KZG2Settings, A []mcl.G1, B []mcl.G2, T mcl.GT, workers int) batch.Proof {
+
+	var proof batch.Proof
+	proof.T = T
+	var bp batch.Prover
+	r := bp.FiatShamir(bp.Prover.Transcript[:], proof.T)
+	B = g2VecRandExpoParallel(B, r, int(L), workers)
+
+	gp := gipakzg.Prover{Transcript: bp.Prover.Transcript}
+	p := &proof.GipaKzgProof
+	V, W := ck.V, ck.W
+	challenges := make([]mcl.Fr, 0, bits.Len64(ck.M-1))
+	for m := ck.M; m > 1; m /= 2 {
+		h := m / 2
+		A_L, A_R := A[:h], A[h:]
+		B_L, B_R := B[:h], B[h:]
+		V_L, V_R := V[:h], V[h:]
+		W_L, W_R := W[:h], W[h:]
+
+		gp.ComL = cm.Com{Com: [3]mcl.GT{innerProdParallel(A_R, V_L, workers), innerProdParallel(W_R, B_L, workers), innerProdParallel(A_R, B_L, workers)}}
+		gp.ComR = cm.Com{Com: [3]mcl.GT{innerProdParallel(A_L, V_R, workers), innerProdParallel(W_L, B_R, workers), innerProdParallel(A_L, B_R, workers)}}
+		p.Append(gp.ComL, gp.ComR)
+
+		var x, y mcl.Fr
+		x = gp.FiatShamir()
+		mcl.FrInv(&y, &x)
+		challenges = append(challenges, x)
+		A = g1FoldParallel(x, A_R, A_L, workers)
+		B = g2FoldParallel(y, B_R, B_L, workers)
+		V = g2FoldParallel(y, V_R, V_L, workers)
+		W = g1FoldParallel(x, W_R, W_L, workers)
+	}
+	p.A[0] = A[0]
+	p.B[0] = B[0]
+
+	// Hash(transcript || A || B)
+	var a, b mcl.Fr
+	data := append(append(gp.Transcript[:], p.A[0].Serialize()...), p.B[0].Serialize()...)
+	hash := blake2b.Sum256(data)
+	a.SetHashOf(hash[:])
+	fw := gipakzg.BuildHaloPoly(challenges, false)
+	g1MulVecParallel(&p.W, kzg1.PK[:len(fw)], fw, workers)
+	g1MulVecParallel(&p.Pi1, kzg1.PK, kzgQuotient(fw, a), workers)
+	if !p.W.IsEqual(&W[0]) {
+		panic("GIPA KZG Prover: W Commitment key computed using GIPA does not match with HaloPoly evaluation.")
+	}
+
+	// Hash(transcript || proof.Pi1)
+	data = append(hash[:], p.Pi1.Serialize()...)
+	hash = blake2b.Sum256(data)
+	b.SetHashOf(hash[:])
+	fv := gipakzg.BuildHaloPoly(challenges, true)
+	g2MulVecParallel(&p.V, kzg2.PK[:len(fv)], fv, workers)
+	g2MulVecParallel(&p.Pi2, kzg2.PK, kzgQuotient(fv, b), workers)
+	if !p.V.IsEqual(&V[0]) {
+		panic("GIPA KZG Prover: V Commitment key computed using GIPA does not match with HaloPoly evaluation.")
+	}
+	return proof
+}
+
+// Coefficients of poly / (X - x), as in ComputeProofSingle of kzg-go.
+// g1MulVecParallel and g2MulVecParallel take as many keys as coefficients.
+func kzgQuotient(poly []mcl.Fr, x mcl.Fr) []mcl.Fr {
+	divisor := make([]mcl.Fr, 2)
+	mcl.FrNeg(&divisor[0], &x)
+	divisor[1].SetInt64(1)
+	quotient, _ := fft.PolyDiv(poly, divisor)
+	return quotient
+}
@@ -1,17 +1,24 @@
 package vcs
 
 import (
+	"encoding/binary"
 	"fmt"
-	"math"
+	"io"
+	"os"
 
 	"github.com/alinush/go-mcl"
 	"github.com/hyperproofs/gipa-go/batch"
 	"github.com/hyperproofs/gipa-go/cm"
 	"github.com/hyperproofs/gipa-go/utils"
+	"github.com/hyperproofs/kzg-go/kzg"
 )
 
+// Needs alpha and beta, so run it during setup, before DestroyTrapdoors.
 func (vcs *VCS) GenAggGipa() {
 
+	if vcs.alpha.IsZero() || vcs.beta.IsZero() {
+		panic("GenAggGipa: alpha and beta are not available. Trapdoors are destroyed or were never loaded.")
+	}
 	{
 		mn := uint64(MAX_AGG_SIZE) // short circuiting things
 		ck, kzg1, kzg2 := cm.IPPSetupKZG(mn, vcs.alpha, vcs.beta, vcs.G, vcs.H)
@@ -21,122 +28,249 @@ func (vcs *VCS) GenAggGipa() {
 }
 
 func (self *VCS) LoadAggGipa() {
+	check(self.TryLoadAggGipa())
+}
 
-	L := uint64(self.L)
-	limit := L * self.TxnLimit
+// Same as LoadAggGipa, but a missing or short CK.data/KZG.data is reported as an error.
+func (self *VCS) TryLoadAggGipa() error {
+	return self.tryLoadAggKeys(self.aggSize(int(self.TxnLimit)))
+}
 
-	self.MN = utils.NextPowOf2(limit)
+// Loads the aggregation keys for instances of size up to mn from the folder.
+func (self *VCS) tryLoadAggKeys(mn uint64) error {
+	ck, kzg1, kzg2, err := self.readAggKeys(mn)
+	if err != nil {
+		return err
+	}
+	self.setAggKeys(mn, ck, kzg1, kzg2)
+	return nil
+}
 
-	self.ck, self.kzg1, self.kzg2 = cm.IPPCMLoadCmKzg(self.MN, self.folderPath)
-	self.aggProver = batch.Prover{}
-	self.aggVerifier = batch.Verifier{}
+func (self *VCS) readAggKeys(mn uint64) (ck cm.Ck, kzg1 kzg.KZG1Settings, kzg2 kzg.KZG2Settings, err error) {
 
-	self.nDiff = int64(uint64(math.Ceil(float64(self.MN)/float64(L))) - self.TxnLimit) // This is the size of padding for P and Q vector (gipa)
-	self.mnDiff = int64(self.MN - (L * self.TxnLimit))                                 // This is the size of padding for A and B vector (gipa)
+	for _, name := range []string{"/CK.data", "/KZG.data"} {
+		if _, err = os.Stat(self.folderPath + name); err != nil {
+			return
+		}
+	}
+	var m uint64
+	if m, err = readKeyCount(self.folderPath + "/CK.data"); err != nil {
+		return
+	} else if mn > m {
+		err = fmt.Errorf("%w: %s holds aggregation keys of size %d, want %d", ErrBatchTooLarge, self.folderPath+"/CK.data", m, mn)
+		return
+	}
+	defer recoverAs(&err, ErrCorruptKeyFile)
+	ck, kzg1, kzg2 = cm.IPPCMLoadCmKzg(mn, self.folderPath)
+	return
+}
+
+// Number of keys in CK.data, from its first 8 bytes.
+func readKeyCount(fileName string) (uint64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	data := make([]byte, 8)
+	if _, err = io.ReadFull(f, data); err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", ErrCorruptKeyFile, fileName, err)
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// Installs aggregation keys for instances of size up to mn.
+func (self *VCS) setAggKeys(mn uint64, ck cm.Ck, kzg1 kzg.KZG1Settings, kzg2 kzg.KZG2Settings) {
+	self.aggMu.Lock()
+	defer self.aggMu.Unlock()
+	self.installAggKeys(mn, ck, kzg1, kzg2)
+}
+
+// Same as setAggKeys, with aggMu held.
+func (self *VCS) installAggKeys(mn uint64, ck cm.Ck, kzg1 kzg.KZG1Settings, kzg2 kzg.KZG2Settings) {
+
+	self.MN = mn
+	self.ck, self.kzg1, self.kzg2 = ck, kzg1, kzg2
 
 	fmt.Println("Size:", len(self.ck.V), len(self.ck.W), len(self.kzg1.PK), len(self.kzg1.VK), len(self.kzg2.PK), len(self.kzg2.VK))
-	fmt.Println("padding:", self.nDiff, self.mnDiff)
 }
 
 // This resets the variable MN and txnLimit.
 // Be sure to load the data from disk
+//
+// Deprecated: AggProve and AggVerify take any number of proofs up to MAX_AGG_SIZE / L - 1,
+// and load larger aggregation keys when a batch needs them.
 func (self *VCS) ResizeAgg(txnLimit uint64) {
-	L := uint64(self.L)
 	self.TxnLimit = txnLimit
-	limit := L * self.TxnLimit
-	self.MN = utils.NextPowOf2(limit)
+	self.aggMu.Lock()
+	self.MN = self.aggSize(int(txnLimit))
+	self.aggMu.Unlock()
+}
+
+// Size of the GIPA instance for n proofs: the power of 2 nearest to L * (n + 1).
+// gipa-go multiplies block k of the instance by r^(2k), so a proof in block 0 would not be checked at all.
+// Block 0 is a dummy row of zeros instead, proof t is in block t + 1, and the instance is padded with zeros after the last proof.
+func (vcs *VCS) aggSize(n int) uint64 {
+	return utils.NextPowOf2(uint64(vcs.L) * uint64(n+1))
+}
+
+// Makes the aggregation keys cover instances of size mn.
+// Larger keys are computed from alpha and beta if they are available, and loaded from the folder otherwise.
+func (vcs *VCS) growAggKeys(mn uint64) error {
+	_, _, _, err := vcs.aggKeys(mn)
+	return err
+}
+
+// Aggregation keys for an instance of size mn, grown as in growAggKeys if they do not cover it.
+// The keys are powers of alpha and beta, so the keys of a smaller instance are a prefix of the loaded ones.
+// The prefix is taken under aggMu and keys are never written in place, so it stays valid if other callers grow the keys afterwards.
+func (vcs *VCS) aggKeys(mn uint64) (cm.Ck, kzg.KZG1Settings, kzg.KZG2Settings, error) {
+	vcs.aggMu.RLock()
+	if mn <= vcs.ck.M {
+		defer vcs.aggMu.RUnlock()
+		ck, kzg1, kzg2 := vcs.aggKeysPrefix(mn)
+		return ck, kzg1, kzg2, nil
+	}
+	vcs.aggMu.RUnlock()
+
+	vcs.aggMu.Lock()
+	defer vcs.aggMu.Unlock()
+	if mn > vcs.ck.M {
+		if err := vcs.loadAggKeysLocked(mn); err != nil {
+			return cm.Ck{}, kzg.KZG1Settings{}, kzg.KZG2Settings{}, err
+		}
+	}
+	ck, kzg1, kzg2 := vcs.aggKeysPrefix(mn)
+	return ck, kzg1, kzg2, nil
+}
+
+// Replaces the aggregation keys with keys of size mn. aggMu is held.
+func (vcs *VCS) loadAggKeysLocked(mn uint64) error {
+	if mn > MAX_AGG_SIZE {
+		return fmt.Errorf("%w: aggregation instance of size %d, at most %d", ErrBatchTooLarge, mn, MAX_AGG_SIZE)
+	}
+	if !vcs.alpha.IsZero() && !vcs.beta.IsZero() {
+		ck, kzg1, kzg2 := cm.IPPSetupKZG(mn, vcs.alpha, vcs.beta, vcs.G, vcs.H)
+		vcs.installAggKeys(mn, *ck, *kzg1, *kzg2)
+		return nil
+	}
+	if vcs.folderPath == "" {
+		return fmt.Errorf("%w: aggregation keys of size %d, at most %d are loaded", ErrBatchTooLarge, mn, vcs.ck.M)
+	}
+	ck, kzg1, kzg2, err := vcs.readAggKeys(mn)
+	if err != nil {
+		return err
+	}
+	vcs.installAggKeys(mn, ck, kzg1, kzg2)
+	return nil
+}
+
+func (vcs *VCS) aggKeysPrefix(mn uint64) (cm.Ck, kzg.KZG1Settings, kzg.KZG2Settings) {
+	ck := cm.Ck{M: mn, V: vcs.ck.V[:mn], W: vcs.ck.W[:mn]}
+	kzg1 := kzg.KZG1Settings{PK: vcs.kzg1.PK[:2*mn-1], VK: vcs.kzg1.VK}
+	kzg2 := kzg.KZG2Settings{PK: vcs.kzg2.PK[:2*mn-1], VK: vcs.kzg2.VK}
+	return ck, kzg1, kzg2
 }
 
+// Whether any aggregation keys are loaded.
+func (vcs *VCS) hasAggKeys() bool {
+	vcs.aggMu.RLock()
+	defer vcs.aggMu.RUnlock()
+	return vcs.MN != 0 && len(vcs.ck.W) != 0
+}
+
+// Number of proofs in an instance of size mn, with the dummy row and the padding: ceil(mn / L).
+func aggRows(mn uint64, L uint8) uint64 {
+	return (mn + uint64(L) - 1) / uint64(L)
+}
+
+// Entries of B for the proof of index: VRK[i] or VRK[i] - H, depending on bit i of the index.
+func (vcs *VCS) aggRowB(index uint64) []mcl.G2 {
+	b := make([]mcl.G2, vcs.L)
+	binary := ToBinary(index, vcs.L)
+	for i := range b {
+		if binary[i] == true {
+			// mcl.G2Sub(&b[i], &vcs.VRK[i], &vcs.H)
+			b[i] = vcs.VRKSubOneRev[i]
+		} else {
+			b[i] = vcs.VRK[i]
+		}
+	}
+	return b
+}
+
+// Aggregates the proofs of the entries at indexVec. Takes any number of proofs with L * (len(proofVec) + 1) <= MAX_AGG_SIZE.
 func (vcs *VCS) AggProve(indexVec []uint64, proofVec [][]mcl.G1) batch.Proof {
 
-	var A []mcl.G1
-	var B []mcl.G2
-	txnLimit := int(vcs.TxnLimit)
+	n := len(indexVec)
 	L := int(vcs.L)
 
-	if len(indexVec) != txnLimit || len(proofVec) != txnLimit {
+	if n == 0 || len(proofVec) != n {
 		panic("AggProof: Vectors are not of the expected size")
 	}
+	mn := vcs.aggSize(n)
+	ck, kzg1, kzg2, err := vcs.aggKeys(mn)
+	check(err)
 
+	A := make([]mcl.G1, mn) // Zero in the dummy row and past L * (n + 1)
+	B := make([]mcl.G2, mn)
 	for t := range proofVec {
 		if len(proofVec[t]) != L {
 			panic(fmt.Sprintf("Bad proof: %d", t))
 		}
-		A = append(A, proofVec[t]...)
+		copy(A[(t+1)*L:], proofVec[t])
+		copy(B[(t+1)*L:], vcs.aggRowB(indexVec[t]))
 	}
 
-	var binary []bool
-	b := make([]mcl.G2, vcs.L)
-	for t := range indexVec {
-		binary = ToBinary(indexVec[t], vcs.L)
-		for i := 0; i < L; i++ {
-			if binary[i] == true {
-				// mcl.G2Sub(&b[i], &vcs.VRK[i], &vcs.H)
-				b[i] = vcs.VRKSubOneRev[i]
-			} else {
-				b[i] = vcs.VRK[i]
-			}
-		}
-		B = append(B, b...)
-	}
+	var prover batch.Prover
+	prover.Init(uint32(vcs.L), uint32(aggRows(mn, vcs.L)), mn, &ck, &kzg1, &kzg2, A, B)
 
-	aPad := make([]mcl.G1, vcs.mnDiff)
-	bPad := make([]mcl.G2, vcs.mnDiff)
-	A = append(A, aPad...)
-	B = append(B, bPad...)
-
-	vcs.aggProver.Init(uint32(vcs.L), uint32(vcs.TxnLimit+uint64(vcs.nDiff)), vcs.MN, &vcs.ck, &vcs.kzg1, &vcs.kzg2, A, B)
-
-	proof := vcs.aggProver.Prove()
+	proof := prover.Prove()
 	return proof
 }
 
+// Checks an aggregated proof from AggProve of the values a_i at indexVec against digest.
 func (vcs *VCS) AggVerify(proof batch.Proof, digest mcl.G1, indexVec []uint64, a_i []mcl.Fr) bool {
+	digestVec := make([]mcl.G1, len(indexVec))
+	for t := range digestVec {
+		digestVec[t] = digest
+	}
+	return vcs.AggVerifyMultiDigest(proof, digestVec, indexVec, a_i)
+}
+
+// Checks an aggregated proof from AggProve where proof t is against its own digest, digestVec[t].
+// The proofs may come from different blocks: the prover does not use the digests,
+// and the verifier only needs digestVec[t] - a_i[t] * G for entry t.
+func (vcs *VCS) AggVerifyMultiDigest(proof batch.Proof, digestVec []mcl.G1, indexVec []uint64, a_i []mcl.Fr) bool {
 
-	txnLimit := int(vcs.TxnLimit)
+	n := len(indexVec)
 	L := int(vcs.L)
 
-	if len(indexVec) != txnLimit || len(a_i) != txnLimit {
+	if n == 0 || len(a_i) != n || len(digestVec) != n {
 		panic("AggProof: Vectors are not of the expected size")
 	}
+	mn := vcs.aggSize(n)
+	ck, kzg1, kzg2, err := vcs.aggKeys(mn)
+	check(err)
 
-	P := make([]mcl.G1, txnLimit)
-	Q := make([]mcl.G2, txnLimit)
+	// Row t + 1 is entry t, as in AggProve. VerifyEdrax pairs the sum of P with Q[0], so Q[0] is H even in the dummy row.
+	P := make([]mcl.G1, aggRows(mn, vcs.L))
+	Q := make([]mcl.G2, aggRows(mn, vcs.L))
+	B := make([]mcl.G2, mn)
 	var p mcl.G1 // temp variables
 
+	Q[0] = vcs.H
 	for t := range a_i {
 		mcl.G1Mul(&p, &vcs.G, &a_i[t])
-		mcl.G1Sub(&p, &digest, &p)
-		P[t] = p
-		Q[t] = vcs.H
-	}
-
-	pPad := make([]mcl.G1, vcs.nDiff)
-	qPad := make([]mcl.G2, vcs.nDiff)
-	P = append(P, pPad...)
-	Q = append(Q, qPad...)
-
-	var B []mcl.G2
-	var binary []bool
-	b := make([]mcl.G2, vcs.L)
-	for t := range indexVec {
-		binary = ToBinary(indexVec[t], vcs.L)
-		for i := 0; i < L; i++ {
-			if binary[i] == true {
-				// mcl.G2Sub(&b[i], &vcs.VRK[i], &vcs.H)
-				b[i] = vcs.VRKSubOneRev[i]
-			} else {
-				b[i] = vcs.VRK[i]
-			}
-		}
-		B = append(B, b...)
+		mcl.G1Sub(&P[t+1], &digestVec[t], &p)
+		Q[t+1] = vcs.H
+		copy(B[(t+1)*L:], vcs.aggRowB(indexVec[t]))
 	}
-	bPad := make([]mcl.G2, vcs.mnDiff)
-	B = append(B, bPad...)
 
 	// fmt.Println("Agg Verifier", L, vcs.N, len(P))
-	vcs.aggVerifier.Init(uint32(L), uint32(vcs.TxnLimit+uint64(vcs.nDiff)), vcs.MN, vcs.ck.W, &vcs.kzg1, &vcs.kzg2, P, Q, B)
-	status := vcs.aggVerifier.VerifyEdrax(proof)
-	// status := vcs.aggVerifier.Verify(proof, P, Q, B)
+	var verifier batch.Verifier
+	verifier.Init(uint32(L), uint32(aggRows(mn, vcs.L)), mn, ck.W, &kzg1, &kzg2, P, Q, B)
+	status := verifier.VerifyEdrax(proof)
+	// status := verifier.Verify(proof, P, Q, B)
 	return status
 }
@@ -0,0 +1,180 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+	"github.com/hyperproofs/gipa-go/cm"
+)
+
+// Same as newTestVCS, with aggregation keys for txnLimit proofs computed from the trapdoors.
+func newTestAggVCS(t *testing.T, L uint8, txnLimit uint64) *VCS {
+	vcs := newTestVCS(t, L, txnLimit)
+	check(vcs.growAggKeys(vcs.aggSize(int(txnLimit))))
+	return vcs
+}
+
+func TestAggSizes(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestAggVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+
+	instance := func(n int) ([]uint64, []mcl.Fr, [][]mcl.G1) {
+		indexVec := make([]uint64, n)
+		valueVec := make([]mcl.Fr, n)
+		proofVec := make([][]mcl.G1, n)
+		for t := range indexVec {
+			indexVec[t] = uint64(7*t+3) % vcs.N
+			valueVec[t] = aFr[indexVec[t]]
+			proofVec[t] = vcs.GetProofPath(vcs.ProofTree, indexVec[t], L)
+		}
+		return indexVec, valueVec, proofVec
+	}
+
+	// 5 and 9 proofs need larger keys than the ones for TxnLimit.
+	for _, n := range []int{1, 2, 3, 5, 9, 4} {
+		t.Run(fmt.Sprintf("%d/Size;%d", L, n), func(t *testing.T) {
+			indexVec, valueVec, proofVec := instance(n)
+			proof, err := vcs.TryAggProve(indexVec, proofVec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !vcs.AggVerify(proof, digest, indexVec, valueVec) {
+				t.Errorf("Aggregated proof of %d entries failed", n)
+			}
+			var one mcl.Fr
+			one.SetInt64(1)
+			for _, u := range []int{0, n - 1} {
+				tampered := append([]mcl.Fr{}, valueVec...)
+				mcl.FrAdd(&tampered[u], &tampered[u], &one)
+				if vcs.AggVerify(proof, digest, indexVec, tampered) {
+					t.Errorf("Aggregated proof of %d entries verified a wrong value at entry %d", n, u)
+				}
+			}
+			// A proof of another entry in place of the first one.
+			forged := append([][]mcl.G1{}, proofVec...)
+			forged[0] = vcs.GetProofPath(vcs.ProofTree, (indexVec[0]+1)%vcs.N, L)
+			if vcs.AggVerify(vcs.AggProve(indexVec, forged), digest, indexVec, valueVec) {
+				t.Errorf("Aggregated proof of %d entries verified with a wrong proof at entry 0", n)
+			}
+			if n == 1 {
+				return
+			}
+			mcl.FrAdd(&valueVec[n-1], &valueVec[n-1], &one)
+			status, err := vcs.TryAggVerify(proof, digest, indexVec[1:], valueVec[1:])
+			if vcs.aggSize(n-1) != vcs.aggSize(n) && !errors.Is(err, ErrBadProofLength) {
+				t.Errorf("Proof of %d entries checked with %d: got %v", n, n-1, err)
+			}
+			if status {
+				t.Errorf("Proof of %d entries verified with %d", n, n-1)
+			}
+		})
+	}
+
+	t.Run(fmt.Sprintf("%d/Limits;", L), func(t *testing.T) {
+		if _, err := vcs.TryAggProve(nil, nil); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Empty batch: got %v", err)
+		}
+		n := MAX_AGG_SIZE/int(L) + 1
+		if _, err := vcs.TryAggVerify(batch.Proof{}, digest, make([]uint64, n), make([]mcl.Fr, n)); !errors.Is(err, ErrBatchTooLarge) {
+			t.Errorf("Batch over MAX_AGG_SIZE: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Files;", L), func(t *testing.T) {
+		// Without trapdoors, larger keys come from the folder, up to the size of its files.
+		other := newTestVCS(t, L, 2)
+		ck, kzg1, kzg2 := cm.IPPSetupKZG(16, other.alpha, other.beta, other.G, other.H)
+		cm.IPPSaveCmKzg(ck, kzg1, kzg2, other.folderPath)
+		other.alpha.Clear()
+		other.beta.Clear()
+		other.LoadAggGipa()
+		other.OpenAll(aFr)
+		digest := other.Commit(aFr, uint64(L))
+
+		indexVec, valueVec, _ := instance(3)
+		proofVec := make([][]mcl.G1, len(indexVec))
+		for t := range indexVec {
+			proofVec[t] = other.GetProofPath(other.ProofTree, indexVec[t], L)
+		}
+		proof, err := other.TryAggProve(indexVec, proofVec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !other.AggVerify(proof, digest, indexVec, valueVec) {
+			t.Errorf("Aggregation with keys loaded from the folder failed")
+		}
+		indexVec, _, _ = instance(4)
+		proofVec = append(proofVec, proofVec[0])
+		if _, err := other.TryAggProve(indexVec, proofVec); !errors.Is(err, ErrBatchTooLarge) {
+			t.Errorf("Batch over the keys in the folder: got %v", err)
+		}
+	})
+}
+
+func TestAggMultiDigest(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestAggVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	vcs.OpenAll(aFr)
+
+	// Openings from three blocks, each against the digest of its block.
+	var digestVec []mcl.G1
+	var indexVec []uint64
+	var valueVec []mcl.Fr
+	var proofVec [][]mcl.G1
+	digest := vcs.Commit(aFr, uint64(L))
+	for block := 0; block < 3; block++ {
+		for _, index := range []uint64{uint64(block), uint64(block + 8)} {
+			digestVec = append(digestVec, digest)
+			indexVec = append(indexVec, index)
+			valueVec = append(valueVec, aFr[index])
+			proofVec = append(proofVec, vcs.GetProofPath(vcs.ProofTree, index, L))
+		}
+		updateindexVec := []uint64{uint64(block), 8, 15}
+		deltaVec := GenerateVectorSeeded(uint64(len(updateindexVec)), []byte(fmt.Sprintf("deltas-%d", block)))
+		for t := range updateindexVec {
+			mcl.FrAdd(&aFr[updateindexVec[t]], &aFr[updateindexVec[t]], &deltaVec[t])
+		}
+		digest = vcs.UpdateComVec(digest, updateindexVec, deltaVec)
+		vcs.UpdateProofTreeBulkInPlace(vcs.ProofTree, updateindexVec, deltaVec)
+	}
+	proof := vcs.AggProve(indexVec, proofVec)
+
+	t.Run(fmt.Sprintf("%d/Verify;", L), func(t *testing.T) {
+		status, err := vcs.TryAggVerifyMultiDigest(proof, digestVec, indexVec, valueVec)
+		if err != nil || !status {
+			t.Errorf("Aggregated proof across blocks failed: %v", err)
+		}
+		if vcs.AggVerify(proof, digestVec[0], indexVec, valueVec) {
+			t.Errorf("Aggregated proof across blocks verified against one digest")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Tamper;", L), func(t *testing.T) {
+		for _, u := range []int{0, 1, 3, 5} {
+			swapped := append([]mcl.G1{}, digestVec...)
+			swapped[u] = digest
+			if vcs.AggVerifyMultiDigest(proof, swapped, indexVec, valueVec) {
+				t.Errorf("Entry %d verified against the digest of another block", u)
+			}
+		}
+		var one mcl.Fr
+		one.SetInt64(1)
+		tampered := append([]mcl.Fr{}, valueVec...)
+		mcl.FrAdd(&tampered[0], &tampered[0], &one)
+		if vcs.AggVerifyMultiDigest(proof, digestVec, indexVec, tampered) {
+			t.Errorf("Entry 0 verified with a wrong value")
+		}
+		if _, err := vcs.TryAggVerifyMultiDigest(proof, digestVec[1:], indexVec, valueVec); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Missing digest: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package vcs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+	"github.com/hyperproofs/gipa-go/utils"
+)
+
+// Aggregates the proofs of a block as they arrive, e.g. while the mempool fills.
+//
+// Add checks a proof and appends it to the A and B vectors of the GIPA instance, after the dummy row of aggSize.
+// It also computes the Miller loops of the proof against the commitment keys, so the pairings of T = <A, ck.V> are done before the block closes.
+// Finalize pads the instance and runs GIPA, and gives the same proof as AggProve of the proofs in the order they were added.
+type Aggregator struct {
+	vcs      *VCS
+	mu       sync.Mutex
+	indexVec []uint64
+	A        []mcl.G1 // Without the dummy row
+	B        []mcl.G2
+	ml       mcl.GT // Product of the Miller loops of A[j] and ck.V[L + j]
+}
+
+func (vcs *VCS) NewAggregator() *Aggregator {
+	ag := Aggregator{vcs: vcs}
+	ag.ml.SetInt64(1)
+	return &ag
+}
+
+// Number of proofs added since the last Finalize.
+func (ag *Aggregator) Len() int {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	return len(ag.indexVec)
+}
+
+// Indices of the proofs added since the last Finalize, in order.
+func (ag *Aggregator) Indices() []uint64 {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	return append([]uint64{}, ag.indexVec...)
+}
+
+// Adds the proof of the entry at index. A proof that is rejected leaves the aggregator as it was.
+func (ag *Aggregator) Add(index uint64, proof []mcl.G1) error {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+
+	vcs := ag.vcs
+	if err := vcs.checkProof(index, proof); err != nil {
+		return err
+	}
+	n := len(ag.indexVec) + 1
+	if uint64(n+1)*uint64(vcs.L) > MAX_AGG_SIZE {
+		return fmt.Errorf("%w: %d entries and a dummy row of %d proof elements, at most %d", ErrBatchTooLarge, n, vcs.L, MAX_AGG_SIZE)
+	}
+	ck, _, _, err := vcs.aggKeys(vcs.aggSize(n))
+	if err != nil {
+		return err
+	}
+
+	j := len(ag.A) + int(vcs.L)
+	var e mcl.GT
+	mcl.MillerLoopVec(&e, proof, ck.V[j:j+int(vcs.L)])
+	mcl.GTMul(&ag.ml, &ag.ml, &e)
+
+	ag.indexVec = append(ag.indexVec, index)
+	ag.A = append(ag.A, proof...)
+	ag.B = append(ag.B, vcs.aggRowB(index)...)
+	return nil
+}
+
+// Aggregates the proofs added since the last Finalize, and starts over.
+func (ag *Aggregator) Finalize() (batch.Proof, error) {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+
+	var proof batch.Proof
+	vcs := ag.vcs
+	n := len(ag.indexVec)
+	if n == 0 {
+		return proof, fmt.Errorf("%w: no proofs to aggregate", ErrParamMismatch)
+	}
+	mn := vcs.aggSize(n)
+	ck, kzg1, kzg2, err := vcs.aggKeys(mn)
+	if err != nil {
+		return proof, err
+	}
+
+	A := make([]mcl.G1, mn)
+	B := make([]mcl.G2, mn)
+	copy(A[vcs.L:], ag.A)
+	copy(B[vcs.L:], ag.B)
+	var prover batch.Prover
+	prover.Init(uint32(vcs.L), uint32(aggRows(mn, vcs.L)), mn, &ck, &kzg1, &kzg2, A, B)
+
+	// Same steps as batch.Prover.Prove, with T from the Miller loops of Add. The dummy row and the padding add nothing to T.
+	mcl.FinalExp(&proof.T, &ag.ml)
+	r := prover.FiatShamir(prover.Prover.Transcript[:], proof.T)
+	prover.Prover.B = utils.G2VecRandExpo(prover.Prover.B, r, int(prover.M))
+	proof.GipaKzgProof = prover.Prover.Prove()
+
+	ag.reset()
+	return proof, nil
+}
+
+// Drops the proofs added since the last Finalize.
+func (ag *Aggregator) Reset() {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	ag.reset()
+}
+
+func (ag *Aggregator) reset() {
+	ag.indexVec = nil
+	ag.A = nil
+	ag.B = nil
+	ag.ml.SetInt64(1)
+}
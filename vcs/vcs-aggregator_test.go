@@ -0,0 +1,138 @@
+package vcs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestAggregator(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestAggVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+	ag := vcs.NewAggregator()
+
+	for _, n := range []int{1, 3, 6} {
+		t.Run(fmt.Sprintf("%d/Stream;%d", L, n), func(t *testing.T) {
+			indexVec := make([]uint64, n)
+			valueVec := make([]mcl.Fr, n)
+			proofVec := make([][]mcl.G1, n)
+			for t := range indexVec {
+				indexVec[t] = uint64(5*t+1) % vcs.N
+				valueVec[t] = aFr[indexVec[t]]
+				proofVec[t] = vcs.GetProofPath(vcs.ProofTree, indexVec[t], L)
+			}
+			for j := range indexVec {
+				if err := ag.Add(indexVec[j], proofVec[j]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if ag.Len() != n || fmt.Sprint(ag.Indices()) != fmt.Sprint(indexVec) {
+				t.Errorf("Aggregator holds %v, want %v", ag.Indices(), indexVec)
+			}
+			proof, err := ag.Finalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ag.Len() != 0 {
+				t.Errorf("Finalize left %d proofs", ag.Len())
+			}
+
+			want, err := EncodeAggProof(vcs.AggProve(indexVec, proofVec), COMPRESSED)
+			check(err)
+			got, err := EncodeAggProof(proof, COMPRESSED)
+			check(err)
+			if !bytes.Equal(got, want) {
+				t.Errorf("Streamed proof of %d entries differs from AggProve", n)
+			}
+			if !vcs.AggVerify(proof, digest, indexVec, valueVec) {
+				t.Errorf("Streamed proof of %d entries failed", n)
+			}
+		})
+	}
+
+	t.Run(fmt.Sprintf("%d/Errors;", L), func(t *testing.T) {
+		proof := vcs.GetProofPath(vcs.ProofTree, 2, L)
+		if err := ag.Add(2, proof[1:]); !errors.Is(err, ErrBadProofLength) {
+			t.Errorf("Short proof: got %v", err)
+		}
+		if err := ag.Add(vcs.N, proof); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Out of range index: got %v", err)
+		}
+		if ag.Len() != 0 {
+			t.Errorf("Rejected proofs were added")
+		}
+		if _, err := ag.Finalize(); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Empty aggregator: got %v", err)
+		}
+		check(ag.Add(2, proof))
+		ag.Reset()
+		if ag.Len() != 0 {
+			t.Errorf("Reset left %d proofs", ag.Len())
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Concurrent;", L), func(t *testing.T) {
+		// Keys for a single proof, so they are grown by the aggregators and AggProve at the same time. Run with -race.
+		vcs := newTestAggVCS(t, L, 1)
+		digest := vcs.Commit(aFr, uint64(L))
+		vcs.OpenAll(aFr)
+
+		n := 6
+		indexVec := make([]uint64, n)
+		valueVec := make([]mcl.Fr, n)
+		proofVec := make([][]mcl.G1, n)
+		for t := range indexVec {
+			indexVec[t] = uint64(3*t+2) % vcs.N
+			valueVec[t] = aFr[indexVec[t]]
+			proofVec[t] = vcs.GetProofPath(vcs.ProofTree, indexVec[t], L)
+		}
+		values := make(map[uint64]mcl.Fr)
+		for t := range indexVec {
+			values[indexVec[t]] = valueVec[t]
+		}
+
+		ags := []*Aggregator{vcs.NewAggregator(), vcs.NewAggregator()}
+		var wg sync.WaitGroup
+		errs := make([]error, len(ags)*n)
+		for k, ag := range ags {
+			for j := range indexVec {
+				wg.Add(1)
+				go func(ag *Aggregator, k int, j int) {
+					defer wg.Done()
+					errs[k*n+j] = ag.Add(indexVec[j], proofVec[j])
+				}(ag, k, j)
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !vcs.AggVerify(vcs.AggProve(indexVec, proofVec), digest, indexVec, valueVec) {
+				t.Errorf("AggProve next to the aggregators failed")
+			}
+		}()
+		wg.Wait()
+		check(firstError(errs))
+
+		for k, ag := range ags {
+			// The proofs were added in any order, so they are checked in the order of Indices.
+			got := ag.Indices()
+			gotValues := make([]mcl.Fr, len(got))
+			for t := range got {
+				gotValues[t] = values[got[t]]
+			}
+			proof, err := ag.Finalize()
+			check(err)
+			if len(got) != n || !vcs.AggVerify(proof, digest, got, gotValues) {
+				t.Errorf("Aggregator %d: proof of %v failed", k, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package vcs
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/alinush/go-mcl"
+)
+
+// Checks that the loaded public parameters are well formed, without the trapdoors:
+//   - every UPK, VRK and VRKSubOne element is in the prime order subgroup,
+//   - VRKSubOne = h - VRK, VRKSubOneRev = VRK - h, and no s_i is 0 or 1,
+//   - UPK[0][0] = g,
+//   - for every level l >= 1 and every k, e(UPK[l][k], h) = e(UPK[l-1][k mod 2^(l-1)], X),
+//     where X is VRK[l-1] if bit l-1 of k is set and VRKSubOne[l-1] otherwise.
+//     Together with the previous check this also gives UPK[l-1][k] = UPK[l][k] + UPK[l][k + 2^(l-1)].
+//   - the PRK, if it is loaded.
+//
+// The equations of a level are combined with random coefficients, so each level costs 3 pairings
+// and a few multi-exponentiations. Failures are reported as ErrInconsistentParams with the level.
+func (vcs *VCS) AuditParams() error {
+
+	if err := vcs.checkVrk(); err != nil {
+		return err
+	}
+	if len(vcs.UPK) != int(vcs.L)+1 {
+		return fmt.Errorf("%w: UPK is not loaded", ErrParamMismatch)
+	}
+
+	var sum, zero mcl.G2
+	for i := uint8(0); i < vcs.L; i++ {
+		if !vcs.VRK[i].IsValidOrder() || !vcs.VRKSubOne[i].IsValidOrder() {
+			return fmt.Errorf("%w: VRK %d is not in the group", ErrInconsistentParams, i)
+		}
+		if vcs.VRK[i].IsZero() || vcs.VRK[i].IsEqual(&vcs.H) {
+			return fmt.Errorf("%w: trapdoor %d is 0 or 1", ErrInconsistentParams, i)
+		}
+		mcl.G2Add(&sum, &vcs.VRK[i], &vcs.VRKSubOne[i])
+		if !sum.IsEqual(&vcs.H) {
+			return fmt.Errorf("%w: VRK %d and VRKSubOne %d do not add up to h", ErrInconsistentParams, i, i)
+		}
+		mcl.G2Add(&sum, &vcs.VRKSubOneRev[i], &vcs.VRKSubOne[i])
+		if !sum.IsEqual(&zero) {
+			return fmt.Errorf("%w: VRKSubOneRev %d is not -VRKSubOne %d", ErrInconsistentParams, i, i)
+		}
+	}
+
+	if !vcs.UPK[0][0].IsEqual(&vcs.G) {
+		return fmt.Errorf("%w: level 0: UPK is not g", ErrInconsistentParams)
+	}
+	for l := uint8(1); l <= vcs.L; l++ {
+		if len(vcs.UPK[l]) != 1<<l {
+			return fmt.Errorf("%w: level %d has %d keys, want %d", ErrParamMismatch, l, len(vcs.UPK[l]), 1<<l)
+		}
+		if k := firstInvalidG1(vcs.UPK[l]); k >= 0 {
+			return fmt.Errorf("%w: level %d: UPK %d is not in the group", ErrInconsistentParams, l, k)
+		}
+
+		half := 1 << (l - 1)
+		r := make([]mcl.Fr, 1<<l)
+		for k := range r {
+			r[k].Random()
+		}
+		P := make([]mcl.G1, 3)
+		Q := []mcl.G2{vcs.H, vcs.VRKSubOne[l-1], vcs.VRK[l-1]}
+		mcl.G1MulVec(&P[0], vcs.UPK[l], r)
+		mcl.G1MulVec(&P[1], vcs.UPK[l-1], r[:half])
+		mcl.G1MulVec(&P[2], vcs.UPK[l-1], r[half:])
+		mcl.G1Neg(&P[1], &P[1])
+		mcl.G1Neg(&P[2], &P[2])
+		if !pairingProductIsOne(P, Q) {
+			return fmt.Errorf("%w: level %d is not consistent with level %d and VRK %d", ErrInconsistentParams, l, l-1, l-1)
+		}
+	}
+
+	if len(vcs.PRK) > 0 {
+		if k := firstInvalidG1(vcs.PRK); k >= 0 {
+			return fmt.Errorf("%w: PRK %d is not in the group", ErrInconsistentParams, k)
+		}
+		if !prkIsConsistent(vcs.PRK, vcs.VRK, vcs.G, vcs.H) {
+			return fmt.Errorf("%w: PRK is not consistent with VRK", ErrInconsistentParams)
+		}
+	}
+	return nil
+}
+
+// Index of the first element that is not in the prime order subgroup, or -1.
+func firstInvalidG1(x []mcl.G1) int {
+	first := int64(len(x))
+	parallelRange(uint64(len(x)), func(start, stop uint64) {
+		for i := start; i < stop; i++ {
+			if !x[i].IsValidOrder() {
+				for {
+					old := atomic.LoadInt64(&first)
+					if int64(i) >= old || atomic.CompareAndSwapInt64(&first, old, int64(i)) {
+						break
+					}
+				}
+				return
+			}
+		}
+	})
+	if first == int64(len(x)) {
+		return -1
+	}
+	return int(first)
+}
+
+// Checks PRK[0] = g and e(PRK[S], h) = e(PRK[S - 2^i], VRK[i]) for every S, where i is the top bit of S.
+// The equations are combined with random coefficients.
+func prkIsConsistent(prk []mcl.G1, vrk []mcl.G2, G mcl.G1, H mcl.G2) bool {
+
+	if len(prk) != 1<<len(vrk) || !prk[0].IsEqual(&G) {
+		return false
+	}
+	r := make([]mcl.Fr, len(prk))
+	for S := 1; S < len(r); S++ {
+		r[S].Random()
+	}
+	P := make([]mcl.G1, len(vrk)+1)
+	Q := make([]mcl.G2, len(vrk)+1)
+	mcl.G1MulVec(&P[0], prk[1:], r[1:])
+	Q[0] = H
+	for i := range vrk {
+		lo := 1 << i
+		mcl.G1MulVec(&P[i+1], prk[:lo], r[lo:2*lo])
+		mcl.G1Neg(&P[i+1], &P[i+1])
+		Q[i+1] = vrk[i]
+	}
+	return pairingProductIsOne(P, Q)
+}
@@ -0,0 +1,53 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestAuditParams(t *testing.T) {
+
+	L := uint8(5)
+	vcs := newTestVCS(t, L, 2)
+
+	t.Run(fmt.Sprintf("%d/Valid;", L), func(t *testing.T) {
+		if err := vcs.AuditParams(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/CraftedUpkFile;", L), func(t *testing.T) {
+		// A well-formed UPK file with a valid checksum, but one key is off.
+		crafted := *vcs
+		crafted.folderPath = t.TempDir()
+		crafted.UPK = make([][]mcl.G1, L+1)
+		for l := range vcs.UPK {
+			crafted.UPK[l] = append([]mcl.G1{}, vcs.UPK[l]...)
+		}
+		mcl.G1Add(&crafted.UPK[3][5], &crafted.UPK[3][5], &vcs.G)
+		check(crafted.saveVrk())
+		check(crafted.saveUpk())
+
+		other := VCS{}
+		other.Init(L, crafted.folderPath, 2)
+		check(other.TryLoadVrk(L))
+		check(other.TryUpkLoadDriver())
+		err := other.AuditParams()
+		if !errors.Is(err, ErrInconsistentParams) || !strings.Contains(err.Error(), "level 3") {
+			t.Errorf("Expected ErrInconsistentParams at level 3, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/VRK;", L), func(t *testing.T) {
+		bad := *vcs
+		bad.VRKSubOne = append([]mcl.G2{}, vcs.VRKSubOne...)
+		bad.VRKSubOne[2] = vcs.VRK[2]
+		if err := bad.AuditParams(); !errors.Is(err, ErrInconsistentParams) {
+			t.Errorf("Expected ErrInconsistentParams, got %v", err)
+		}
+	})
+}
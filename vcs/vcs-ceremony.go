@@ -0,0 +1,550 @@
+package vcs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/cm"
+	"github.com/hyperproofs/gipa-go/utils"
+	"github.com/hyperproofs/kzg-go/kzg"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Multi-party setup, in the style of powers of tau.
+// Every participant raises the parameters to their own secrets. The trapdoors of the result are the
+// product of the secrets of all the participants, so one honest participant who forgets their secrets is enough.
+//
+// The UPK tree is in the Lagrange basis, g^{(1-s_1)(s_2)...}, which cannot be rerandomized in place.
+// The ceremony works on the PRK instead, g^{prod_{i in S} s_i} for every subset S (monomial basis),
+// and Finalize derives the UPK tree from it without any secret.
+// The aggregation keys are the powers g^{alpha^i} and h^{beta^i}, as in cm.IPPSetupKZG.
+type Ceremony struct {
+	L  uint8
+	MN uint64 // Size of the aggregation keys. Blocks with L * (TxnLimit + 1) <= MN can be aggregated, see aggSize.
+	G  mcl.G1
+	H  mcl.G2
+
+	PRK     []mcl.G1 // g^{prod_{i in S} s_i}, S is the bit mask of the index
+	VRK     []mcl.G2 // h^{s_i}
+	AlphaG1 []mcl.G1 // g^{alpha^i}, i < 2MN - 1
+	AlphaH  mcl.G2   // h^{alpha}
+	BetaG2  []mcl.G2 // h^{beta^i}, i < 2MN - 1
+	BetaG   mcl.G1   // g^{beta}
+
+	Contributions []Contribution
+}
+
+// What a participant publishes. The new VRK, h^alpha and h^beta chain the contributions together:
+// VRK'[i] = VRK[i]^{t_i}, AlphaH' = AlphaH^a, BetaH' = BetaH^b, which is checked against g^{t_i}, g^a and g^b.
+type Contribution struct {
+	VRK    []mcl.G2 // VRK after the contribution
+	AlphaH mcl.G2   // h^{alpha} after the contribution
+	BetaH  mcl.G2   // h^{beta} after the contribution
+
+	T []mcl.G1 // g^{t_i}
+	A mcl.G1   // g^a
+	B mcl.G1   // g^b
+
+	// Schnorr proofs of knowledge of t_1, ..., t_L, a, b. Binds the contribution to the previous one.
+	R []mcl.G1
+	Z []mcl.Fr
+}
+
+const CEREMONY_DOMAIN = "hyperproofs-go ceremony v1"
+
+// Starts a ceremony. All the secrets of the initial parameters are 1.
+func NewCeremony(L uint8, mn uint64) (*Ceremony, error) {
+	if L == 0 || L >= 32 {
+		return nil, fmt.Errorf("%w: Ceremony: Either ell is 0 or >= 32", ErrInvalidParam)
+	}
+	if mn < 2 || !utils.IsPow2(mn) || mn > MAX_AGG_SIZE {
+		return nil, fmt.Errorf("%w: Ceremony: size of the aggregation keys %d is not a power of 2 in [2, %d]", ErrInvalidParam, mn, MAX_AGG_SIZE)
+	}
+
+	c := Ceremony{L: L, MN: mn}
+	c.G, c.H = StandardGenerators()
+
+	c.PRK = make([]mcl.G1, uint64(1)<<L)
+	for i := range c.PRK {
+		c.PRK[i] = c.G
+	}
+	c.VRK = make([]mcl.G2, L)
+	for i := range c.VRK {
+		c.VRK[i] = c.H
+	}
+	c.AlphaG1 = make([]mcl.G1, 2*mn-1)
+	c.BetaG2 = make([]mcl.G2, 2*mn-1)
+	for i := range c.AlphaG1 {
+		c.AlphaG1[i] = c.G
+		c.BetaG2[i] = c.H
+	}
+	c.AlphaH = c.H
+	c.BetaG = c.G
+	return &c, nil
+}
+
+// h^{beta}
+func (c *Ceremony) betaH() mcl.G2 {
+	return c.BetaG2[1]
+}
+
+// Samples fresh secrets, applies them to the parameters and appends the proof to the transcript.
+// The secrets are wiped before returning.
+func (c *Ceremony) Contribute() {
+
+	L := int(c.L)
+	secrets := make([]mcl.Fr, L+2) // t_1, ..., t_L, a, b
+	for i := range secrets {
+		for secrets[i].IsZero() {
+			secrets[i].Random()
+		}
+	}
+	t, a, b := secrets[:L], &secrets[L], &secrets[L+1]
+
+	contrib := Contribution{
+		T: make([]mcl.G1, L),
+		R: make([]mcl.G1, L+2),
+		Z: make([]mcl.Fr, L+2),
+	}
+	X := make([]mcl.G1, L+2) // g^{secret}
+	for i := range secrets {
+		mcl.G1Mul(&X[i], &c.G, &secrets[i])
+	}
+	copy(contrib.T, X[:L])
+	contrib.A = X[L]
+	contrib.B = X[L+1]
+
+	// Proofs of knowledge
+	k := make([]mcl.Fr, L+2)
+	for i := range k {
+		k[i].Random()
+		mcl.G1Mul(&contrib.R[i], &c.G, &k[i])
+	}
+	e := c.challenge(X, contrib.R)
+	for i := range k {
+		mcl.FrMul(&contrib.Z[i], &e, &secrets[i])
+		mcl.FrAdd(&contrib.Z[i], &contrib.Z[i], &k[i])
+		k[i].Clear()
+	}
+
+	fmt.Println(SEP, "Contribution", len(c.Contributions)+1, SEP)
+	for i := range c.VRK {
+		mcl.G2Mul(&c.VRK[i], &c.VRK[i], &t[i])
+	}
+	c.scalePrk(t)
+	scalePowersG1(c.AlphaG1, *a)
+	mcl.G2Mul(&c.AlphaH, &c.AlphaH, a)
+	scalePowersG2(c.BetaG2, *b)
+	mcl.G1Mul(&c.BetaG, &c.BetaG, b)
+
+	contrib.VRK = append([]mcl.G2{}, c.VRK...)
+	contrib.AlphaH = c.AlphaH
+	contrib.BetaH = c.betaH()
+	c.Contributions = append(c.Contributions, contrib)
+
+	for i := range secrets {
+		secrets[i].Clear()
+	}
+}
+
+// PRK[S] = PRK[S]^{prod_{i in S} t_i}
+func (c *Ceremony) scalePrk(t []mcl.Fr) {
+	exp := make([]mcl.Fr, len(c.PRK))
+	exp[0].SetInt64(1)
+	for S := 1; S < len(exp); S++ {
+		top := bits.Len(uint(S)) - 1
+		mcl.FrMul(&exp[S], &exp[S^(1<<top)], &t[top])
+	}
+	parallelRange(uint64(len(c.PRK)), func(start, stop uint64) {
+		for S := start; S < stop; S++ {
+			mcl.G1Mul(&c.PRK[S], &c.PRK[S], &exp[S])
+			exp[S].Clear()
+		}
+	})
+}
+
+// powers[i] = powers[i]^{x^i}
+func scalePowersG1(powers []mcl.G1, x mcl.Fr) {
+	parallelRange(uint64(len(powers)), func(start, stop uint64) {
+		exp := utils.FrPow(x, int64(start))
+		for i := start; i < stop; i++ {
+			mcl.G1Mul(&powers[i], &powers[i], &exp)
+			mcl.FrMul(&exp, &exp, &x)
+		}
+		exp.Clear()
+	})
+}
+
+func scalePowersG2(powers []mcl.G2, x mcl.Fr) {
+	parallelRange(uint64(len(powers)), func(start, stop uint64) {
+		exp := utils.FrPow(x, int64(start))
+		for i := start; i < stop; i++ {
+			mcl.G2Mul(&powers[i], &powers[i], &exp)
+			mcl.FrMul(&exp, &exp, &x)
+		}
+		exp.Clear()
+	})
+}
+
+// Fiat-Shamir challenge of the next contribution. Covers the parameters the contribution starts from.
+func (c *Ceremony) challenge(X []mcl.G1, R []mcl.G1) mcl.Fr {
+	h, _ := blake2b.New512(nil)
+	h.Write([]byte(CEREMONY_DOMAIN))
+	n := make([]byte, 8)
+	binary.LittleEndian.PutUint64(n, uint64(len(c.Contributions)))
+	h.Write(n)
+	for i := range c.VRK {
+		h.Write(c.VRK[i].Serialize())
+	}
+	h.Write(c.AlphaH.Serialize())
+	beta := c.betaH()
+	h.Write(beta.Serialize())
+	for i := range X {
+		h.Write(X[i].Serialize())
+		h.Write(R[i].Serialize())
+	}
+	var e mcl.Fr
+	check(e.SetLittleEndianMod(h.Sum(nil)))
+	return e
+}
+
+// Checks the whole transcript and that the current parameters are well formed.
+// Any contribution that does not follow from the previous one is reported as ErrInvalidContribution.
+func (c *Ceremony) Verify() error {
+
+	G, H := StandardGenerators()
+	if !c.G.IsEqual(&G) || !c.H.IsEqual(&H) {
+		return fmt.Errorf("%w: generators are not the standard generators", ErrInvalidContribution)
+	}
+	if len(c.PRK) != 1<<c.L || len(c.VRK) != int(c.L) || len(c.AlphaG1) != int(2*c.MN-1) || len(c.BetaG2) != int(2*c.MN-1) {
+		return fmt.Errorf("%w: parameters do not have the sizes for ell %d and MN %d", ErrInvalidContribution, c.L, c.MN)
+	}
+
+	// Replay the transcript on the small parameters only.
+	replay := Ceremony{L: c.L, G: G, H: H}
+	replay.VRK = make([]mcl.G2, c.L)
+	for i := range replay.VRK {
+		replay.VRK[i] = H
+	}
+	replay.AlphaH = H
+	replay.BetaG2 = []mcl.G2{H, H}
+	for j := range c.Contributions {
+		if err := replay.verifyContribution(&c.Contributions[j]); err != nil {
+			return fmt.Errorf("contribution %d: %w", j+1, err)
+		}
+		next := &c.Contributions[j]
+		copy(replay.VRK, next.VRK)
+		replay.AlphaH = next.AlphaH
+		replay.BetaG2[1] = next.BetaH
+		replay.Contributions = append(replay.Contributions, *next)
+	}
+	for i := range c.VRK {
+		if !c.VRK[i].IsEqual(&replay.VRK[i]) {
+			return fmt.Errorf("%w: VRK %d does not match the last contribution", ErrInvalidContribution, i)
+		}
+	}
+	if !c.AlphaH.IsEqual(&replay.AlphaH) || !c.BetaG2[1].IsEqual(&replay.BetaG2[1]) {
+		return fmt.Errorf("%w: aggregation keys do not match the last contribution", ErrInvalidContribution)
+	}
+	return c.verifyStructure()
+}
+
+// Checks a contribution against the parameters it starts from.
+func (c *Ceremony) verifyContribution(next *Contribution) error {
+
+	L := int(c.L)
+	if len(next.VRK) != L || len(next.T) != L || len(next.R) != L+2 || len(next.Z) != L+2 {
+		return fmt.Errorf("%w: malformed contribution", ErrInvalidContribution)
+	}
+	X := append(append([]mcl.G1{}, next.T...), next.A, next.B)
+	for i := range X {
+		if X[i].IsZero() || !X[i].IsValidOrder() || !next.R[i].IsValidOrder() {
+			return fmt.Errorf("%w: secret %d is zero or not in the group", ErrInvalidContribution, i)
+		}
+	}
+
+	e := c.challenge(X, next.R)
+	var lhs, rhs mcl.G1
+	for i := range X {
+		mcl.G1Mul(&lhs, &c.G, &next.Z[i])
+		mcl.G1Mul(&rhs, &X[i], &e)
+		mcl.G1Add(&rhs, &rhs, &next.R[i])
+		if !lhs.IsEqual(&rhs) {
+			return fmt.Errorf("%w: proof of knowledge %d does not verify", ErrInvalidContribution, i)
+		}
+	}
+
+	for i := 0; i < L; i++ {
+		if !next.VRK[i].IsValidOrder() || !pairingIsEqual(&next.T[i], &c.VRK[i], &c.G, &next.VRK[i]) {
+			return fmt.Errorf("%w: VRK %d is not raised to the committed secret", ErrInvalidContribution, i)
+		}
+	}
+	if !next.AlphaH.IsValidOrder() || !pairingIsEqual(&next.A, &c.AlphaH, &c.G, &next.AlphaH) {
+		return fmt.Errorf("%w: h^alpha is not raised to the committed secret", ErrInvalidContribution)
+	}
+	beta := c.betaH()
+	if !next.BetaH.IsValidOrder() || !pairingIsEqual(&next.B, &beta, &c.G, &next.BetaH) {
+		return fmt.Errorf("%w: h^beta is not raised to the committed secret", ErrInvalidContribution)
+	}
+	return nil
+}
+
+// Checks that PRK, AlphaG1, BetaG2 and BetaG are powers of the secrets behind VRK, AlphaH and h^beta.
+// The equations are combined with random coefficients, so this costs a few pairings and multi-exponentiations.
+func (c *Ceremony) verifyStructure() error {
+
+	if i := firstInvalidG1(c.PRK); i >= 0 {
+		return fmt.Errorf("%w: PRK %d is not in the group", ErrInvalidContribution, i)
+	}
+	for i := range c.AlphaG1 {
+		if !c.AlphaG1[i].IsValidOrder() || !c.BetaG2[i].IsValidOrder() {
+			return fmt.Errorf("%w: aggregation key %d is not in the group", ErrInvalidContribution, i)
+		}
+	}
+	if !c.BetaG.IsValidOrder() {
+		return fmt.Errorf("%w: g^beta is not in the group", ErrInvalidContribution)
+	}
+
+	if !prkIsConsistent(c.PRK, c.VRK, c.G, c.H) {
+		return fmt.Errorf("%w: PRK is not consistent with VRK", ErrInvalidContribution)
+	}
+
+	// AlphaG1[0] = g and e(AlphaG1[i+1], h) = e(AlphaG1[i], h^alpha)
+	// BetaG2[0] = h, e(g^beta, h) = e(g, BetaG2[1]) and e(g, BetaG2[i+1]) = e(g^beta, BetaG2[i])
+	n := len(c.AlphaG1)
+	if !c.AlphaG1[0].IsEqual(&c.G) || !c.BetaG2[0].IsEqual(&c.H) {
+		return fmt.Errorf("%w: aggregation keys do not start with the generators", ErrInvalidContribution)
+	}
+	if !pairingIsEqual(&c.BetaG, &c.H, &c.G, &c.BetaG2[1]) {
+		return fmt.Errorf("%w: g^beta is not consistent with h^beta", ErrInvalidContribution)
+	}
+	r := make([]mcl.Fr, n-1)
+	for i := range r {
+		r[i].Random()
+	}
+	var a0, a1 mcl.G1
+	var b0, b1 mcl.G2
+	mcl.G1MulVec(&a0, c.AlphaG1[:n-1], r)
+	mcl.G1MulVec(&a1, c.AlphaG1[1:], r)
+	if !pairingIsEqual(&a1, &c.H, &a0, &c.AlphaH) {
+		return fmt.Errorf("%w: g^{alpha^i} are not consecutive powers", ErrInvalidContribution)
+	}
+	mcl.G2MulVec(&b0, c.BetaG2[:n-1], r)
+	mcl.G2MulVec(&b1, c.BetaG2[1:], r)
+	if !pairingIsEqual(&c.G, &b1, &c.BetaG, &b0) {
+		return fmt.Errorf("%w: h^{beta^i} are not consecutive powers", ErrInvalidContribution)
+	}
+	return nil
+}
+
+// UPK tree from the PRK. Only group operations, no secret is needed.
+func (c *Ceremony) upkTree() [][]mcl.G1 {
+
+	L := c.L
+	upk := make([][]mcl.G1, L+1)
+
+	// Monomial to Lagrange basis, one variable at a time:
+	// g^{(1-s_i) x} = g^{x} - g^{s_i x} and g^{s_i x} stays.
+	upk[L] = append([]mcl.G1{}, c.PRK...)
+	for i := uint8(0); i < L; i++ {
+		bit := uint64(1) << i
+		for k := uint64(0); k < uint64(len(upk[L])); k++ {
+			if k&bit == 0 {
+				mcl.G1Sub(&upk[L][k], &upk[L][k], &upk[L][k|bit])
+			}
+		}
+	}
+
+	// Level l only has the first l variables: (1 - s_l) + s_l = 1.
+	for l := int(L) - 1; l >= 0; l-- {
+		upk[l] = make([]mcl.G1, 1<<l)
+		for k := range upk[l] {
+			mcl.G1Add(&upk[l][k], &upk[l+1][k], &upk[l+1][k+(1<<l)])
+		}
+	}
+	return upk
+}
+
+// Verifies the transcript and writes the public parameters to folder, in the layout KeyGenLoad expects.
+// Nobody knows the trapdoors, so there is no trapdoor file.
+func (c *Ceremony) Finalize(folder string) error {
+
+	if len(c.Contributions) == 0 {
+		return fmt.Errorf("%w: Ceremony: no contributions yet", ErrInvalidParam)
+	}
+	if err := c.Verify(); err != nil {
+		return err
+	}
+
+	vcs := VCS{}
+	if err := vcs.TryInit(c.L, folder, 1); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return err
+	}
+	vcs.G = c.G
+	vcs.H = c.H
+	for i := range c.VRK {
+		vcs.VRK[i] = c.VRK[i]
+		mcl.G2Sub(&vcs.VRKSubOne[i], &c.H, &c.VRK[i])
+		mcl.G2Sub(&vcs.VRKSubOneRev[i], &c.VRK[i], &c.H)
+	}
+	vcs.setupID = vcs.computeSetupID()
+	vcs.setupL = vcs.L
+	vcs.setupFile = folder + VRKNAME
+	if err := vcs.saveVrk(); err != nil {
+		return err
+	}
+	vcs.UPK = c.upkTree()
+	if err := vcs.saveUpk(); err != nil {
+		return err
+	}
+
+	ck := cm.Ck{M: c.MN, V: make([]mcl.G2, c.MN), W: make([]mcl.G1, c.MN)}
+	for i := uint64(0); i < c.MN; i++ {
+		ck.W[i] = c.AlphaG1[2*i]
+		ck.V[i] = c.BetaG2[2*i]
+	}
+	kzg1 := kzg.NewKZG1Settings(c.AlphaG1, []mcl.G2{c.H, c.AlphaH})
+	kzg2 := kzg.NewKZG2Settings(c.BetaG2, []mcl.G1{c.G, c.BetaG})
+	cm.IPPSaveCmKzg(&ck, kzg1, kzg2, folder)
+	fmt.Println(SEP, "Finalized the ceremony in", folder, SEP)
+	return nil
+}
+
+// Writes the parameters and the transcript to one file.
+func (c *Ceremony) Save(fileName string) error {
+
+	h := FileHeader{
+		Version: FORMAT_VERSION,
+		Curve:   uint16(mcl.BLS12_381),
+		Kind:    FILE_CEREMONY,
+		L:       c.L,
+		NFiles:  1,
+		Stop:    uint64(len(c.Contributions)),
+	}
+	f, err := createKeyFile(fileName, h)
+	if err != nil {
+		return err
+	}
+	n := make([]byte, 8)
+	binary.LittleEndian.PutUint64(n, c.MN)
+	f.Write(n)
+	f.Write(c.G.Serialize())
+	f.Write(c.H.Serialize())
+	for i := range c.PRK {
+		f.Write(c.PRK[i].Serialize())
+	}
+	for i := range c.VRK {
+		f.Write(c.VRK[i].Serialize())
+	}
+	for i := range c.AlphaG1 {
+		f.Write(c.AlphaG1[i].Serialize())
+		f.Write(c.BetaG2[i].Serialize())
+	}
+	f.Write(c.AlphaH.Serialize())
+	f.Write(c.BetaG.Serialize())
+
+	for j := range c.Contributions {
+		next := &c.Contributions[j]
+		for i := range next.VRK {
+			f.Write(next.VRK[i].Serialize())
+			f.Write(next.T[i].Serialize())
+		}
+		f.Write(next.AlphaH.Serialize())
+		f.Write(next.BetaH.Serialize())
+		f.Write(next.A.Serialize())
+		f.Write(next.B.Serialize())
+		for i := range next.R {
+			f.Write(next.R[i].Serialize())
+			f.Write(next.Z[i].Serialize())
+		}
+	}
+	return f.Close()
+}
+
+// Reads a ceremony written by Save. The transcript is not verified, use Verify.
+func LoadCeremony(fileName string) (*Ceremony, error) {
+
+	f, err := openKeyFile(fileName, FILE_CEREMONY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := f.Header
+	if h.L == 0 || h.L >= 32 {
+		return nil, fmt.Errorf("%w: %s: ell %d", ErrCorruptKeyFile, fileName, h.L)
+	}
+	n := make([]byte, 8)
+	if _, err = io.ReadFull(f, n); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorruptKeyFile, fileName, err)
+	}
+	mn := binary.LittleEndian.Uint64(n)
+	if mn < 2 || !utils.IsPow2(mn) || mn > MAX_AGG_SIZE {
+		return nil, fmt.Errorf("%w: %s: MN %d", ErrCorruptKeyFile, fileName, mn)
+	}
+
+	L := int64(h.L)
+	g1, g2, fr := int64(GetG1ByteSize()), int64(GetG2ByteSize()), int64(GetFrByteSize())
+	params := g1 + g2 + (int64(1)<<L)*g1 + L*g2 + int64(2*mn-1)*(g1+g2) + g2 + g1
+	contrib := L*(g2+g1) + 2*g2 + 2*g1 + (L+2)*(g1+fr)
+	if err = f.expectPayload(8 + params + int64(h.Stop)*contrib); err != nil {
+		return nil, err
+	}
+
+	c := Ceremony{L: h.L, MN: mn}
+	c.PRK = make([]mcl.G1, uint64(1)<<h.L)
+	c.VRK = make([]mcl.G2, h.L)
+	c.AlphaG1 = make([]mcl.G1, 2*mn-1)
+	c.BetaG2 = make([]mcl.G2, 2*mn-1)
+
+	r := elementReader{r: f}
+	r.G1(&c.G)
+	r.G2(&c.H)
+	for i := range c.PRK {
+		r.G1(&c.PRK[i])
+	}
+	for i := range c.VRK {
+		r.G2(&c.VRK[i])
+	}
+	for i := range c.AlphaG1 {
+		r.G1(&c.AlphaG1[i])
+		r.G2(&c.BetaG2[i])
+	}
+	r.G2(&c.AlphaH)
+	r.G1(&c.BetaG)
+
+	c.Contributions = make([]Contribution, h.Stop)
+	for j := range c.Contributions {
+		next := &c.Contributions[j]
+		next.VRK = make([]mcl.G2, L)
+		next.T = make([]mcl.G1, L)
+		next.R = make([]mcl.G1, L+2)
+		next.Z = make([]mcl.Fr, L+2)
+		for i := range next.VRK {
+			r.G2(&next.VRK[i])
+			r.G1(&next.T[i])
+		}
+		r.G2(&next.AlphaH)
+		r.G2(&next.BetaH)
+		r.G1(&next.A)
+		r.G1(&next.B)
+		for i := range next.R {
+			r.G1(&next.R[i])
+			r.Fr(&next.Z[i])
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, r.err)
+	}
+	if err = f.Verify(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
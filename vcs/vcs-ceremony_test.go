@@ -0,0 +1,96 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestCeremony(t *testing.T) {
+
+	mcl.InitFromString("bls12-381")
+	NCORES = 4
+	L := uint8(4)
+	txnLimit := uint64(2)
+
+	c, err := NewCeremony(L, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Contribute()
+	c.Contribute()
+
+	t.Run(fmt.Sprintf("%d/Verify;", L), func(t *testing.T) {
+		if err := c.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/SaveLoad;", L), func(t *testing.T) {
+		fileName := t.TempDir() + "/ceremony.data"
+		if err := c.Save(fileName); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadCeremony(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := loaded.Verify(); err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded.Contributions) != 2 || !loaded.PRK[5].IsEqual(&c.PRK[5]) {
+			t.Errorf("Loaded ceremony does not match")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Tampered;", L), func(t *testing.T) {
+		bad := *c
+		bad.PRK = append([]mcl.G1{}, c.PRK...)
+		mcl.G1Add(&bad.PRK[5], &bad.PRK[5], &bad.G)
+		if err := bad.Verify(); !errors.Is(err, ErrInvalidContribution) {
+			t.Errorf("Expected ErrInvalidContribution, got %v", err)
+		}
+
+		bad = *c
+		bad.Contributions = append([]Contribution{}, c.Contributions...)
+		bad.Contributions[0].Z = append([]mcl.Fr{}, c.Contributions[0].Z...)
+		bad.Contributions[0].Z[0].Random()
+		if err := bad.Verify(); !errors.Is(err, ErrInvalidContribution) {
+			t.Errorf("Expected ErrInvalidContribution, got %v", err)
+		}
+
+		// Dropping a contribution breaks the chain.
+		bad = *c
+		bad.Contributions = c.Contributions[1:]
+		if err := bad.Verify(); !errors.Is(err, ErrInvalidContribution) {
+			t.Errorf("Expected ErrInvalidContribution, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Finalize;", L), func(t *testing.T) {
+		folder := t.TempDir()
+		if err := c.Finalize(folder); err != nil {
+			t.Fatal(err)
+		}
+		vcs := VCS{}
+		if err := vcs.TryKeyGenLoad(4, L, folder, txnLimit); err != nil {
+			t.Fatal(err)
+		}
+
+		aFr := GenerateVector(vcs.N)
+		digest := vcs.Commit(aFr, uint64(L))
+		vcs.OpenAll(aFr)
+		indexVec := []uint64{6, 9}
+		valueVec := []mcl.Fr{aFr[6], aFr[9]}
+		proofVec := [][]mcl.G1{vcs.GetProofPath(vcs.ProofTree, 6, L), vcs.GetProofPath(vcs.ProofTree, 9, L)}
+		if !vcs.Verify(digest, indexVec[0], valueVec[0], proofVec[0]) {
+			t.Errorf("Verification failed")
+		}
+		proof := vcs.AggProve(indexVec, proofVec)
+		if !vcs.AggVerify(proof, digest, indexVec, valueVec) {
+			t.Errorf("Aggregation failed")
+		}
+	})
+}
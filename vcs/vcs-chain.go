@@ -0,0 +1,199 @@
+package vcs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+	"github.com/hyperproofs/gipa-go/cm"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Domain separation tag of the header hash. Bump the version if the header changes.
+const BLOCK_DST = "hyperproofs-go block v1"
+
+// Header of a block, linking its digest to the digest of the previous block.
+//
+// Digest is UpdateComVec of the parent digest with the batch (Indices, Deltas),
+// and Proof is the aggregated proof from AggProve of the entries at Indices against Digest.
+// A header with an empty batch has a zero proof. The genesis header is one, with height 0 and a zero parent hash.
+type BlockHeader struct {
+	Height  uint64
+	Parent  [32]byte // Hash of the previous header
+	Digest  mcl.G1
+	Indices []uint64
+	Deltas  []mcl.Fr
+	Proof   batch.Proof
+}
+
+// Header of the first block, committing to the initial vector.
+func GenesisHeader(digest mcl.G1) BlockHeader {
+	return BlockHeader{Digest: digest}
+}
+
+// blake2b-256 of the header: BLOCK_DST, the height, the parent hash, the digest, the batch and the proof.
+func (h *BlockHeader) Hash() [32]byte {
+	var buf bytes.Buffer
+	u64 := make([]byte, 8)
+	putUint64 := func(x uint64) {
+		binary.LittleEndian.PutUint64(u64, x)
+		buf.Write(u64)
+	}
+
+	buf.WriteString(BLOCK_DST)
+	putUint64(h.Height)
+	buf.Write(h.Parent[:])
+	buf.Write(h.Digest.Serialize())
+	putUint64(uint64(len(h.Indices)))
+	for t := range h.Indices {
+		putUint64(h.Indices[t])
+	}
+	putUint64(uint64(len(h.Deltas)))
+	for t := range h.Deltas {
+		buf.Write(h.Deltas[t].Serialize())
+	}
+
+	p := &h.Proof.GipaKzgProof
+	buf.Write(h.Proof.T.Serialize())
+	for _, coms := range [][]cm.Com{p.L, p.R} {
+		putUint64(uint64(len(coms)))
+		for i := range coms {
+			for k := range coms[i].Com {
+				buf.Write(coms[i].Com[k].Serialize())
+			}
+		}
+	}
+	buf.Write(p.A[0].Serialize())
+	buf.Write(p.B[0].Serialize())
+	buf.Write(p.W.Serialize())
+	buf.Write(p.V.Serialize())
+	buf.Write(p.Pi1.Serialize())
+	buf.Write(p.Pi2.Serialize())
+	return blake2b.Sum256(buf.Bytes())
+}
+
+// Header of the block that applies the batch (updateindexVec, deltaVec) on top of parent.
+// proofVec holds the proofs of the entries at updateindexVec after the batch, which are aggregated with TryAggProve.
+// An empty batch gives a block with the digest of parent and a zero proof.
+func (vcs *VCS) NewBlockHeader(parent *BlockHeader, updateindexVec []uint64, deltaVec []mcl.Fr, proofVec [][]mcl.G1) (BlockHeader, error) {
+	h := BlockHeader{Height: parent.Height + 1, Parent: parent.Hash()}
+	if err := vcs.checkBatch(updateindexVec, deltaVec); err != nil {
+		return h, err
+	}
+	var proof batch.Proof
+	if len(updateindexVec) == 0 {
+		if len(proofVec) != 0 {
+			return h, fmt.Errorf("%w: %d proofs for an empty batch", ErrParamMismatch, len(proofVec))
+		}
+	} else {
+		var err error
+		if proof, err = vcs.TryAggProve(updateindexVec, proofVec); err != nil {
+			return h, err
+		}
+	}
+	h.Digest = vcs.blockDigest(parent.Digest, updateindexVec, deltaVec)
+	h.Indices = append([]uint64{}, updateindexVec...)
+	h.Deltas = append([]mcl.Fr{}, deltaVec...)
+	h.Proof = proof
+	return h, nil
+}
+
+func (vcs *VCS) checkBatch(updateindexVec []uint64, deltaVec []mcl.Fr) error {
+	if len(updateindexVec) != len(deltaVec) {
+		return fmt.Errorf("%w: %d indices and %d deltas", ErrParamMismatch, len(updateindexVec), len(deltaVec))
+	}
+	for t := range updateindexVec {
+		if updateindexVec[t] >= vcs.N {
+			return fmt.Errorf("%w: entry %d has index %d, vector size %d", ErrIndexOutOfRange, t, updateindexVec[t], vcs.N)
+		}
+	}
+	return nil
+}
+
+// Digest after the batch. UpdateComVec needs at least one entry, and an empty batch keeps the digest.
+func (vcs *VCS) blockDigest(digest mcl.G1, updateindexVec []uint64, deltaVec []mcl.Fr) mcl.G1 {
+	if len(updateindexVec) == 0 {
+		return digest
+	}
+	return vcs.UpdateComVec(digest, updateindexVec, deltaVec)
+}
+
+// Whether p is the zero proof of a header with an empty batch.
+func isZeroAggProof(p *batch.Proof) bool {
+	g := &p.GipaKzgProof
+	return p.T.IsZero() && len(g.L) == 0 && len(g.R) == 0 && g.A[0].IsZero() && g.B[0].IsZero() &&
+		g.W.IsZero() && g.V.IsZero() && g.Pi1.IsZero() && g.Pi2.IsZero()
+}
+
+// Checks that h follows parent: its height, its parent hash, and that its digest is UpdateComVec of the
+// parent digest with its batch, and that the proof is zero if the batch is empty.
+// The aggregated proof of a batch needs the values of the entries, see VerifyBlockProof.
+func (vcs *VCS) ValidateHeader(parent *BlockHeader, h *BlockHeader) error {
+	if h.Height != parent.Height+1 {
+		return fmt.Errorf("%w: height %d follows height %d", ErrInvalidBlock, h.Height, parent.Height)
+	}
+	if h.Parent != parent.Hash() {
+		return fmt.Errorf("%w: block %d does not link to the hash of block %d", ErrInvalidBlock, h.Height, parent.Height)
+	}
+	if err := vcs.checkBatch(h.Indices, h.Deltas); err != nil {
+		return fmt.Errorf("%w: block %d: %v", ErrInvalidBlock, h.Height, err)
+	}
+	if len(h.Indices) == 0 && !isZeroAggProof(&h.Proof) {
+		return fmt.Errorf("%w: block %d has an empty batch and a proof", ErrInvalidBlock, h.Height)
+	}
+	digest := vcs.blockDigest(parent.Digest, h.Indices, h.Deltas)
+	if !digest.IsEqual(&h.Digest) {
+		return fmt.Errorf("%w: digest of block %d does not follow from block %d and the batch", ErrInvalidBlock, h.Height, parent.Height)
+	}
+	return nil
+}
+
+// Checks every header against the one before it. The first header is trusted, as the genesis or a checkpoint.
+func (vcs *VCS) ValidateChain(headers []BlockHeader) error {
+	for i := 1; i < len(headers); i++ {
+		if err := vcs.ValidateHeader(&headers[i-1], &headers[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks the aggregated proof of h, given the values of the entries at h.Indices after the block.
+// A header with an empty batch has nothing to prove, and takes no values.
+func (vcs *VCS) VerifyBlockProof(h *BlockHeader, a_i []mcl.Fr) (bool, error) {
+	if len(h.Indices) == 0 && len(a_i) == 0 {
+		return isZeroAggProof(&h.Proof), nil
+	}
+	return vcs.TryAggVerify(h.Proof, h.Digest, h.Indices, a_i)
+}
+
+// Checks the aggregated proofs of many headers at once with AggVerifyBatch, given the values of the entries
+// at headers[i].Indices after block i. Headers with an empty batch, like the genesis, have a zero proof and are skipped.
+func (vcs *VCS) VerifyBlockProofs(headers []BlockHeader, values [][]mcl.Fr) error {
+	if len(headers) != len(values) {
+		return fmt.Errorf("%w: %d headers and %d value vectors", ErrParamMismatch, len(headers), len(values))
+	}
+	var instances []AggInstance
+	var heights []uint64
+	for i := range headers {
+		h := &headers[i]
+		if len(h.Indices) == 0 {
+			continue
+		}
+		instances = append(instances, AggInstance{Proof: h.Proof, Digest: h.Digest, Indices: h.Indices, Values: values[i]})
+		heights = append(heights, h.Height)
+	}
+	if len(instances) == 0 {
+		return nil
+	}
+	status, failed, err := vcs.TryAggVerifyBatch(instances)
+	if err != nil {
+		return err
+	}
+	if !status {
+		return fmt.Errorf("%w: aggregated proof of block %d failed", ErrInvalidBlock, heights[failed])
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestChain(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestAggVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	vcs.OpenAll(aFr)
+
+	headers := []BlockHeader{GenesisHeader(vcs.Commit(aFr, uint64(L)))}
+	batches := [][]uint64{{3, 9}, {9, 0}, {15, 3}}
+	values := make([][]mcl.Fr, len(batches))
+
+	t.Run(fmt.Sprintf("%d/Build;", L), func(t *testing.T) {
+		for i := range batches {
+			deltaVec := GenerateVectorSeeded(uint64(len(batches[i])), []byte(fmt.Sprintf("deltas-%d", i)))
+			for t := range batches[i] {
+				mcl.FrAdd(&aFr[batches[i][t]], &aFr[batches[i][t]], &deltaVec[t])
+			}
+			vcs.UpdateProofTreeBulkInPlace(vcs.ProofTree, batches[i], deltaVec)
+			proofVec := make([][]mcl.G1, len(batches[i]))
+			values[i] = make([]mcl.Fr, len(batches[i]))
+			for t, index := range batches[i] {
+				proofVec[t] = vcs.GetProofPath(vcs.ProofTree, index, L)
+				values[i][t] = aFr[index]
+			}
+
+			h, err := vcs.NewBlockHeader(&headers[len(headers)-1], batches[i], deltaVec, proofVec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers = append(headers, h)
+		}
+		want := vcs.Commit(aFr, uint64(L))
+		if !headers[len(headers)-1].Digest.IsEqual(&want) {
+			t.Errorf("Digest of the last block does not match the vector")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Validate;", L), func(t *testing.T) {
+		if err := vcs.ValidateChain(headers); err != nil {
+			t.Fatal(err)
+		}
+		for i := range batches {
+			status, err := vcs.VerifyBlockProof(&headers[i+1], values[i])
+			if err != nil || !status {
+				t.Errorf("Proof of block %d failed: %v", i+1, err)
+			}
+		}
+		status, _ := vcs.VerifyBlockProof(&headers[1], values[1])
+		if status {
+			t.Errorf("Proof of block 1 verified with the values of block 2")
+		}
+
+		// The genesis has no proof, so it takes no values.
+		if err := vcs.VerifyBlockProofs(headers, append([][]mcl.Fr{nil}, values...)); err != nil {
+			t.Errorf("Batch verification of the chain failed: %v", err)
+		}
+		swapped := [][]mcl.Fr{nil, values[0], values[2], values[1]}
+		if err := vcs.VerifyBlockProofs(headers, swapped); !errors.Is(err, ErrInvalidBlock) || !strings.Contains(err.Error(), "block 2") {
+			t.Errorf("Batch verification with swapped values: got %v", err)
+		}
+		var one mcl.Fr
+		one.SetInt64(1)
+		forged := [][]mcl.Fr{nil, values[0], append([]mcl.Fr{}, values[1]...), values[2]}
+		mcl.FrAdd(&forged[2][0], &forged[2][0], &one)
+		if err := vcs.VerifyBlockProofs(headers, forged); !errors.Is(err, ErrInvalidBlock) || !strings.Contains(err.Error(), "block 2") {
+			t.Errorf("Batch verification with a forged first value: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Tamper;", L), func(t *testing.T) {
+		tamper := map[string]func(h *BlockHeader){
+			"Delta":  func(h *BlockHeader) { h.Deltas = []mcl.Fr{h.Deltas[1], h.Deltas[0]} },
+			"Index":  func(h *BlockHeader) { h.Indices = []uint64{h.Indices[0], 4} },
+			"Parent": func(h *BlockHeader) { h.Parent[0] ^= 1 },
+			"Height": func(h *BlockHeader) { h.Height++ },
+			"Digest": func(h *BlockHeader) { h.Digest = headers[1].Digest },
+			"Range":  func(h *BlockHeader) { h.Indices = []uint64{h.Indices[0], vcs.N} },
+			"Length": func(h *BlockHeader) { h.Deltas = h.Deltas[:1] },
+		}
+		for name, f := range tamper {
+			chain := append([]BlockHeader{}, headers...)
+			f(&chain[2])
+			if err := vcs.ValidateChain(chain); !errors.Is(err, ErrInvalidBlock) {
+				t.Errorf("%s: got %v", name, err)
+			}
+		}
+
+		// Changing the proof of a block breaks the link to it.
+		chain := append([]BlockHeader{}, headers...)
+		chain[1].Proof = chain[2].Proof
+		if err := vcs.ValidateChain(chain); !errors.Is(err, ErrInvalidBlock) {
+			t.Errorf("Proof: got %v", err)
+		}
+	})
+	t.Run(fmt.Sprintf("%d/Empty;", L), func(t *testing.T) {
+		// A block without transactions keeps the digest and has a zero proof, like the genesis.
+		last := &headers[len(headers)-1]
+		h, err := vcs.NewBlockHeader(last, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !h.Digest.IsEqual(&last.Digest) {
+			t.Errorf("Empty block changed the digest")
+		}
+		chain := append(append([]BlockHeader{}, headers...), h)
+		if err := vcs.ValidateChain(chain); err != nil {
+			t.Fatal(err)
+		}
+		if status, err := vcs.VerifyBlockProof(&h, nil); err != nil || !status {
+			t.Errorf("Proof of the empty block failed: %v", err)
+		}
+		if err := vcs.VerifyBlockProofs(chain, append(append([][]mcl.Fr{nil}, values...), nil)); err != nil {
+			t.Errorf("Batch verification of the chain with an empty block failed: %v", err)
+		}
+
+		chain[len(chain)-1].Proof = headers[1].Proof
+		if err := vcs.ValidateChain(chain); !errors.Is(err, ErrInvalidBlock) {
+			t.Errorf("Empty batch with a proof: got %v", err)
+		}
+		proof := vcs.GetProofPath(vcs.ProofTree, 3, L)
+		if _, err := vcs.NewBlockHeader(last, nil, nil, [][]mcl.G1{proof}); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Proofs for an empty batch: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,496 @@
+package vcs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/alinush/go-mcl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// A proof serving node keeps its proof tree across restarts with checkpoints and a delta log.
+// A checkpoint holds the proof tree (full or pruned), the digest and the number of update batches applied to them.
+// The delta log holds every batch of (updateindex, delta) in order. On restart, the node loads the last checkpoint
+// and replays the batches of the log from Checkpoint.Seq on, see RestoreProofTree.
+type Checkpoint struct {
+	Digest mcl.G1
+	Seq    uint64 // Number of batches of the delta log applied to the proof tree and the digest
+}
+
+// Checks that a checkpoint or a delta log was written for this ell and setup.
+func (vcs *VCS) checkStateFile(fileName string, h FileHeader) error {
+	if h.L != vcs.L || h.SetupID != vcs.setupID {
+		return fmt.Errorf("%w: %s was written for ell %d and setup %x, want ell %d and setup %x", ErrParamMismatch, fileName, h.L, h.SetupID[:8], vcs.L, vcs.setupID[:8])
+	}
+	return nil
+}
+
+// Writes to fileName.tmp and renames it once it is complete, so a crash leaves the previous checkpoint in place.
+func (vcs *VCS) writeCheckpoint(fileName string, header FileHeader, write func(w io.Writer) error) error {
+	f, err := createKeyFile(fileName+".tmp", header)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+func writeUint64(w io.Writer, x uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, x)
+	_, err := w.Write(buf)
+	return err
+}
+
+// Saves a proof tree from OpenAll or UpdateProofTreeBulk. Level i must have 2^i nodes.
+//
+// Payload: Seq, the digest and the nodes level by level from the root.
+func (vcs *VCS) SaveProofTree(fileName string, proofTree [][]mcl.G1, cp Checkpoint) error {
+	if err := vcs.checkProofTree(proofTree); err != nil {
+		return err
+	}
+	header := vcs.newFileHeader(FILE_CHECKPOINT, 1, 0, 0, proofTreeSize(vcs.L))
+	return vcs.writeCheckpoint(fileName, header, func(w io.Writer) error {
+		if err := writeUint64(w, cp.Seq); err != nil {
+			return err
+		}
+		if _, err := w.Write(cp.Digest.Serialize()); err != nil {
+			return err
+		}
+		return writeProofTree(w, proofTree)
+	})
+}
+
+func (vcs *VCS) checkProofTree(proofTree [][]mcl.G1) error {
+	if len(proofTree) != int(vcs.L) {
+		return fmt.Errorf("%w: proof tree of ell %d, want %d", ErrInvalidParam, len(proofTree), vcs.L)
+	}
+	for i := range proofTree {
+		if len(proofTree[i]) != 1<<i {
+			return fmt.Errorf("%w: level %d of the proof tree has %d nodes, want %d", ErrInvalidParam, i, len(proofTree[i]), 1<<i)
+		}
+	}
+	return nil
+}
+
+func writeProofTree(w io.Writer, proofTree [][]mcl.G1) error {
+	for i := range proofTree {
+		for j := range proofTree[i] {
+			if _, err := w.Write(proofTree[i][j].Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (vcs *VCS) readProofTree(er *elementReader) [][]mcl.G1 {
+	proofTree := make([][]mcl.G1, vcs.L)
+	for i := range proofTree {
+		proofTree[i] = make([]mcl.G1, 1<<i)
+		for j := range proofTree[i] {
+			er.G1(&proofTree[i][j])
+		}
+	}
+	return proofTree
+}
+
+func (vcs *VCS) LoadProofTree(fileName string) ([][]mcl.G1, Checkpoint, error) {
+	var cp Checkpoint
+	f, err := openKeyFile(fileName, FILE_CHECKPOINT)
+	if err != nil {
+		return nil, cp, err
+	}
+	defer f.Close()
+	if err = vcs.checkStateFile(fileName, f.Header); err != nil {
+		return nil, cp, err
+	}
+	if err = f.expectPayload(8 + int64(proofTreeSize(vcs.L)+1)*int64(GetG1ByteSize())); err != nil {
+		return nil, cp, err
+	}
+
+	er := elementReader{r: f}
+	er.Uint64(&cp.Seq)
+	er.G1(&cp.Digest)
+	proofTree := vcs.readProofTree(&er)
+	if er.err != nil {
+		return nil, cp, fmt.Errorf("%s: %w", fileName, er.err)
+	}
+	if err = f.Verify(); err != nil {
+		return nil, cp, err
+	}
+	return proofTree, cp, nil
+}
+
+// Saves a pruned proof tree, as UpdateProofTreeBulkDB updates it.
+//
+// Payload: Seq, the digest, then for every level the number of nodes and (index, node) in increasing index.
+func (vcs *VCS) SavePrunedProofTree(fileName string, proofTree []map[uint64]mcl.G1, cp Checkpoint) error {
+	if len(proofTree) != int(vcs.L) {
+		return fmt.Errorf("%w: proof tree of ell %d, want %d", ErrInvalidParam, len(proofTree), vcs.L)
+	}
+	nodes := uint64(0)
+	for i := range proofTree {
+		nodes += uint64(len(proofTree[i]))
+	}
+	header := vcs.newFileHeader(FILE_PRUNED_CHECKPOINT, 1, 0, 0, nodes)
+	return vcs.writeCheckpoint(fileName, header, func(w io.Writer) error {
+		if err := writeUint64(w, cp.Seq); err != nil {
+			return err
+		}
+		if _, err := w.Write(cp.Digest.Serialize()); err != nil {
+			return err
+		}
+		for i := range proofTree {
+			keys := make([]uint64, 0, len(proofTree[i]))
+			for k := range proofTree[i] {
+				keys = append(keys, k)
+			}
+			sort.Slice(keys, func(a, b int) bool { return keys[a] < keys[b] })
+			if err := writeUint64(w, uint64(len(keys))); err != nil {
+				return err
+			}
+			for _, k := range keys {
+				node := proofTree[i][k]
+				if err := writeUint64(w, k); err != nil {
+					return err
+				}
+				if _, err := w.Write(node.Serialize()); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (vcs *VCS) LoadPrunedProofTree(fileName string) ([]map[uint64]mcl.G1, Checkpoint, error) {
+	var cp Checkpoint
+	f, err := openKeyFile(fileName, FILE_PRUNED_CHECKPOINT)
+	if err != nil {
+		return nil, cp, err
+	}
+	defer f.Close()
+	h := f.Header
+	if err = vcs.checkStateFile(fileName, h); err != nil {
+		return nil, cp, err
+	}
+	if err = f.expectPayload(8 + 8*int64(vcs.L) + int64(h.Stop)*(8+int64(GetG1ByteSize())) + int64(GetG1ByteSize())); err != nil {
+		return nil, cp, err
+	}
+
+	er := elementReader{r: f}
+	er.Uint64(&cp.Seq)
+	er.G1(&cp.Digest)
+	proofTree := make([]map[uint64]mcl.G1, vcs.L)
+	total := uint64(0)
+	for i := range proofTree {
+		var count uint64
+		er.Uint64(&count)
+		total += count
+		if er.err == nil && total > h.Stop {
+			return nil, cp, fmt.Errorf("%w: %s holds more nodes than its header says", ErrCorruptKeyFile, fileName)
+		}
+		proofTree[i] = make(map[uint64]mcl.G1, count)
+		for j := uint64(0); j < count && er.err == nil; j++ {
+			var k uint64
+			var node mcl.G1
+			er.Uint64(&k)
+			er.G1(&node)
+			if k >= uint64(1)<<i {
+				return nil, cp, fmt.Errorf("%w: %s: node %d of level %d", ErrCorruptKeyFile, fileName, k, i)
+			}
+			proofTree[i][k] = node
+		}
+	}
+	if er.err != nil {
+		return nil, cp, fmt.Errorf("%s: %w", fileName, er.err)
+	}
+	if err = f.Verify(); err != nil {
+		return nil, cp, err
+	}
+	return proofTree, cp, nil
+}
+
+// Append only log of update batches. Every batch is one record:
+//
+//	seq uint64, count uint32, crc32 of seq and count, count times (updateindex uint64, delta Fr), blake2b-256 of the record
+//
+// The header of the file is a key file header of kind FILE_DELTA_LOG without a payload checksum, as the file grows.
+// Start in the header is the seq of the first record, so a log can be started over after a checkpoint.
+// A record that was cut short by a crash is dropped when the log is opened. The crc32 tells a record cut short
+// from a corrupt count, and a record of full length that does not match its checksum is corrupt, not torn.
+// The offset of every record is kept in memory, 8 bytes per batch, so a replay reads only the batches it asks for.
+type DeltaLog struct {
+	f        *os.File
+	vcs      *VCS
+	first    uint64  // Seq of the first batch in the file
+	next     uint64  // Seq of the next batch
+	offsets  []int64 // Offset in the file of batch first + i
+	end      int64   // Offset of the next batch
+	fileName string
+}
+
+// Opens the delta log in fileName, or creates it if it does not exist.
+func (vcs *VCS) OpenDeltaLog(fileName string) (*DeltaLog, error) {
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	log := DeltaLog{f: f, vcs: vcs, fileName: fileName}
+
+	fi, err := f.Stat()
+	if err == nil && fi.Size() == 0 {
+		header := vcs.newFileHeader(FILE_DELTA_LOG, 1, 0, 0, 0)
+		if _, err = f.Write(header.Serialize()); err == nil {
+			err = f.Sync()
+		}
+	}
+	if err == nil {
+		err = log.scan()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &log, nil
+}
+
+// Replaces the delta log in fileName by an empty one whose first batch gets seq first.
+func (vcs *VCS) createDeltaLog(fileName string, first uint64) (*DeltaLog, error) {
+	f, err := os.Create(fileName + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	header := vcs.newFileHeader(FILE_DELTA_LOG, 1, 0, first, first)
+	if _, err = f.Write(header.Serialize()); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(fileName+".tmp", fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vcs.OpenDeltaLog(fileName)
+}
+
+// Checks the header and the records, drops a torn record at the end and positions the file for Append.
+func (log *DeltaLog) scan() error {
+	buf := make([]byte, HEADER_SIZE)
+	if _, err := log.f.ReadAt(buf, 0); err != nil {
+		return fmt.Errorf("%w: %s: header: %v", ErrCorruptKeyFile, log.fileName, err)
+	}
+	var h FileHeader
+	if err := h.Deserialize(buf); err != nil {
+		return fmt.Errorf("%s: %w", log.fileName, err)
+	}
+	if h.Kind != FILE_DELTA_LOG {
+		return fmt.Errorf("%w: %s holds %s, want a delta log", ErrParamMismatch, log.fileName, fileKindNames[h.Kind])
+	}
+	if err := log.vcs.checkStateFile(log.fileName, h); err != nil {
+		return err
+	}
+	log.first = h.Start
+	log.next = h.Start
+	log.offsets = nil
+
+	end := int64(HEADER_SIZE)
+	err := log.records(end, log.first, math.MaxUint64, func(seq uint64, size int64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		log.offsets = append(log.offsets, end)
+		end += size
+		log.next = seq + 1
+		return nil
+	})
+	if err == io.ErrUnexpectedEOF {
+		// Torn write of the last record.
+		if err = log.f.Truncate(end); err == nil {
+			err = log.f.Sync()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.end = end
+	_, err = log.f.Seek(end, io.SeekStart)
+	return err
+}
+
+// Reads at most n records from offset on, in order, and hands them to f. The first one must be batch first.
+// Returns io.ErrUnexpectedEOF if the last record is incomplete.
+func (log *DeltaLog) records(offset int64, first uint64, n uint64, f func(seq uint64, size int64, updateindexVec []uint64, deltaVec []mcl.Fr) error) error {
+	fi, err := log.f.Stat()
+	if err != nil {
+		return err
+	}
+	remaining := fi.Size() - offset
+	r := bufio.NewReader(io.NewSectionReader(log.f, offset, remaining))
+	frSize := GetFrByteSize()
+	for expected := first; expected-first < n; expected++ {
+		head := make([]byte, 16)
+		if _, err := io.ReadFull(r, head); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		remaining -= int64(len(head))
+		if crc32.ChecksumIEEE(head[:12]) != binary.LittleEndian.Uint32(head[12:16]) {
+			return fmt.Errorf("%w: %s: batch %d: corrupt record header", ErrCorruptKeyFile, log.fileName, expected)
+		}
+		seq := binary.LittleEndian.Uint64(head[0:8])
+		count := binary.LittleEndian.Uint32(head[8:12])
+		if seq != expected {
+			return fmt.Errorf("%w: %s: found batch %d, want %d", ErrCorruptKeyFile, log.fileName, seq, expected)
+		}
+		size := int64(count)*int64(8+frSize) + blake2b.Size256
+		if size > remaining {
+			return io.ErrUnexpectedEOF // The last record, partly written
+		}
+		remaining -= size
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return fmt.Errorf("%w: %s: batch %d: %v", ErrCorruptKeyFile, log.fileName, expected, err)
+		}
+		hasher, _ := blake2b.New256(nil)
+		hasher.Write(head)
+		hasher.Write(body[:len(body)-blake2b.Size256])
+		if !bytes.Equal(hasher.Sum(nil), body[len(body)-blake2b.Size256:]) {
+			return fmt.Errorf("%w: %s: batch %d: checksum mismatch", ErrCorruptKeyFile, log.fileName, expected)
+		}
+
+		updateindexVec := make([]uint64, count)
+		deltaVec := make([]mcl.Fr, count)
+		for i := range updateindexVec {
+			entry := body[i*(8+frSize) : (i+1)*(8+frSize)]
+			updateindexVec[i] = binary.LittleEndian.Uint64(entry[:8])
+			if err := deltaVec[i].Deserialize(entry[8:]); err != nil {
+				return fmt.Errorf("%w: %s: batch %d: %v", ErrCorruptKeyFile, log.fileName, seq, err)
+			}
+		}
+		if err := f(seq, int64(len(head)+len(body)), updateindexVec, deltaVec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Seq the next batch gets.
+func (log *DeltaLog) Next() uint64 {
+	return log.next
+}
+
+// Appends a batch and syncs it to disk. Returns the seq of the batch.
+func (log *DeltaLog) Append(updateindexVec []uint64, deltaVec []mcl.Fr) (uint64, error) {
+	if len(updateindexVec) != len(deltaVec) || uint64(len(updateindexVec)) > 1<<32-1 {
+		return 0, fmt.Errorf("%w: %d indices and %d deltas", ErrInvalidParam, len(updateindexVec), len(deltaVec))
+	}
+	for t := range updateindexVec {
+		if updateindexVec[t] >= log.vcs.N {
+			return 0, fmt.Errorf("%w: entry %d has index %d, vector size %d", ErrIndexOutOfRange, t, updateindexVec[t], log.vcs.N)
+		}
+	}
+
+	record := make([]byte, 16, 16+len(updateindexVec)*(8+GetFrByteSize())+blake2b.Size256)
+	binary.LittleEndian.PutUint64(record[0:8], log.next)
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(updateindexVec)))
+	binary.LittleEndian.PutUint32(record[12:16], crc32.ChecksumIEEE(record[:12]))
+	index := make([]byte, 8)
+	for t := range updateindexVec {
+		binary.LittleEndian.PutUint64(index, updateindexVec[t])
+		record = append(record, index...)
+		record = append(record, deltaVec[t].Serialize()...)
+	}
+	sum := blake2b.Sum256(record)
+	record = append(record, sum[:]...)
+
+	if _, err := log.f.Write(record); err != nil {
+		return 0, err
+	}
+	if err := log.f.Sync(); err != nil {
+		return 0, err
+	}
+	log.offsets = append(log.offsets, log.end)
+	log.end += int64(len(record))
+	log.next++
+	return log.next - 1, nil
+}
+
+// Hands the batches from seq from on to apply, in order.
+func (log *DeltaLog) Replay(from uint64, apply func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error) error {
+	return log.ReplayRange(from, log.next, apply)
+}
+
+// Hands the batches with seq in [from, to) to apply, in order.
+func (log *DeltaLog) ReplayRange(from uint64, to uint64, apply func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error) error {
+	if from > to || to > log.next || from < log.first {
+		return fmt.Errorf("%w: %s: replay batches [%d, %d), the log holds batches [%d, %d)", ErrParamMismatch, log.fileName, from, to, log.first, log.next)
+	}
+	if from == to {
+		return nil
+	}
+	return log.records(log.offsets[from-log.first], from, to-from, func(seq uint64, size int64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		return apply(seq, updateindexVec, deltaVec)
+	})
+}
+
+func (log *DeltaLog) Close() error {
+	return log.f.Close()
+}
+
+// Loads the checkpoint in fileName and replays the batches of the log it does not have.
+func (vcs *VCS) RestoreProofTree(fileName string, log *DeltaLog) ([][]mcl.G1, Checkpoint, error) {
+	proofTree, cp, err := vcs.LoadProofTree(fileName)
+	if err != nil {
+		return nil, cp, err
+	}
+	err = log.Replay(cp.Seq, func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		vcs.UpdateProofTreeBulkInPlace(proofTree, updateindexVec, deltaVec)
+		cp.Digest = vcs.UpdateComVec(cp.Digest, updateindexVec, deltaVec)
+		cp.Seq = seq + 1
+		return nil
+	})
+	if err != nil {
+		return nil, cp, err
+	}
+	return proofTree, cp, nil
+}
+
+// Same as RestoreProofTree, for a pruned proof tree. upk_db holds the UPK of every index, as for UpdateProofTreeBulkDB.
+// The log must only touch indices in upk_db.
+func (vcs *VCS) RestorePrunedProofTree(fileName string, log *DeltaLog, upk_db map[uint64][]mcl.G1) ([]map[uint64]mcl.G1, Checkpoint, error) {
+	proofTree, cp, err := vcs.LoadPrunedProofTree(fileName)
+	if err != nil {
+		return nil, cp, err
+	}
+	err = log.Replay(cp.Seq, func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		for t := range updateindexVec {
+			if _, ok := upk_db[updateindexVec[t]]; !ok {
+				return fmt.Errorf("%w: batch %d updates index %d, which has no UPK", ErrIndexOutOfRange, seq, updateindexVec[t])
+			}
+		}
+		proofTree, _ = vcs.UpdateProofTreeBulkDB(proofTree, upk_db, updateindexVec, deltaVec)
+		cp.Digest = vcs.UpdateComVecDB(upk_db, cp.Digest, updateindexVec, deltaVec)
+		cp.Seq = seq + 1
+		return nil
+	})
+	if err != nil {
+		return nil, cp, err
+	}
+	return proofTree, cp, nil
+}
@@ -0,0 +1,285 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestCheckpoint(t *testing.T) {
+
+	L := uint8(5)
+	vcs := newTestVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+	folder := t.TempDir()
+
+	batches := [][]uint64{{3, 17}, {17, 31, 0}, {8}}
+	deltas := make([][]mcl.Fr, len(batches))
+	for i := range batches {
+		deltas[i] = GenerateVectorSeeded(uint64(len(batches[i])), []byte(fmt.Sprintf("deltas-%d", i)))
+	}
+
+	t.Run(fmt.Sprintf("%d/ProofTree;", L), func(t *testing.T) {
+		fileName := folder + "/checkpoint.data"
+		if err := vcs.SaveProofTree(fileName, vcs.ProofTree, Checkpoint{Digest: digest, Seq: 4}); err != nil {
+			t.Fatal(err)
+		}
+		proofTree, cp, err := vcs.LoadProofTree(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp.Seq != 4 || !cp.Digest.IsEqual(&digest) {
+			t.Errorf("Checkpoint does not match: %d", cp.Seq)
+		}
+		for x := range proofTree {
+			for y := range proofTree[x] {
+				if !proofTree[x][y].IsEqual(&vcs.ProofTree[x][y]) {
+					t.Errorf("Node %d of level %d does not match", y, x)
+				}
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Restore;", L), func(t *testing.T) {
+		fileName := folder + "/restore.data"
+		log, err := vcs.OpenDeltaLog(folder + "/restore.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+
+		// Checkpoint after the first batch, then two more batches are only in the log.
+		proofTree := vcs.ProofTree
+		want := digest
+		for i := range batches {
+			seq, err := log.Append(batches[i], deltas[i])
+			if err != nil || seq != uint64(i) {
+				t.Fatalf("Append returned %d, %v", seq, err)
+			}
+			proofTree, _ = vcs.UpdateProofTreeBulk(proofTree, batches[i], deltas[i])
+			want = vcs.UpdateComVec(want, batches[i], deltas[i])
+			if i == 0 {
+				if err = vcs.SaveProofTree(fileName, proofTree, Checkpoint{Digest: want, Seq: log.Next()}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		log.Close()
+
+		log, err = vcs.OpenDeltaLog(folder + "/restore.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if log.Next() != uint64(len(batches)) {
+			t.Errorf("Reopened log ends at %d, want %d", log.Next(), len(batches))
+		}
+		restored, cp, err := vcs.RestoreProofTree(fileName, log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp.Seq != uint64(len(batches)) || !cp.Digest.IsEqual(&want) {
+			t.Errorf("Restored checkpoint at %d does not match", cp.Seq)
+		}
+		for x := range restored {
+			for y := range restored[x] {
+				if !restored[x][y].IsEqual(&proofTree[x][y]) {
+					t.Errorf("Node %d of level %d does not match", y, x)
+				}
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Pruned;", L), func(t *testing.T) {
+		fileName := folder + "/pruned.data"
+		indices := []uint64{0, 3, 8, 17, 31}
+		upk_db := make(map[uint64][]mcl.G1)
+		pruned := make([]map[uint64]mcl.G1, L)
+		for i := range pruned {
+			pruned[i] = make(map[uint64]mcl.G1)
+		}
+		for _, index := range indices {
+			upk_db[index] = vcs.GetUpk(index)
+			for j := uint8(0); j < L; j++ {
+				id := index >> (j + 1)
+				pruned[L-j-1][id] = vcs.ProofTree[L-j-1][id]
+			}
+		}
+		if err := vcs.SavePrunedProofTree(fileName, pruned, Checkpoint{Digest: digest}); err != nil {
+			t.Fatal(err)
+		}
+		log, err := vcs.OpenDeltaLog(folder + "/pruned.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+		want := digest
+		proofTree := vcs.ProofTree
+		for i := range batches {
+			if _, err = log.Append(batches[i], deltas[i]); err != nil {
+				t.Fatal(err)
+			}
+			proofTree, _ = vcs.UpdateProofTreeBulk(proofTree, batches[i], deltas[i])
+			want = vcs.UpdateComVec(want, batches[i], deltas[i])
+		}
+
+		restored, cp, err := vcs.RestorePrunedProofTree(fileName, log, upk_db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cp.Digest.IsEqual(&want) {
+			t.Errorf("Restored digest does not match")
+		}
+		for x := range restored {
+			if len(restored[x]) != len(pruned[x]) {
+				t.Errorf("Level %d has %d nodes, want %d", x, len(restored[x]), len(pruned[x]))
+			}
+			for y, node := range restored[x] {
+				if !node.IsEqual(&proofTree[x][y]) {
+					t.Errorf("Node %d of level %d does not match", y, x)
+				}
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Range;", L), func(t *testing.T) {
+		log, err := vcs.OpenDeltaLog(folder + "/range.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+		for i := range batches {
+			log.Append(batches[i], deltas[i])
+		}
+
+		// Batch 2 is corrupted on disk. A replay that stops before it, or starts after batch 0, reads only its own batches.
+		log.f.WriteAt([]byte{0xff}, log.offsets[2]+20)
+		for _, r := range [][2]uint64{{0, 2}, {1, 2}, {0, 0}} {
+			var seqs []uint64
+			err := log.ReplayRange(r[0], r[1], func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+				seqs = append(seqs, seq)
+				if len(updateindexVec) != len(batches[seq]) || !deltaVec[0].IsEqual(&deltas[seq][0]) {
+					t.Errorf("Batch %d does not match", seq)
+				}
+				return nil
+			})
+			if err != nil || uint64(len(seqs)) != r[1]-r[0] || (len(seqs) > 0 && seqs[0] != r[0]) {
+				t.Errorf("Replay of [%d, %d) got %v: %v", r[0], r[1], seqs, err)
+			}
+		}
+		if err := log.ReplayRange(2, 3, func(uint64, []uint64, []mcl.Fr) error { return nil }); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Replay of the corrupt batch: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/TornTail;", L), func(t *testing.T) {
+		logName := folder + "/torn.log"
+		log, err := vcs.OpenDeltaLog(logName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range batches {
+			if _, err = log.Append(batches[i], deltas[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		log.Close()
+		fi, _ := os.Stat(logName)
+		os.Truncate(logName, fi.Size()-5)
+
+		log, err = vcs.OpenDeltaLog(logName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+		if log.Next() != uint64(len(batches)-1) {
+			t.Errorf("Log ends at %d, want %d", log.Next(), len(batches)-1)
+		}
+		seq, err := log.Append(batches[2], deltas[2])
+		if err != nil || seq != uint64(len(batches)-1) {
+			t.Errorf("Append after a torn record returned %d, %v", seq, err)
+		}
+		count := 0
+		err = log.Replay(1, func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+			count++
+			return nil
+		})
+		if err != nil || count != 2 {
+			t.Errorf("Replayed %d batches, want 2: %v", count, err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Errors;", L), func(t *testing.T) {
+		fileName := folder + "/corrupt.data"
+		if err := vcs.SaveProofTree(fileName, vcs.ProofTree, Checkpoint{Digest: digest}); err != nil {
+			t.Fatal(err)
+		}
+		f, _ := os.OpenFile(fileName, os.O_RDWR, 0)
+		f.WriteAt([]byte{0xff}, HEADER_SIZE+200)
+		f.Close()
+		if _, _, err := vcs.LoadProofTree(fileName); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Corrupt checkpoint: got %v", err)
+		}
+
+		// A middle record that does not match its checksum is not a torn write.
+		logName := folder + "/corrupt.log"
+		log, err := vcs.OpenDeltaLog(logName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range batches {
+			log.Append(batches[i], deltas[i])
+		}
+		log.Close()
+		f, _ = os.OpenFile(logName, os.O_RDWR, 0)
+		f.WriteAt([]byte{0xff}, HEADER_SIZE+20)
+		f.Close()
+		if _, err := vcs.OpenDeltaLog(logName); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Corrupt delta log: got %v", err)
+		}
+
+		// The same for the last record, and for a count that asks for more than the file holds.
+		for name, offset := range map[string]int64{"Last": -1, "Count": HEADER_SIZE + 11} {
+			logName := folder + "/corrupt-" + name + ".log"
+			log, err := vcs.OpenDeltaLog(logName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range batches {
+				log.Append(batches[i], deltas[i])
+			}
+			log.Close()
+			if offset < 0 {
+				fi, _ := os.Stat(logName)
+				offset += fi.Size()
+			}
+			f, _ = os.OpenFile(logName, os.O_RDWR, 0)
+			f.WriteAt([]byte{0x7f}, offset)
+			f.Close()
+			if _, err := vcs.OpenDeltaLog(logName); !errors.Is(err, ErrCorruptKeyFile) {
+				t.Errorf("%s: got %v", name, err)
+			}
+		}
+
+		other := newTestVCS(t, L, 2)
+		if _, _, err := other.LoadProofTree(folder + "/checkpoint.data"); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Checkpoint of another setup: got %v", err)
+		}
+		if _, err := other.OpenDeltaLog(folder + "/restore.log"); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Delta log of another setup: got %v", err)
+		}
+
+		log, _ = vcs.OpenDeltaLog(folder + "/errors.log")
+		defer log.Close()
+		if _, err := log.Append([]uint64{vcs.N}, deltas[2]); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Out of range index: got %v", err)
+		}
+		if _, err := log.Append(batches[0], deltas[2]); !errors.Is(err, ErrInvalidParam) {
+			t.Errorf("Length mismatch: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package vcs
+
+/*
+#include <mcl/bn.h>
+*/
+import "C"
+import (
+	"bytes"
+	"fmt"
+	"unsafe"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+	"github.com/hyperproofs/gipa-go/cm"
+)
+
+// Binary encodings of digests, proof paths, proof trees and aggregated proofs.
+// Every encoding starts with a 3 byte header, followed by the elements:
+//
+//	offset  size  field
+//	0       1     kind (ENC_DIGEST, ENC_PROOF, ...)
+//	1       1     point format (COMPRESSED or UNCOMPRESSED)
+//	2       1     ell of the proof or proof tree, number of GIPA rounds of an aggregated proof, 0 for a digest
+//
+// Points use the mcl serialization, GT elements are always 576 bytes.
+// The decoders only accept the exact encoding the encoders produce: wrong sizes, trailing bytes,
+// non-canonical points and points outside the prime order subgroup are reported as ErrInvalidEncoding.
+const (
+	ENC_DIGEST     = 1
+	ENC_PROOF      = 2
+	ENC_PROOF_TREE = 3
+	ENC_AGG_PROOF  = 4
+)
+
+const ENC_HEADER_SIZE = 3
+
+type PointFormat uint8
+
+const (
+	COMPRESSED   PointFormat = 0
+	UNCOMPRESSED PointFormat = 1
+)
+
+var encKindNames = map[uint8]string{
+	ENC_DIGEST:     "digest",
+	ENC_PROOF:      "proof",
+	ENC_PROOF_TREE: "proof tree",
+	ENC_AGG_PROOF:  "aggregated proof",
+}
+
+func (format PointFormat) g1Size() int {
+	if format == UNCOMPRESSED {
+		return 2 * GetG1ByteSize()
+	}
+	return GetG1ByteSize()
+}
+
+func (format PointFormat) g2Size() int {
+	if format == UNCOMPRESSED {
+		return 2 * GetG2ByteSize()
+	}
+	return GetG2ByteSize()
+}
+
+func gtSize() int {
+	return 12 * mcl.GetFpByteSize()
+}
+
+func (format PointFormat) encodeG1(p *mcl.G1) []byte {
+	if format == UNCOMPRESSED {
+		return p.SerializeUncompressed()
+	}
+	return p.Serialize()
+}
+
+func (format PointFormat) encodeG2(p *mcl.G2) []byte {
+	if format == UNCOMPRESSED {
+		return p.SerializeUncompressed()
+	}
+	return p.Serialize()
+}
+
+// Checks x^r == 1, that is x is in GT and not only in Fp12.
+// mcl.GTPow assumes its input is in GT, so this uses the generic exponentiation.
+func gtIsValidOrder(x *mcl.GT) bool {
+	var minusOne mcl.Fr
+	minusOne.SetInt64(-1)
+	var y mcl.GT
+	C.mclBnGT_powGeneric((*C.mclBnGT)(unsafe.Pointer(&y)), (*C.mclBnGT)(unsafe.Pointer(x)), (*C.mclBnFr)(unsafe.Pointer(&minusOne)))
+	mcl.GTMul(&y, &y, x)
+	return y.IsOne()
+}
+
+func encodeHeader(kind uint8, format PointFormat, ell uint8) []byte {
+	return []byte{kind, uint8(format), ell}
+}
+
+// Reads the elements of an encoding. The first error is kept and later reads are no-ops, as in elementReader.
+type decoder struct {
+	buf    []byte
+	format PointFormat
+	err    error
+}
+
+// Parses the header and checks that the payload has the size implied by it. size is negative for an invalid ell.
+func newDecoder(buf []byte, kind uint8, size func(format PointFormat, ell uint8) int) (*decoder, uint8, error) {
+	if len(buf) < ENC_HEADER_SIZE {
+		return nil, 0, fmt.Errorf("%w: %d bytes is too short for a %s", ErrInvalidEncoding, len(buf), encKindNames[kind])
+	}
+	if buf[0] != kind {
+		return nil, 0, fmt.Errorf("%w: encodes a %s, want a %s", ErrInvalidEncoding, encKindNames[buf[0]], encKindNames[kind])
+	}
+	format := PointFormat(buf[1])
+	if format != COMPRESSED && format != UNCOMPRESSED {
+		return nil, 0, fmt.Errorf("%w: point format %d", ErrInvalidEncoding, buf[1])
+	}
+	ell := buf[2]
+	n := size(format, ell)
+	if n < 0 {
+		return nil, 0, fmt.Errorf("%w: %s of %d", ErrInvalidEncoding, encKindNames[kind], ell)
+	}
+	if len(buf) != ENC_HEADER_SIZE+n {
+		return nil, 0, fmt.Errorf("%w: %s of %d is %d bytes, want %d", ErrInvalidEncoding, encKindNames[kind], ell, len(buf), ENC_HEADER_SIZE+n)
+	}
+	return &decoder{buf: buf[ENC_HEADER_SIZE:], format: format}, ell, nil
+}
+
+func (d *decoder) next(size int) []byte {
+	b := d.buf[:size]
+	d.buf = d.buf[size:]
+	return b
+}
+
+func (d *decoder) G1(p *mcl.G1) {
+	if d.err != nil {
+		return
+	}
+	b := d.next(d.format.g1Size())
+	var err error
+	if d.format == UNCOMPRESSED {
+		err = p.DeserializeUncompressed(b)
+	} else {
+		err = p.Deserialize(b)
+	}
+	switch {
+	case err != nil:
+		d.err = fmt.Errorf("%w: not a G1 point: %v", ErrInvalidEncoding, err)
+	case !p.IsValidOrder():
+		d.err = fmt.Errorf("%w: G1 point is not in the subgroup", ErrInvalidEncoding)
+	case !bytes.Equal(d.format.encodeG1(p), b):
+		d.err = fmt.Errorf("%w: G1 point is not canonical", ErrInvalidEncoding)
+	}
+}
+
+func (d *decoder) G2(p *mcl.G2) {
+	if d.err != nil {
+		return
+	}
+	b := d.next(d.format.g2Size())
+	var err error
+	if d.format == UNCOMPRESSED {
+		err = p.DeserializeUncompressed(b)
+	} else {
+		err = p.Deserialize(b)
+	}
+	switch {
+	case err != nil:
+		d.err = fmt.Errorf("%w: not a G2 point: %v", ErrInvalidEncoding, err)
+	case !p.IsValidOrder():
+		d.err = fmt.Errorf("%w: G2 point is not in the subgroup", ErrInvalidEncoding)
+	case !bytes.Equal(d.format.encodeG2(p), b):
+		d.err = fmt.Errorf("%w: G2 point is not canonical", ErrInvalidEncoding)
+	}
+}
+
+func (d *decoder) GT(x *mcl.GT) {
+	if d.err != nil {
+		return
+	}
+	b := d.next(gtSize())
+	switch err := x.Deserialize(b); {
+	case err != nil:
+		d.err = fmt.Errorf("%w: not a GT element: %v", ErrInvalidEncoding, err)
+	case !bytes.Equal(x.Serialize(), b):
+		d.err = fmt.Errorf("%w: GT element is not canonical", ErrInvalidEncoding)
+	case !gtIsValidOrder(x):
+		d.err = fmt.Errorf("%w: GT element is not in the subgroup", ErrInvalidEncoding)
+	}
+}
+
+func EncodeDigest(digest mcl.G1, format PointFormat) []byte {
+	return append(encodeHeader(ENC_DIGEST, format, 0), format.encodeG1(&digest)...)
+}
+
+func DecodeDigest(buf []byte) (mcl.G1, error) {
+	var digest mcl.G1
+	d, _, err := newDecoder(buf, ENC_DIGEST, func(format PointFormat, ell uint8) int {
+		if ell != 0 {
+			return -1
+		}
+		return format.g1Size()
+	})
+	if err != nil {
+		return digest, err
+	}
+	d.G1(&digest)
+	return digest, d.err
+}
+
+// Encodes a proof path from GetProofPath. Proofs longer than 255 are ErrInvalidParam.
+func EncodeProof(proof []mcl.G1, format PointFormat) ([]byte, error) {
+	if len(proof) > 255 {
+		return nil, fmt.Errorf("%w: proof of length %d", ErrInvalidParam, len(proof))
+	}
+	buf := encodeHeader(ENC_PROOF, format, uint8(len(proof)))
+	for i := range proof {
+		buf = append(buf, format.encodeG1(&proof[i])...)
+	}
+	return buf, nil
+}
+
+func DecodeProof(buf []byte) ([]mcl.G1, error) {
+	d, ell, err := newDecoder(buf, ENC_PROOF, func(format PointFormat, ell uint8) int {
+		return int(ell) * format.g1Size()
+	})
+	if err != nil {
+		return nil, err
+	}
+	proof := make([]mcl.G1, ell)
+	for i := range proof {
+		d.G1(&proof[i])
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return proof, nil
+}
+
+// Encodes a proof tree from OpenAll, level by level from the root. Level i must have 2^i nodes.
+func EncodeProofTree(proofTree [][]mcl.G1, format PointFormat) ([]byte, error) {
+	if len(proofTree) == 0 || len(proofTree) >= 32 {
+		return nil, fmt.Errorf("%w: proof tree of ell %d", ErrInvalidParam, len(proofTree))
+	}
+	for i := range proofTree {
+		if len(proofTree[i]) != 1<<i {
+			return nil, fmt.Errorf("%w: level %d of the proof tree has %d nodes, want %d", ErrInvalidParam, i, len(proofTree[i]), 1<<i)
+		}
+	}
+	ell := uint8(len(proofTree))
+	buf := make([]byte, 0, ENC_HEADER_SIZE+int(proofTreeSize(ell))*format.g1Size())
+	buf = append(buf, encodeHeader(ENC_PROOF_TREE, format, ell)...)
+	for i := range proofTree {
+		for j := range proofTree[i] {
+			buf = append(buf, format.encodeG1(&proofTree[i][j])...)
+		}
+	}
+	return buf, nil
+}
+
+func DecodeProofTree(buf []byte) ([][]mcl.G1, error) {
+	d, ell, err := newDecoder(buf, ENC_PROOF_TREE, func(format PointFormat, ell uint8) int {
+		if ell == 0 || ell >= 32 {
+			return -1
+		}
+		return int(proofTreeSize(ell)) * format.g1Size()
+	})
+	if err != nil {
+		return nil, err
+	}
+	proofTree := make([][]mcl.G1, ell)
+	for i := range proofTree {
+		proofTree[i] = make([]mcl.G1, 1<<i)
+		for j := range proofTree[i] {
+			d.G1(&proofTree[i][j])
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return proofTree, nil
+}
+
+// Size of an aggregated proof with the given number of GIPA rounds, without the header.
+func aggProofSize(format PointFormat, rounds uint8) int {
+	return gtSize()*(1+6*int(rounds)) + 3*format.g1Size() + 3*format.g2Size()
+}
+
+// Encodes an aggregated proof from AggProve:
+// T, the rounds (L[i] then R[i], 3 GT elements each), A, B, W, V, Pi1 and Pi2.
+func EncodeAggProof(proof batch.Proof, format PointFormat) ([]byte, error) {
+	p := &proof.GipaKzgProof
+	if len(p.L) != len(p.R) || len(p.L) > 255 {
+		return nil, fmt.Errorf("%w: aggregated proof with %d left and %d right commitments", ErrInvalidParam, len(p.L), len(p.R))
+	}
+	rounds := uint8(len(p.L))
+	buf := make([]byte, 0, ENC_HEADER_SIZE+aggProofSize(format, rounds))
+	buf = append(buf, encodeHeader(ENC_AGG_PROOF, format, rounds)...)
+	buf = append(buf, proof.T.Serialize()...)
+	for i := range p.L {
+		for _, com := range []*cm.Com{&p.L[i], &p.R[i]} {
+			for k := range com.Com {
+				buf = append(buf, com.Com[k].Serialize()...)
+			}
+		}
+	}
+	buf = append(buf, format.encodeG1(&p.A[0])...)
+	buf = append(buf, format.encodeG2(&p.B[0])...)
+	buf = append(buf, format.encodeG1(&p.W)...)
+	buf = append(buf, format.encodeG2(&p.V)...)
+	buf = append(buf, format.encodeG1(&p.Pi1)...)
+	buf = append(buf, format.encodeG2(&p.Pi2)...)
+	return buf, nil
+}
+
+func DecodeAggProof(buf []byte) (batch.Proof, error) {
+	var proof batch.Proof
+	d, rounds, err := newDecoder(buf, ENC_AGG_PROOF, aggProofSize)
+	if err != nil {
+		return proof, err
+	}
+	p := &proof.GipaKzgProof
+	d.GT(&proof.T)
+	p.L = make([]cm.Com, rounds)
+	p.R = make([]cm.Com, rounds)
+	for i := range p.L {
+		for _, com := range []*cm.Com{&p.L[i], &p.R[i]} {
+			for k := range com.Com {
+				d.GT(&com.Com[k])
+			}
+		}
+	}
+	d.G1(&p.A[0])
+	d.G2(&p.B[0])
+	d.G1(&p.W)
+	d.G2(&p.V)
+	d.G1(&p.Pi1)
+	d.G2(&p.Pi2)
+	return proof, d.err
+}
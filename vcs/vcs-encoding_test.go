@@ -0,0 +1,127 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+// A point on the G1 curve outside the prime order subgroup.
+func offSubgroupG1() mcl.G1 {
+	var p mcl.G1
+	var b, y2 mcl.Fp
+	b.SetInt64(4)
+	for x := int64(1); ; x++ {
+		p.X.SetInt64(x)
+		mcl.FpSqr(&y2, &p.X)
+		mcl.FpMul(&y2, &y2, &p.X)
+		mcl.FpAdd(&y2, &y2, &b)
+		if mcl.FpSquareRoot(&p.Y, &y2) {
+			p.Z.SetInt64(1)
+			if !p.IsValidOrder() {
+				return p
+			}
+		}
+	}
+}
+
+func TestEncoding(t *testing.T) {
+
+	L := uint8(4)
+	txnLimit := uint64(2)
+	vcs := VCS{}
+	vcs.KeyGenSeededInMemory(4, L, txnLimit, []byte(t.Name()))
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+	indexVec := []uint64{1, 10}
+	valueVec := []mcl.Fr{aFr[1], aFr[10]}
+	proofVec := [][]mcl.G1{vcs.GetProofPath(vcs.ProofTree, 1, L), vcs.GetProofPath(vcs.ProofTree, 10, L)}
+	aggProof := vcs.AggProve(indexVec, proofVec)
+
+	for _, format := range []PointFormat{COMPRESSED, UNCOMPRESSED} {
+
+		t.Run(fmt.Sprintf("%d/RoundTrip;%d", L, format), func(t *testing.T) {
+			d, err := DecodeDigest(EncodeDigest(digest, format))
+			if err != nil || !d.IsEqual(&digest) {
+				t.Fatalf("Digest does not round trip: %v", err)
+			}
+
+			buf, err := EncodeProof(proofVec[0], format)
+			check(err)
+			proof, err := DecodeProof(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !vcs.Verify(digest, indexVec[0], valueVec[0], proof) {
+				t.Errorf("Decoded proof does not verify")
+			}
+
+			buf, err = EncodeProofTree(vcs.ProofTree, format)
+			check(err)
+			tree, err := DecodeProofTree(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range tree {
+				if !SliceIsEqual(tree[i], vcs.ProofTree[i]) {
+					t.Errorf("Level %d of the proof tree does not match", i)
+				}
+			}
+
+			buf, err = EncodeAggProof(aggProof, format)
+			check(err)
+			decoded, err := DecodeAggProof(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !vcs.AggVerify(decoded, digest, indexVec, valueVec) {
+				t.Errorf("Decoded aggregated proof does not verify")
+			}
+		})
+
+		t.Run(fmt.Sprintf("%d/Strict;%d", L, format), func(t *testing.T) {
+			buf, err := EncodeProof(proofVec[0], format)
+			check(err)
+			bad := map[string][]byte{
+				"Empty":    {},
+				"Trailing": append(append([]byte{}, buf...), 0),
+				"Short":    buf[:len(buf)-1],
+				"Kind":     append([]byte{ENC_DIGEST}, buf[1:]...),
+				"Format":   append([]byte{ENC_PROOF, 2}, buf[2:]...),
+				"Ell":      append([]byte{ENC_PROOF, buf[1], L + 1}, buf[3:]...),
+			}
+			p := offSubgroupG1()
+			bad["Subgroup"] = EncodeDigest(p, format)
+			corrupt := append([]byte{}, buf...)
+			corrupt[ENC_HEADER_SIZE] ^= 0x80
+			bad["Point"] = corrupt
+
+			for name, b := range bad {
+				var err error
+				if name == "Subgroup" {
+					_, err = DecodeDigest(b)
+				} else {
+					var proof []mcl.G1
+					proof, err = DecodeProof(b)
+					if err == nil && vcs.Verify(digest, indexVec[0], valueVec[0], proof) {
+						t.Errorf("%s: decoded to a valid proof", name)
+					}
+				}
+				if !errors.Is(err, ErrInvalidEncoding) {
+					t.Errorf("%s: expected ErrInvalidEncoding, got %v", name, err)
+
+				}
+			}
+
+			agg, err := EncodeAggProof(aggProof, format)
+			check(err)
+			agg[ENC_HEADER_SIZE] ^= 1 // First byte of T
+			if _, err := DecodeAggProof(agg); !errors.Is(err, ErrInvalidEncoding) {
+				t.Errorf("Expected ErrInvalidEncoding, got %v", err)
+			}
+		})
+	}
+}
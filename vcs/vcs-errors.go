@@ -0,0 +1,236 @@
+package vcs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+)
+
+// Sentinel errors of the error-returning API (TryInit, TryKeyGenLoad, TryVerify, ...).
+// Returned errors wrap one of these with the details, so test them with errors.Is.
+var (
+	ErrInvalidParam    = errors.New("vcs: invalid parameter")
+	ErrBadProofLength  = errors.New("vcs: bad proof length")
+	ErrIndexOutOfRange = errors.New("vcs: index out of range")
+	ErrParamMismatch   = errors.New("vcs: parameter mismatch")
+	ErrCorruptKeyFile  = errors.New("vcs: corrupt key file")
+	ErrBatchTooLarge   = errors.New("vcs: batch too large")
+	ErrInvalidEncoding = errors.New("vcs: invalid encoding")
+	ErrFieldOverflow   = errors.New("vcs: account field overflow")
+	ErrMapFull         = errors.New("vcs: map is full")
+	ErrInvalidBlock    = errors.New("vcs: invalid block header")
+
+	ErrInvalidContribution = errors.New("vcs: invalid ceremony contribution")
+	ErrInconsistentParams  = errors.New("vcs: inconsistent public parameters")
+)
+
+// Reads exactly size bytes from r and hands them to deserialize.
+// Short reads and points/scalars that do not deserialize are reported as ErrCorruptKeyFile.
+func readElement(r io.Reader, size int, deserialize func([]byte) error) error {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptKeyFile, err)
+	}
+	if err := deserialize(data); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptKeyFile, err)
+	}
+	return nil
+}
+
+func readFr(r io.Reader, x *mcl.Fr) error {
+	return readElement(r, GetFrByteSize(), x.Deserialize)
+}
+
+func readG1(r io.Reader, x *mcl.G1) error {
+	return readElement(r, GetG1ByteSize(), x.Deserialize)
+}
+
+func readG2(r io.Reader, x *mcl.G2) error {
+	return readElement(r, GetG2ByteSize(), x.Deserialize)
+}
+
+// Reads elements one after the other and keeps the first error. Check err once at the end.
+type elementReader struct {
+	r   io.Reader
+	err error
+}
+
+func (er *elementReader) Fr(x *mcl.Fr) {
+	if er.err == nil {
+		er.err = readFr(er.r, x)
+	}
+}
+
+func (er *elementReader) G1(x *mcl.G1) {
+	if er.err == nil {
+		er.err = readG1(er.r, x)
+	}
+}
+
+func (er *elementReader) G2(x *mcl.G2) {
+	if er.err == nil {
+		er.err = readG2(er.r, x)
+	}
+}
+
+func (er *elementReader) Uint64(x *uint64) {
+	if er.err == nil {
+		er.err = readElement(er.r, 8, func(b []byte) error {
+			*x = binary.LittleEndian.Uint64(b)
+			return nil
+		})
+	}
+}
+
+// gipa-go and kzg-go report every failure with a panic.
+// Use as defer recoverAs(&err, sentinel) to turn such a panic into an error.
+func recoverAs(err *error, sentinel error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%w: %v", sentinel, r)
+	}
+}
+
+// Checks that proof is a well-formed proof path for index.
+func (vcs *VCS) checkProof(index uint64, proof []mcl.G1) error {
+	if index >= vcs.N {
+		return fmt.Errorf("%w: index %d, vector size %d", ErrIndexOutOfRange, index, vcs.N)
+	}
+	if len(proof) != int(vcs.L) {
+		return fmt.Errorf("%w: got %d, want %d", ErrBadProofLength, len(proof), vcs.L)
+	}
+	return nil
+}
+
+// Checks that the verification keys are in place.
+func (vcs *VCS) checkVrk() error {
+	if vcs.L == 0 || len(vcs.VRK) != int(vcs.L) || len(vcs.VRKSubOneRev) != int(vcs.L) {
+		return fmt.Errorf("%w: VCS is not initialised", ErrParamMismatch)
+	}
+	return nil
+}
+
+// Same as Verify, but rejects malformed input with an error instead of panicking.
+func (vcs *VCS) TryVerify(digest mcl.G1, index uint64, a_i mcl.Fr, proof []mcl.G1) (bool, error) {
+	if err := vcs.checkVrk(); err != nil {
+		return false, err
+	}
+	if err := vcs.checkProof(index, proof); err != nil {
+		return false, err
+	}
+	return vcs.Verify(digest, index, a_i, proof), nil
+}
+
+// Same as VerifyMemoized, but rejects malformed input with an error instead of panicking.
+func (vcs *VCS) TryVerifyMemoized(digest mcl.G1, indexVec []uint64, a_i []mcl.Fr, proofVec [][]mcl.G1) (bool, int, error) {
+	if err := vcs.checkVrk(); err != nil {
+		return false, 0, err
+	}
+	if len(indexVec) != len(proofVec) || len(indexVec) != len(a_i) {
+		return false, 0, fmt.Errorf("%w: %d indices, %d values, %d proofs", ErrParamMismatch, len(indexVec), len(a_i), len(proofVec))
+	}
+	for t := range proofVec {
+		if err := vcs.checkProof(indexVec[t], proofVec[t]); err != nil {
+			return false, 0, fmt.Errorf("proof %d: %w", t, err)
+		}
+	}
+	status, count := vcs.VerifyMemoized(digest, indexVec, a_i, proofVec)
+	return status, count, nil
+}
+
+// Checks the aggregation keys and the shape of an aggregation instance.
+func (vcs *VCS) checkAggInstance(indexVec []uint64, n int) error {
+	if !vcs.hasAggKeys() {
+		return fmt.Errorf("%w: aggregation keys are not loaded", ErrParamMismatch)
+	}
+	if len(indexVec) != n || n == 0 {
+		return fmt.Errorf("%w: got %d indices and %d entries", ErrParamMismatch, len(indexVec), n)
+	}
+	if uint64(n+1)*uint64(vcs.L) > MAX_AGG_SIZE {
+		return fmt.Errorf("%w: %d entries and a dummy row of %d proof elements, at most %d", ErrBatchTooLarge, n, vcs.L, MAX_AGG_SIZE)
+	}
+	for t := range indexVec {
+		if indexVec[t] >= vcs.N {
+			return fmt.Errorf("%w: entry %d has index %d, vector size %d", ErrIndexOutOfRange, t, indexVec[t], vcs.N)
+		}
+	}
+	return nil
+}
+
+// Same as AggProve, but rejects malformed input with an error instead of panicking.
+func (vcs *VCS) TryAggProve(indexVec []uint64, proofVec [][]mcl.G1) (proof batch.Proof, err error) {
+	if err = vcs.checkAggInstance(indexVec, len(proofVec)); err != nil {
+		return
+	}
+	for t := range proofVec {
+		if err = vcs.checkProof(indexVec[t], proofVec[t]); err != nil {
+			err = fmt.Errorf("proof %d: %w", t, err)
+			return
+		}
+	}
+	if err = vcs.growAggKeys(vcs.aggSize(len(indexVec))); err != nil {
+		return
+	}
+	defer recoverAs(&err, ErrParamMismatch)
+	proof = vcs.AggProve(indexVec, proofVec)
+	return
+}
+
+// Same as AggVerify, but rejects malformed input with an error instead of panicking.
+func (vcs *VCS) TryAggVerify(proof batch.Proof, digest mcl.G1, indexVec []uint64, a_i []mcl.Fr) (status bool, err error) {
+	if err = vcs.checkAggProof(proof, indexVec, len(a_i)); err != nil {
+		return
+	}
+	defer recoverAs(&err, ErrBadProofLength)
+	status = vcs.AggVerify(proof, digest, indexVec, a_i)
+	return
+}
+
+// Same as AggVerifyMultiDigest, but rejects malformed input with an error instead of panicking.
+func (vcs *VCS) TryAggVerifyMultiDigest(proof batch.Proof, digestVec []mcl.G1, indexVec []uint64, a_i []mcl.Fr) (status bool, err error) {
+	if len(digestVec) != len(indexVec) {
+		err = fmt.Errorf("%w: got %d digests and %d indices", ErrParamMismatch, len(digestVec), len(indexVec))
+		return
+	}
+	if err = vcs.checkAggProof(proof, indexVec, len(a_i)); err != nil {
+		return
+	}
+	defer recoverAs(&err, ErrBadProofLength)
+	status = vcs.AggVerifyMultiDigest(proof, digestVec, indexVec, a_i)
+	return
+}
+
+// Same as AggVerifyBatch, but rejects malformed input with an error instead of panicking.
+func (vcs *VCS) TryAggVerifyBatch(instances []AggInstance) (status bool, failed int, err error) {
+	failed = -1
+	if len(instances) == 0 {
+		err = fmt.Errorf("%w: no aggregated proofs", ErrParamMismatch)
+		return
+	}
+	for k := range instances {
+		if err = vcs.checkAggProof(instances[k].Proof, instances[k].Indices, len(instances[k].Values)); err != nil {
+			err = fmt.Errorf("instance %d: %w", k, err)
+			return
+		}
+	}
+	defer recoverAs(&err, ErrBadProofLength)
+	status, failed = vcs.AggVerifyBatch(instances)
+	return
+}
+
+// Checks the shape of an aggregated proof of n entries, and makes the aggregation keys cover it.
+func (vcs *VCS) checkAggProof(proof batch.Proof, indexVec []uint64, n int) error {
+	if err := vcs.checkAggInstance(indexVec, n); err != nil {
+		return err
+	}
+	mn := vcs.aggSize(n)
+	rounds := bits.Len64(mn - 1)
+	if len(proof.GipaKzgProof.L) != rounds || len(proof.GipaKzgProof.R) != rounds {
+		return fmt.Errorf("%w: aggregated proof has %d/%d rounds, want %d", ErrBadProofLength, len(proof.GipaKzgProof.L), len(proof.GipaKzgProof.R), rounds)
+	}
+	return vcs.growAggKeys(mn)
+}
@@ -0,0 +1,99 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+// Generates trapdoors, VRK and UPK for a small ell in a temporary folder.
+// The keys are derived from the name of the test. Aggregation keys are not generated.
+func newTestVCS(t *testing.T, L uint8, txnLimit uint64) *VCS {
+	mcl.InitFromString("bls12-381")
+	NCORES = 4
+	vcs := VCS{}
+	vcs.Init(L, t.TempDir(), txnLimit)
+	vcs.TrapdoorsGenSeeded([]byte(t.Name()))
+	vcs.PrkUpkGen()
+	return &vcs
+}
+
+func TestVCSErrors(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestVCS(t, L, 2)
+	aFr := GenerateVector(vcs.N)
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+	index := uint64(5)
+	proof := vcs.GetProofPath(vcs.ProofTree, index, L)
+
+	t.Run(fmt.Sprintf("%d/TryVerify;", L), func(t *testing.T) {
+		status, err := vcs.TryVerify(digest, index, aFr[index], proof)
+		if err != nil || !status {
+			t.Errorf("TryVerify rejected a valid proof: %v", err)
+		}
+		_, err = vcs.TryVerify(digest, index, aFr[index], proof[1:])
+		if !errors.Is(err, ErrBadProofLength) {
+			t.Errorf("Expected ErrBadProofLength, got %v", err)
+		}
+		_, err = vcs.TryVerify(digest, vcs.N, aFr[index], proof)
+		if !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Expected ErrIndexOutOfRange, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/TryVerifyMemoized;", L), func(t *testing.T) {
+		_, _, err := vcs.TryVerifyMemoized(digest, []uint64{index, index}, aFr[:2], [][]mcl.G1{proof})
+		if !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Expected ErrParamMismatch, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/TryAggProve;", L), func(t *testing.T) {
+		_, err := vcs.TryAggProve([]uint64{index}, [][]mcl.G1{proof})
+		if !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Expected ErrParamMismatch, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/TryInit;", L), func(t *testing.T) {
+		other := VCS{}
+		if err := other.TryInit(0, vcs.folderPath, 1); !errors.Is(err, ErrInvalidParam) {
+			t.Errorf("Expected ErrInvalidParam, got %v", err)
+		}
+		if err := other.TryInit(L, vcs.folderPath, MAX_AGG_SIZE); !errors.Is(err, ErrBatchTooLarge) {
+			t.Errorf("Expected ErrBatchTooLarge, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/TryKeyGenLoad;", L), func(t *testing.T) {
+		other := VCS{}
+		err := other.TryKeyGenLoad(4, L, t.TempDir(), 2)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected os.ErrNotExist, got %v", err)
+		}
+		err = other.TryKeyGenLoad(4, L+1, vcs.folderPath, 2)
+		if !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Expected ErrParamMismatch, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/CorruptKeyFile;", L), func(t *testing.T) {
+		other := VCS{}
+		other.Init(L, vcs.folderPath, 2)
+		fileName := vcs.folderPath + fmt.Sprintf(UPKNAME, 3)
+		check(os.Truncate(fileName, int64(GetG1ByteSize())/2))
+		if err := other.TryUpkLoadDriver(); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Expected ErrCorruptKeyFile, got %v", err)
+		}
+
+		check(os.Truncate(vcs.folderPath+TRAPDOORNAME, 20))
+		if err := other.TryLoadTrapdoor(L); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Expected ErrCorruptKeyFile, got %v", err)
+		}
+	})
+}
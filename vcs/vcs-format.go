@@ -0,0 +1,366 @@
+package vcs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/alinush/go-mcl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Every key file (trapdoors.data, vrk.data, upk-XX.data, prk-XX.data, prooftree-XX.data) starts with this header,
+// followed by the serialized elements. All integers are little endian.
+//
+//	offset  size  field
+//	0       4     magic "HPKF"
+//	4       2     format version
+//	6       2     curve id (mcl curve constant)
+//	8       1     kind of file (FILE_TRAPDOOR, FILE_VRK, ...)
+//	9       1     ell of the setup that wrote the file
+//	10      1     NFILES, number of chunks the keys of this kind are split into
+//	11      1     index of this chunk
+//	12      8     start of the chunk range (inclusive)
+//	20      8     stop of the chunk range (exclusive)
+//	28      32    setup id, blake2b-256 of G, H and the VRK of the setup
+//	60      32    blake2b-256 of the payload
+const FORMAT_MAGIC = "HPKF"
+const FORMAT_VERSION = 3 // Version 3 derives G and H by hash to curve, see StandardGenerators
+const HEADER_SIZE = 92
+
+// Kind of a key file.
+const (
+	FILE_TRAPDOOR          = 1
+	FILE_VRK               = 2
+	FILE_UPK               = 3
+	FILE_PRK               = 4
+	FILE_CEREMONY          = 5
+	FILE_PROOFTREE         = 6
+	FILE_CHECKPOINT        = 7
+	FILE_PRUNED_CHECKPOINT = 8
+	FILE_DELTA_LOG         = 9
+	FILE_STATE             = 10
+	FILE_VECTOR            = 11
+)
+
+var fileKindNames = map[uint8]string{
+	FILE_TRAPDOOR:          "trapdoor",
+	FILE_VRK:               "VRK",
+	FILE_UPK:               "UPK",
+	FILE_PRK:               "PRK",
+	FILE_CEREMONY:          "ceremony",
+	FILE_PROOFTREE:         "proof tree",
+	FILE_CHECKPOINT:        "proof tree checkpoint",
+	FILE_PRUNED_CHECKPOINT: "pruned proof tree checkpoint",
+	FILE_DELTA_LOG:         "delta log",
+	FILE_STATE:             "state checkpoint",
+	FILE_VECTOR:            "vector",
+}
+
+type FileHeader struct {
+	Version  uint16
+	Curve    uint16
+	Kind     uint8
+	L        uint8
+	NFiles   uint8
+	Index    uint8
+	Start    uint64
+	Stop     uint64
+	SetupID  [32]byte
+	Checksum [32]byte
+}
+
+func (h *FileHeader) Serialize() []byte {
+	buf := make([]byte, HEADER_SIZE)
+	copy(buf[0:4], FORMAT_MAGIC)
+	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
+	binary.LittleEndian.PutUint16(buf[6:8], h.Curve)
+	buf[8] = h.Kind
+	buf[9] = h.L
+	buf[10] = h.NFiles
+	buf[11] = h.Index
+	binary.LittleEndian.PutUint64(buf[12:20], h.Start)
+	binary.LittleEndian.PutUint64(buf[20:28], h.Stop)
+	copy(buf[28:60], h.SetupID[:])
+	copy(buf[60:92], h.Checksum[:])
+	return buf
+}
+
+// Parses and validates a header. Only the fields that do not depend on the caller are checked here.
+func (h *FileHeader) Deserialize(buf []byte) error {
+	if len(buf) != HEADER_SIZE {
+		return fmt.Errorf("%w: header is %d bytes, want %d", ErrCorruptKeyFile, len(buf), HEADER_SIZE)
+	}
+	if string(buf[0:4]) != FORMAT_MAGIC {
+		return fmt.Errorf("%w: bad magic %q, not a key file or written by an old version", ErrCorruptKeyFile, buf[0:4])
+	}
+	h.Version = binary.LittleEndian.Uint16(buf[4:6])
+	h.Curve = binary.LittleEndian.Uint16(buf[6:8])
+	h.Kind = buf[8]
+	h.L = buf[9]
+	h.NFiles = buf[10]
+	h.Index = buf[11]
+	h.Start = binary.LittleEndian.Uint64(buf[12:20])
+	h.Stop = binary.LittleEndian.Uint64(buf[20:28])
+	copy(h.SetupID[:], buf[28:60])
+	copy(h.Checksum[:], buf[60:92])
+
+	if h.Version != FORMAT_VERSION {
+		return fmt.Errorf("%w: format version %d, want %d", ErrParamMismatch, h.Version, FORMAT_VERSION)
+	}
+	if h.Curve != uint16(mcl.BLS12_381) {
+		return fmt.Errorf("%w: curve id %d, want %d (bls12-381)", ErrParamMismatch, h.Curve, mcl.BLS12_381)
+	}
+	if h.Start > h.Stop {
+		return fmt.Errorf("%w: chunk range [%d, %d)", ErrCorruptKeyFile, h.Start, h.Stop)
+	}
+	return nil
+}
+
+// Header for a key file of this setup.
+func (vcs *VCS) newFileHeader(kind uint8, nfiles uint8, index uint8, start uint64, stop uint64) FileHeader {
+	return FileHeader{
+		Version: FORMAT_VERSION,
+		Curve:   uint16(mcl.BLS12_381),
+		Kind:    kind,
+		L:       vcs.L,
+		NFiles:  nfiles,
+		Index:   index,
+		Start:   start,
+		Stop:    stop,
+		SetupID: vcs.setupID,
+	}
+}
+
+// Identifies a setup. Files of one setup carry the same id, so files from different setups cannot be mixed.
+func (vcs *VCS) computeSetupID() [32]byte {
+	return setupIDOf(vcs.G, vcs.H, vcs.VRK)
+}
+
+func setupIDOf(G mcl.G1, H mcl.G2, vrk []mcl.G2) [32]byte {
+	h, _ := blake2b.New256(nil)
+	h.Write(G.Serialize())
+	h.Write(H.Serialize())
+	for i := range vrk {
+		h.Write(vrk[i].Serialize())
+	}
+	var id [32]byte
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// Writes a key file. The checksum is filled in the header on Close.
+type keyFileWriter struct {
+	f      *os.File
+	w      *bufio.Writer
+	hasher hash.Hash
+	header FileHeader
+}
+
+func createKeyFile(fileName string, header FileHeader) (*keyFileWriter, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Write(header.Serialize()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	hasher, _ := blake2b.New256(nil)
+	kw := keyFileWriter{f: f, hasher: hasher, header: header}
+	kw.w = bufio.NewWriter(io.MultiWriter(f, hasher))
+	return &kw, nil
+}
+
+func (kw *keyFileWriter) Write(p []byte) (int, error) {
+	return kw.w.Write(p)
+}
+
+func (kw *keyFileWriter) Close() error {
+	err := kw.w.Flush()
+	if err == nil {
+		copy(kw.header.Checksum[:], kw.hasher.Sum(nil))
+		_, err = kw.f.WriteAt(kw.header.Serialize(), 0)
+	}
+	if err == nil {
+		err = kw.f.Sync()
+	}
+	if cerr := kw.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Reads a key file and checks its checksum once the payload has been consumed.
+type keyFileReader struct {
+	f      *os.File
+	r      *bufio.Reader
+	hasher hash.Hash
+	size   int64 // Size of the payload
+	Header FileHeader
+}
+
+// Opens a key file and validates the header against the expected kind.
+func openKeyFile(fileName string, kind uint8) (*keyFileReader, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	kr := keyFileReader{f: f}
+	buf := make([]byte, HEADER_SIZE)
+	if _, err = io.ReadFull(f, buf); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s: header: %v", ErrCorruptKeyFile, fileName, err)
+	}
+	if err = kr.Header.Deserialize(buf); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	if kr.Header.Kind != kind {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s holds %s keys, want %s keys", ErrParamMismatch, fileName, fileKindNames[kr.Header.Kind], fileKindNames[kind])
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	kr.size = fi.Size() - HEADER_SIZE
+	kr.hasher, _ = blake2b.New256(nil)
+	kr.r = bufio.NewReader(io.TeeReader(f, kr.hasher))
+	return &kr, nil
+}
+
+// Checks that the payload has the size implied by the header.
+func (kr *keyFileReader) expectPayload(size int64) error {
+	if kr.size != size {
+		return fmt.Errorf("%w: %s: payload is %d bytes, want %d", ErrCorruptKeyFile, kr.f.Name(), kr.size, size)
+	}
+	return nil
+}
+
+func (kr *keyFileReader) Read(p []byte) (int, error) {
+	return kr.r.Read(p)
+}
+
+// Consumes the rest of the payload, checks the checksum and closes the file.
+func (kr *keyFileReader) Verify() error {
+	defer kr.f.Close()
+	if _, err := io.Copy(io.Discard, kr.r); err != nil {
+		return err
+	}
+	if !bytes.Equal(kr.hasher.Sum(nil), kr.Header.Checksum[:]) {
+		return fmt.Errorf("%w: %s: checksum mismatch", ErrCorruptKeyFile, kr.f.Name())
+	}
+	return nil
+}
+
+func (kr *keyFileReader) Close() error {
+	return kr.f.Close()
+}
+
+// Chunks of a DiskProofTree or a VectorStore, open for reading and writing.
+// The payload of chunk i holds elements [header[i].Start, header[i].Stop) of elemSize bytes each.
+//
+// Writes go through to the files. The checksums of the chunks that were written to are updated on Flush and Close,
+// so chunks that were not closed fail to open with ErrCorruptKeyFile.
+type chunkFiles struct {
+	files    []*os.File
+	header   []FileHeader
+	elemSize int
+
+	mu    sync.Mutex
+	dirty []bool
+}
+
+// Checks the checksum of a chunk if verify is set, and reopens it for reading and writing.
+func (c *chunkFiles) reopen(kr *keyFileReader, verify bool) error {
+	var err error
+	if verify {
+		err = kr.Verify()
+	} else {
+		err = kr.Close()
+	}
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(kr.f.Name(), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	c.files = append(c.files, f)
+	c.header = append(c.header, kr.Header)
+	c.dirty = append(c.dirty, false)
+	return nil
+}
+
+// Chunk of element j and the offset of the element in it.
+func (c *chunkFiles) chunkOf(j uint64) (int, int64) {
+	i := sort.Search(len(c.header), func(i int) bool { return j < c.header[i].Stop })
+	return i, HEADER_SIZE + int64(j-c.header[i].Start)*int64(c.elemSize)
+}
+
+// Writes data at offset in chunk i and marks the chunk as dirty.
+func (c *chunkFiles) writeAt(i int, data []byte, offset int64) error {
+	if _, err := c.files[i].WriteAt(data, offset); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.dirty[i] = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Updates the checksums of the chunks that were written to and syncs them to disk.
+func (c *chunkFiles) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, f := range c.files {
+		if !c.dirty[i] {
+			continue
+		}
+		h := &c.header[i]
+		hasher, _ := blake2b.New256(nil)
+		size := int64(h.Stop-h.Start) * int64(c.elemSize)
+		if _, err := io.Copy(hasher, io.NewSectionReader(f, HEADER_SIZE, size)); err != nil {
+			return err
+		}
+		copy(h.Checksum[:], hasher.Sum(nil))
+		if _, err := f.WriteAt(h.Serialize(), 0); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		c.dirty[i] = false
+	}
+	return nil
+}
+
+func (c *chunkFiles) Close() error {
+	err := c.Flush()
+	for _, f := range c.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	c.files = nil
+	return err
+}
+
+// Checks that a file belongs to the setup that was loaded first.
+func (vcs *VCS) checkSameSetup(fileName string, h FileHeader) error {
+	if h.L != vcs.setupL {
+		return fmt.Errorf("%w: %s was written for ell %d, %s for ell %d", ErrParamMismatch, fileName, h.L, vcs.setupFile, vcs.setupL)
+	}
+	if h.SetupID != vcs.setupID {
+		return fmt.Errorf("%w: %s belongs to setup %x, %s to setup %x", ErrParamMismatch, fileName, h.SetupID[:8], vcs.setupFile, vcs.setupID[:8])
+	}
+	return nil
+}
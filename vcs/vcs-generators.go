@@ -0,0 +1,72 @@
+package vcs
+
+/*
+#include <stdlib.h>
+#include <mcl/bn.h>
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/alinush/go-mcl"
+)
+
+// Generators G and H are not sampled by whoever runs the setup. They are the hash to curve
+// (draft-irtf-cfrg-hash-to-curve, suites BLS12381G1_XMD:SHA-256_SSWU_RO_ and BLS12381G2_XMD:SHA-256_SSWU_RO_)
+// of GENERATOR_DOMAIN under the domain separation tags below.
+// Anyone can recompute them, so independent deployments can check that they share the same base points,
+// and nobody knows the discrete log of one with respect to the other.
+// Their serialized values are pinned in TestStandardGenerators.
+// Changing any of these strings changes every key, so bump FORMAT_VERSION with them.
+const GENERATOR_DOMAIN = "hyperproofs-go generators v1"
+const GENERATOR_DST_G1 = "HYPERPROOFS-GO-V01-CS01-with-BLS12381G1_XMD:SHA-256_SSWU_RO_"
+const GENERATOR_DST_G2 = "HYPERPROOFS-GO-V01-CS01-with-BLS12381G2_XMD:SHA-256_SSWU_RO_"
+
+// Tags mcl sets on init. Restored once the generators are computed, so other users of HashAndMapTo are not affected.
+const mclDefaultDstG1 = "BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_POP_"
+const mclDefaultDstG2 = "BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_"
+
+var standardG mcl.G1
+var standardH mcl.G2
+
+// The map-to mode and the tags are process wide in mcl. The generators are computed while the package is initialized:
+// go-mcl has set up BLS12-381 with its default mode and tags in its own init, and no caller has run yet,
+// so setting the defaults back leaves every later user of HashAndMapTo as mcl set it up.
+func init() {
+	check(mcl.SetMapToMode(mcl.IRTF))
+	check(setDst(GENERATOR_DST_G1, GENERATOR_DST_G2))
+	check(standardG.HashAndMapTo([]byte(GENERATOR_DOMAIN + " G")))
+	check(standardH.HashAndMapTo([]byte(GENERATOR_DOMAIN + " H")))
+	check(setDst(mclDefaultDstG1, mclDefaultDstG2))
+	check(mcl.SetMapToMode(0))
+}
+
+// Returns the generators G and H every setup uses.
+func StandardGenerators() (mcl.G1, mcl.G2) {
+	return standardG, standardH
+}
+
+// The Go binding of mcl does not expose the domain separation tags.
+func setDst(dstG1 string, dstG2 string) error {
+	cG1 := C.CString(dstG1)
+	defer C.free(unsafe.Pointer(cG1))
+	cG2 := C.CString(dstG2)
+	defer C.free(unsafe.Pointer(cG2))
+	if C.mclBnG1_setDst(cG1, C.mclSize(len(dstG1))) != 0 {
+		return fmt.Errorf("mclBnG1_setDst %q", dstG1)
+	}
+	if C.mclBnG2_setDst(cG2, C.mclSize(len(dstG2))) != 0 {
+		return fmt.Errorf("mclBnG2_setDst %q", dstG2)
+	}
+	return nil
+}
+
+// Checks that G and H of a parameter set are the standard generators.
+func checkGenerators(G *mcl.G1, H *mcl.G2) error {
+	stdG, stdH := StandardGenerators()
+	if !G.IsEqual(&stdG) || !H.IsEqual(&stdH) {
+		return fmt.Errorf("%w: generators are not the hash to curve of %q", ErrParamMismatch, GENERATOR_DOMAIN)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package vcs
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestStandardGenerators(t *testing.T) {
+
+	mcl.InitFromString("bls12-381")
+	L := uint8(3)
+
+	t.Run(fmt.Sprintf("%d/KnownAnswer;", L), func(t *testing.T) {
+		// Serialize of the hash to curve of GENERATOR_DOMAIN under GENERATOR_DST_G1 and GENERATOR_DST_G2.
+		// Changing the domain, the tags or the suite changes these.
+		wantG := "3f96abd095a6a400c3dfc2b24750bfdbb0b24b55a7625340f145ab834c128a6855aa798d63b76b4da874c949c2fccd80"
+		wantH := "2d46c9ede29dc8521a1a2a8e299f7da2100fbfa4541388b98d0ae9d98337feccd64acf164f46e0803e528c9be865450f" +
+			"dc775dad750f292fb1d50866a42beaf9924119c091b619677bf8b7bafc0a55c0af188a69fd90797472a19795ee910992"
+
+		G, H := StandardGenerators()
+		if got := hex.EncodeToString(G.Serialize()); got != wantG {
+			t.Errorf("G is %s, want %s", got, wantG)
+		}
+		if got := hex.EncodeToString(H.Serialize()); got != wantH {
+			t.Errorf("H is %s, want %s", got, wantH)
+		}
+		if !G.IsValidOrder() || !H.IsValidOrder() {
+			t.Errorf("Generators are not in the group")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Setup;", L), func(t *testing.T) {
+		vcs := newTestVCS(t, L, 2)
+		G, H := StandardGenerators()
+		if !vcs.G.IsEqual(&G) || !vcs.H.IsEqual(&H) {
+			t.Errorf("Setup does not use the standard generators")
+		}
+		other := VCS{}
+		other.Init(L, vcs.folderPath, 2)
+		if err := other.TryLoadVrk(L); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Mismatch;", L), func(t *testing.T) {
+		// A consistent parameter set over other base points.
+		vcs := VCS{}
+		vcs.Init(L, t.TempDir(), 2)
+		vcs.TrapdoorsGenSeeded([]byte(t.Name()))
+		vcs.G.Random()
+		vcs.H.Random()
+		vcs.deriveVrk()
+		check(vcs.saveVrk())
+
+		other := VCS{}
+		other.Init(L, vcs.folderPath, 2)
+		if err := other.TryLoadVrk(L); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Expected ErrParamMismatch, got %v", err)
+		}
+	})
+}
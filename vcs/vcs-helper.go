@@ -8,6 +8,7 @@ var PRKNAME string
 var VRKNAME string
 var UPKNAME string
 var TRAPDOORNAME string
+var PROOFTREENAME string
 var NFILES uint8
 var NCORES uint8
 
@@ -0,0 +1,333 @@
+package vcs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/alinush/go-mcl"
+)
+
+const HISTORYSNAPSHOTNAME = "/snapshot-%010d.data"
+const HISTORYLOGNAME = "/history.log"
+
+// Keeps the past states of the vector, so the value of an account at any block height can be proven
+// against the digest of that block.
+//
+// Every block is appended to a block-indexed delta log (block h is batch h-1), and every interval blocks
+// the vector, the digest and the proof tree are written to a snapshot. A past state is rebuilt from the
+// closest snapshot at or below its height and the blocks after it, so a query replays at most interval-1 blocks.
+// The log keeps the offset of every block, so a replay reads from the block after the snapshot up to the height, and no further.
+// The last state that was rebuilt is kept, so queries at increasing heights replay each block once.
+type History struct {
+	vcs       *VCS
+	folder    string
+	interval  uint64
+	mu        sync.Mutex
+	log       *DeltaLog
+	snapshots []uint64 // Heights of the snapshots, increasing
+	head      historyState
+	cache     historyState // Last state rebuilt by GetStateProof, if vector is not nil
+}
+
+type historyState struct {
+	height    uint64
+	digest    mcl.G1
+	vector    []mcl.Fr
+	proofTree [][]mcl.G1
+}
+
+// Value of an account at a block height, with its proof against the digest of that block.
+type StateProof struct {
+	Height uint64
+	Index  uint64
+	Value  mcl.Fr
+	Digest mcl.G1
+	Proof  []mcl.G1
+}
+
+func (s *historyState) apply(vcs *VCS, updateindexVec []uint64, deltaVec []mcl.Fr) {
+	for t := range updateindexVec {
+		mcl.FrAdd(&s.vector[updateindexVec[t]], &s.vector[updateindexVec[t]], &deltaVec[t])
+	}
+	s.digest = vcs.UpdateComVec(s.digest, updateindexVec, deltaVec)
+	vcs.UpdateProofTreeBulkInPlace(s.proofTree, updateindexVec, deltaVec)
+	s.height++
+}
+
+// Starts the history of the vector a in folder, at height 0, with a snapshot every interval blocks.
+// The proof tree is computed with OpenAll, so vcs.ProofTree is the proof tree of the head afterwards.
+func (vcs *VCS) NewHistory(folder string, a []mcl.Fr, interval uint64) (*History, error) {
+	if uint64(len(a)) != vcs.N {
+		return nil, fmt.Errorf("%w: vector of size %d, want %d", ErrInvalidParam, len(a), vcs.N)
+	}
+	if interval == 0 {
+		return nil, fmt.Errorf("%w: snapshot interval of 0 blocks", ErrInvalidParam)
+	}
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	h := History{vcs: vcs, folder: folder, interval: interval}
+	h.head.vector = make([]mcl.Fr, len(a))
+	copy(h.head.vector, a)
+	h.head.digest = vcs.Commit(h.head.vector, uint64(vcs.L))
+	vcs.OpenAll(h.head.vector)
+	h.head.proofTree = vcs.ProofTree
+
+	if err := h.snapshot(); err != nil {
+		return nil, err
+	}
+	log, err := vcs.createDeltaLog(folder+HISTORYLOGNAME, 0)
+	if err != nil {
+		return nil, err
+	}
+	h.log = log
+	return &h, nil
+}
+
+// Opens the history in folder. The head is rebuilt from the last snapshot and the blocks after it.
+func (vcs *VCS) OpenHistory(folder string, interval uint64) (*History, error) {
+	if interval == 0 {
+		return nil, fmt.Errorf("%w: snapshot interval of 0 blocks", ErrInvalidParam)
+	}
+	h := History{vcs: vcs, folder: folder, interval: interval}
+
+	names, err := filepath.Glob(folder + "/snapshot-*.data")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		var height uint64
+		if _, err := fmt.Sscanf(filepath.Base(name), HISTORYSNAPSHOTNAME[1:], &height); err != nil {
+			continue
+		}
+		h.snapshots = append(h.snapshots, height)
+	}
+	sort.Slice(h.snapshots, func(i, j int) bool { return h.snapshots[i] < h.snapshots[j] })
+	if len(h.snapshots) == 0 || h.snapshots[0] != 0 {
+		return nil, fmt.Errorf("%w: %s has no snapshot at height 0", ErrCorruptKeyFile, folder)
+	}
+
+	last := h.snapshots[len(h.snapshots)-1]
+	if err = h.load(&h.head, last); err != nil {
+		return nil, err
+	}
+	log, err := vcs.OpenDeltaLog(folder + HISTORYLOGNAME)
+	if err != nil {
+		return nil, err
+	}
+	if log.first != 0 || log.Next() < last {
+		log.Close()
+		return nil, fmt.Errorf("%w: %s holds blocks [%d, %d), the last snapshot is at height %d", ErrCorruptKeyFile, folder+HISTORYLOGNAME, log.first+1, log.Next()+1, last)
+	}
+	err = log.Replay(last, func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		h.head.apply(vcs, updateindexVec, deltaVec)
+		return nil
+	})
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	h.log = log
+	return &h, nil
+}
+
+func (h *History) snapshotName(height uint64) string {
+	return h.folder + fmt.Sprintf(HISTORYSNAPSHOTNAME, height)
+}
+
+// Writes the head to a snapshot.
+func (h *History) snapshot() error {
+	s := &h.head
+	if err := h.vcs.saveStateFile(h.snapshotName(s.height), s.height, s.digest, s.vector, s.proofTree); err != nil {
+		return err
+	}
+	h.snapshots = append(h.snapshots, s.height)
+	return nil
+}
+
+func (h *History) load(s *historyState, height uint64) error {
+	seq, digest, vector, proofTree, err := h.vcs.loadStateFile(h.snapshotName(height))
+	if err != nil {
+		return err
+	}
+	if seq != height {
+		return fmt.Errorf("%w: %s is at height %d", ErrCorruptKeyFile, h.snapshotName(height), seq)
+	}
+	*s = historyState{height, digest, vector, proofTree}
+	return nil
+}
+
+// Height of the last block.
+func (h *History) Height() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.head.height
+}
+
+// Appends a block and applies it to the head. Returns the height of the block.
+// If the block is logged but its snapshot cannot be written, the block is applied and the error is returned.
+func (h *History) ApplyBlock(updateindexVec []uint64, deltaVec []mcl.Fr) (uint64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := h.log.Append(updateindexVec, deltaVec); err != nil {
+		return h.head.height, err
+	}
+	h.head.apply(h.vcs, updateindexVec, deltaVec)
+	if h.head.height%h.interval == 0 {
+		return h.head.height, h.snapshot()
+	}
+	return h.head.height, nil
+}
+
+// Height of the last snapshot at or below height.
+func (h *History) closestSnapshot(height uint64) uint64 {
+	i := sort.Search(len(h.snapshots), func(i int) bool { return h.snapshots[i] > height })
+	return h.snapshots[i-1]
+}
+
+func (h *History) checkHeight(height uint64) error {
+	if height > h.head.height {
+		return fmt.Errorf("%w: block height %d, the last block is at %d", ErrIndexOutOfRange, height, h.head.height)
+	}
+	return nil
+}
+
+// State at height, from the head, the cache or the closest snapshot.
+func (h *History) rebuild(height uint64) (*historyState, error) {
+	if height == h.head.height {
+		return &h.head, nil
+	}
+	base := h.closestSnapshot(height)
+	if h.cache.vector == nil || h.cache.height < base || h.cache.height > height {
+		h.cache.vector = nil
+		if err := h.load(&h.cache, base); err != nil {
+			return nil, err
+		}
+	}
+	err := h.log.ReplayRange(h.cache.height, height, func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		h.cache.apply(h.vcs, updateindexVec, deltaVec)
+		return nil
+	})
+	if err != nil {
+		h.cache.vector = nil
+		return nil, err
+	}
+	return &h.cache, nil
+}
+
+// Value of the account at index after block height, and its proof against the digest of that block.
+// Height 0 is the state NewHistory started from.
+func (h *History) GetStateProof(index uint64, height uint64) (StateProof, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sp := StateProof{Height: height, Index: index}
+	if index >= h.vcs.N {
+		return sp, fmt.Errorf("%w: index %d, vector size %d", ErrIndexOutOfRange, index, h.vcs.N)
+	}
+	if err := h.checkHeight(height); err != nil {
+		return sp, err
+	}
+	s, err := h.rebuild(height)
+	if err != nil {
+		return sp, err
+	}
+	sp.Value = s.vector[index]
+	sp.Digest = s.digest
+	sp.Proof = h.vcs.GetProofPath(s.proofTree, index, h.vcs.L)
+	return sp, nil
+}
+
+// Value of the account at index after block height, without a proof.
+// Only the entry of the closest snapshot and the blocks after it are read.
+func (h *History) RetrieveStateData(index uint64, height uint64) (mcl.Fr, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var value mcl.Fr
+	if index >= h.vcs.N {
+		return value, fmt.Errorf("%w: index %d, vector size %d", ErrIndexOutOfRange, index, h.vcs.N)
+	}
+	if err := h.checkHeight(height); err != nil {
+		return value, err
+	}
+	base := h.closestSnapshot(height)
+	err := h.readSnapshot(base, int64(8+GetG1ByteSize())+int64(index)*int64(GetFrByteSize()), func(er *elementReader) {
+		er.Fr(&value)
+	})
+	if err != nil {
+		return value, err
+	}
+	err = h.log.ReplayRange(base, height, func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		for t := range updateindexVec {
+			if updateindexVec[t] == index {
+				mcl.FrAdd(&value, &value, &deltaVec[t])
+			}
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Digest of the block at height.
+func (h *History) DigestAt(height uint64) (mcl.G1, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var digest mcl.G1
+	if err := h.checkHeight(height); err != nil {
+		return digest, err
+	}
+	base := h.closestSnapshot(height)
+	err := h.readSnapshot(base, 8, func(er *elementReader) {
+		er.G1(&digest)
+	})
+	if err != nil {
+		return digest, err
+	}
+	err = h.log.ReplayRange(base, height, func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		digest = h.vcs.UpdateComVec(digest, updateindexVec, deltaVec)
+		return nil
+	})
+	return digest, err
+}
+
+// Reads part of a snapshot at offset in its payload. The checksum is not checked, as that reads the whole file.
+func (h *History) readSnapshot(height uint64, offset int64, read func(er *elementReader)) error {
+	fileName := h.snapshotName(height)
+	f, err := openKeyFile(fileName, FILE_STATE)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = h.vcs.checkStateFile(fileName, f.Header); err != nil {
+		return err
+	}
+	er := elementReader{r: io.NewSectionReader(f.f, HEADER_SIZE+offset, f.size-offset)}
+	read(&er)
+	if er.err != nil {
+		return fmt.Errorf("%s: %w", fileName, er.err)
+	}
+	return nil
+}
+
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.log.Close()
+}
+
+// Checks a StateProof against the digest of its block, as the verifier knows it.
+func (vcs *VCS) VerifyStateProof(digest mcl.G1, sp StateProof) bool {
+	if !sp.Digest.IsEqual(&digest) {
+		return false
+	}
+	status, err := vcs.TryVerify(digest, sp.Index, sp.Value, sp.Proof)
+	return err == nil && status
+}
@@ -0,0 +1,136 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestHistory(t *testing.T) {
+
+	L := uint8(5)
+	vcs := newTestVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	folder := t.TempDir()
+	interval := uint64(3)
+	blocks := 10
+
+	// Vector and digest after every block, as the chain records them.
+	vectors := [][]mcl.Fr{append([]mcl.Fr{}, aFr...)}
+	digests := []mcl.G1{vcs.Commit(aFr, uint64(L))}
+
+	h, err := vcs.NewHistory(folder, aFr, interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkHeight := func(t *testing.T, h *History, height uint64) {
+		for _, index := range []uint64{0, 5, 17, 31} {
+			sp, err := h.GetStateProof(index, height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sp.Value.IsEqual(&vectors[height][index]) {
+				t.Errorf("Value of %d at height %d does not match", index, height)
+			}
+			if !vcs.VerifyStateProof(digests[height], sp) {
+				t.Errorf("Proof of %d at height %d failed", index, height)
+			}
+			value, err := h.RetrieveStateData(index, height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !value.IsEqual(&vectors[height][index]) {
+				t.Errorf("Retrieved value of %d at height %d does not match", index, height)
+			}
+		}
+		digest, err := h.DigestAt(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !digest.IsEqual(&digests[height]) {
+			t.Errorf("Digest at height %d does not match", height)
+		}
+	}
+
+	t.Run(fmt.Sprintf("%d/Apply;", L), func(t *testing.T) {
+		for i := 1; i <= blocks; i++ {
+			indexVec := []uint64{uint64(i), uint64(3 * i % 32), 17}
+			deltaVec := GenerateVectorSeeded(uint64(len(indexVec)), []byte(fmt.Sprintf("deltas-%d", i)))
+			height, err := h.ApplyBlock(indexVec, deltaVec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if height != uint64(i) {
+				t.Errorf("Block at height %d, want %d", height, i)
+			}
+			b := append([]mcl.Fr{}, vectors[i-1]...)
+			for t := range indexVec {
+				mcl.FrAdd(&b[indexVec[t]], &b[indexVec[t]], &deltaVec[t])
+			}
+			vectors = append(vectors, b)
+			digests = append(digests, vcs.Commit(b, uint64(L)))
+		}
+		if len(h.snapshots) != blocks/int(interval)+1 {
+			t.Errorf("Wrote %d snapshots, want %d", len(h.snapshots), blocks/int(interval)+1)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Query;", L), func(t *testing.T) {
+		// Increasing heights reuse the cache, the others rebuild from a snapshot.
+		for height := 0; height <= blocks; height++ {
+			checkHeight(t, h, uint64(height))
+		}
+		for _, height := range []uint64{7, 2, 9, 6, 0} {
+			checkHeight(t, h, height)
+		}
+
+		sp, _ := h.GetStateProof(5, 4)
+		if vcs.VerifyStateProof(digests[5], sp) {
+			t.Errorf("Proof at height 4 verified against the digest of height 5")
+		}
+		sp.Digest = digests[5]
+		if vcs.VerifyStateProof(digests[5], sp) {
+			t.Errorf("Proof with a replaced digest verified")
+		}
+		if _, err := h.GetStateProof(5, uint64(blocks+1)); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Future height: got %v", err)
+		}
+		if _, err := h.RetrieveStateData(vcs.N, 2); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Out of range index: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Reopen;", L), func(t *testing.T) {
+		h.Close()
+		h, err = vcs.OpenHistory(folder, interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		if h.Height() != uint64(blocks) {
+			t.Errorf("Reopened at height %d, want %d", h.Height(), blocks)
+		}
+		for _, height := range []uint64{uint64(blocks), 8, 1} {
+			checkHeight(t, h, height)
+		}
+
+		indexVec := []uint64{4}
+		deltaVec := GenerateVectorSeeded(1, []byte("after reopen"))
+		if _, err := h.ApplyBlock(indexVec, deltaVec); err != nil {
+			t.Fatal(err)
+		}
+		b := append([]mcl.Fr{}, vectors[blocks]...)
+		mcl.FrAdd(&b[4], &b[4], &deltaVec[0])
+		vectors = append(vectors, b)
+		digests = append(digests, vcs.Commit(b, uint64(L)))
+		checkHeight(t, h, uint64(blocks+1))
+		checkHeight(t, h, uint64(blocks-1))
+
+		if _, err := vcs.OpenHistory(t.TempDir(), interval); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Empty folder: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,292 @@
+package vcs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+
+	"github.com/alinush/go-mcl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Domain separation tag of the map commitment. Bump the version if the slots or entries change.
+const MAP_DST = "hyperproofs-go map v1"
+
+// Number of slots a key may live in.
+const MAP_PROBES = 4
+
+// Number of evictions Put tries before it reports the map as full.
+const MAP_MAX_KICKS = 500
+
+// Commitment to a map from byte keys to byte values, on top of the vector commitment.
+//
+// A key may only live in one of MAP_PROBES slots, derived from the key by hashing. Put takes a free one
+// and moves other keys to their other slots if all are taken, as in cuckoo hashing.
+// Entry i of the vector is the hash to field of (key, blake2b-256(value)) of the key in slot i, or zero if it is free.
+//
+// A proof opens all the slots of a key. The key is in the map if exactly one of them holds it,
+// and not in the map if none does, so the same proof format covers membership and non-membership.
+type KVMap struct {
+	vcs       *VCS
+	slots     []mapSlot
+	index     map[string]uint64 // Slot of every key
+	vector    []mcl.Fr
+	digest    mcl.G1
+	proofTree [][]mcl.G1
+	rng       *rand.Rand
+}
+
+type mapSlot struct {
+	key   []byte // nil if the slot is free
+	value []byte
+}
+
+// Opening of one slot of a key.
+type MapSlotProof struct {
+	Index     uint64
+	Empty     bool
+	Key       []byte   // Key in the slot
+	ValueHash [32]byte // blake2b-256 of its value
+	Proof     []mcl.G1
+}
+
+// Openings of the MAP_PROBES slots of a key, in probe order.
+type MapProof struct {
+	Slots []MapSlotProof
+}
+
+// Empty map over the N entries of the vector.
+func (vcs *VCS) NewMap() *KVMap {
+	m := KVMap{
+		vcs:    vcs,
+		slots:  make([]mapSlot, vcs.N),
+		index:  make(map[string]uint64),
+		vector: make([]mcl.Fr, vcs.N),
+		rng:    rand.New(rand.NewSource(1)),
+	}
+	for i := range m.vector {
+		m.vector[i].Clear()
+	}
+	m.digest.Clear()
+	m.proofTree = make([][]mcl.G1, vcs.L)
+	for i := range m.proofTree {
+		m.proofTree[i] = make([]mcl.G1, 1<<i)
+		for j := range m.proofTree[i] {
+			m.proofTree[i][j].Clear()
+		}
+	}
+	return &m
+}
+
+// Slot of probe j of key.
+func (vcs *VCS) mapProbe(key []byte, j int) uint64 {
+	digest := blake2b.Sum256(seededInput(MAP_DST, key, "probe", uint64(j)))
+	return binary.LittleEndian.Uint64(digest[:8]) & (vcs.N - 1)
+}
+
+func (vcs *VCS) mapProbes(key []byte) []uint64 {
+	probes := make([]uint64, MAP_PROBES)
+	for j := range probes {
+		probes[j] = vcs.mapProbe(key, j)
+	}
+	return probes
+}
+
+// Entry of the vector for a key and the hash of its value.
+func mapEntry(key []byte, valueHash [32]byte) mcl.Fr {
+	buf := make([]byte, 8, 8+len(key)+len(valueHash))
+	binary.LittleEndian.PutUint64(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = append(buf, valueHash[:]...)
+	return hashToFr(MAP_DST, buf, "entry", 0)
+}
+
+func (m *KVMap) entry(i uint64) mcl.Fr {
+	if m.slots[i].key == nil {
+		var zero mcl.Fr
+		zero.Clear()
+		return zero
+	}
+	return mapEntry(m.slots[i].key, blake2b.Sum256(m.slots[i].value))
+}
+
+func (m *KVMap) Digest() mcl.G1 {
+	return m.digest
+}
+
+// Number of keys in the map.
+func (m *KVMap) Len() int {
+	return len(m.index)
+}
+
+func (m *KVMap) Get(key []byte) ([]byte, bool) {
+	i, ok := m.index[string(key)]
+	if !ok {
+		return nil, false
+	}
+	return m.slots[i].value, true
+}
+
+// Sets the value of key, and inserts the key if it is not in the map.
+// Returns ErrMapFull if no slot is found for a new key, and the map is unchanged.
+func (m *KVMap) Put(key []byte, value []byte) error {
+	if key == nil {
+		key = []byte{}
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	key = append([]byte{}, key...)
+	value = append([]byte{}, value...)
+
+	if i, ok := m.index[string(key)]; ok {
+		m.slots[i].value = value
+		m.commit(map[uint64]bool{i: true})
+		return nil
+	}
+
+	// Previous content of the slots that change, to undo a failed insertion.
+	undo := make(map[uint64]mapSlot)
+	cur := mapSlot{key, value}
+	prev := uint64(1 << 63) // Slot cur was evicted from
+	for kick := 0; kick < MAP_MAX_KICKS; kick++ {
+		probes := m.vcs.mapProbes(cur.key)
+		for _, p := range probes {
+			if m.slots[p].key == nil {
+				m.place(undo, p, cur)
+				m.commit(changedSlots(undo))
+				return nil
+			}
+		}
+		j := m.rng.Intn(MAP_PROBES)
+		if probes[j] == prev {
+			j = (j + 1) % MAP_PROBES
+		}
+		p := probes[j]
+		evicted := m.slots[p]
+		m.place(undo, p, cur)
+		cur, prev = evicted, p
+	}
+
+	for i, s := range undo {
+		if m.slots[i].key != nil {
+			delete(m.index, string(m.slots[i].key))
+		}
+		m.slots[i] = s
+	}
+	delete(m.index, string(key))
+	for i, s := range undo {
+		if s.key != nil {
+			m.index[string(s.key)] = i
+		}
+	}
+	return fmt.Errorf("%w: no slot for a key after %d evictions, %d of %d slots are taken", ErrMapFull, MAP_MAX_KICKS, len(m.index), m.vcs.N)
+}
+
+func (m *KVMap) place(undo map[uint64]mapSlot, i uint64, s mapSlot) {
+	if _, ok := undo[i]; !ok {
+		undo[i] = m.slots[i]
+	}
+	m.slots[i] = s
+	m.index[string(s.key)] = i
+}
+
+func changedSlots(undo map[uint64]mapSlot) map[uint64]bool {
+	changed := make(map[uint64]bool, len(undo))
+	for i := range undo {
+		changed[i] = true
+	}
+	return changed
+}
+
+// Removes key from the map. Deleting a key that is not in the map does nothing.
+func (m *KVMap) Delete(key []byte) {
+	i, ok := m.index[string(key)]
+	if !ok {
+		return
+	}
+	delete(m.index, string(key))
+	m.slots[i] = mapSlot{}
+	m.commit(map[uint64]bool{i: true})
+}
+
+// Updates the vector, the digest and the proof tree for the slots that changed.
+func (m *KVMap) commit(changed map[uint64]bool) {
+	updateindexVec := make([]uint64, 0, len(changed))
+	deltaVec := make([]mcl.Fr, 0, len(changed))
+	for i := range changed {
+		var delta mcl.Fr
+		next := m.entry(i)
+		mcl.FrSub(&delta, &next, &m.vector[i])
+		if delta.IsZero() {
+			continue
+		}
+		m.vector[i] = next
+		updateindexVec = append(updateindexVec, i)
+		deltaVec = append(deltaVec, delta)
+	}
+	if len(updateindexVec) == 0 {
+		return
+	}
+	m.digest = m.vcs.UpdateComVec(m.digest, updateindexVec, deltaVec)
+	m.vcs.UpdateProofTreeBulkInPlace(m.proofTree, updateindexVec, deltaVec)
+}
+
+// Proof that key maps to its value, or that key is not in the map.
+func (m *KVMap) Prove(key []byte) MapProof {
+	probes := m.vcs.mapProbes(key)
+	proof := MapProof{Slots: make([]MapSlotProof, len(probes))}
+	for j, p := range probes {
+		s := MapSlotProof{Index: p, Empty: m.slots[p].key == nil}
+		if !s.Empty {
+			s.Key = m.slots[p].key
+			s.ValueHash = blake2b.Sum256(m.slots[p].value)
+		}
+		s.Proof = m.vcs.GetProofPath(m.proofTree, p, m.vcs.L)
+		proof.Slots[j] = s
+	}
+	return proof
+}
+
+// Checks that key maps to value in the map of digest, or with a nil value, that key is not in the map.
+func (vcs *VCS) VerifyMap(digest mcl.G1, key []byte, value []byte, proof MapProof) bool {
+	status, err := vcs.TryVerifyMap(digest, key, value, proof)
+	return err == nil && status
+}
+
+// Same as VerifyMap, but a malformed proof is reported as an error.
+func (vcs *VCS) TryVerifyMap(digest mcl.G1, key []byte, value []byte, proof MapProof) (bool, error) {
+	if len(proof.Slots) != MAP_PROBES {
+		return false, fmt.Errorf("%w: map proof opens %d slots, want %d", ErrBadProofLength, len(proof.Slots), MAP_PROBES)
+	}
+	found := make(map[uint64]MapSlotProof)
+	for j, s := range proof.Slots {
+		if s.Index != vcs.mapProbe(key, j) {
+			return false, fmt.Errorf("%w: slot %d of the map proof is %d, want %d", ErrInvalidParam, j, s.Index, vcs.mapProbe(key, j))
+		}
+		var a_i mcl.Fr
+		if s.Empty {
+			a_i.Clear()
+		} else {
+			a_i = mapEntry(s.Key, s.ValueHash)
+		}
+		status, err := vcs.TryVerify(digest, s.Index, a_i, s.Proof)
+		if err != nil || !status {
+			return false, err
+		}
+		if !s.Empty && string(s.Key) == string(key) {
+			found[s.Index] = s
+		}
+	}
+
+	if value == nil {
+		return len(found) == 0, nil
+	}
+	if len(found) != 1 {
+		return false, nil
+	}
+	for _, s := range found {
+		return s.ValueHash == blake2b.Sum256(value), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,135 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestMap(t *testing.T) {
+
+	L := uint8(6)
+	vcs := newTestVCS(t, L, 2)
+	m := vcs.NewMap()
+
+	address := func(i int) []byte {
+		h := blake2b.Sum256([]byte(fmt.Sprintf("address %d", i)))
+		return h[:20]
+	}
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf("balance %d", i*1000))
+	}
+	count := 48
+
+	t.Run(fmt.Sprintf("%d/Put;", L), func(t *testing.T) {
+		for i := 0; i < count; i++ {
+			if err := m.Put(address(i), value(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if m.Len() != count {
+			t.Errorf("Map holds %d keys, want %d", m.Len(), count)
+		}
+		for i := 0; i < count; i++ {
+			v, ok := m.Get(address(i))
+			if !ok || string(v) != string(value(i)) {
+				t.Errorf("Key %d maps to %q, %v", i, v, ok)
+			}
+		}
+		want := vcs.Commit(m.vector, uint64(L))
+		digest := m.Digest()
+		if !digest.IsEqual(&want) {
+			t.Errorf("Digest does not match the vector")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Prove;", L), func(t *testing.T) {
+		digest := m.Digest()
+		for _, i := range []int{0, 7, count - 1} {
+			proof := m.Prove(address(i))
+			if !vcs.VerifyMap(digest, address(i), value(i), proof) {
+				t.Errorf("Membership of key %d failed", i)
+			}
+			if vcs.VerifyMap(digest, address(i), value(i+1), proof) {
+				t.Errorf("Key %d verified with another value", i)
+			}
+			if vcs.VerifyMap(digest, address(i), nil, proof) {
+				t.Errorf("Non-membership of key %d verified", i)
+			}
+		}
+		absent := address(count + 1)
+		proof := m.Prove(absent)
+		if !vcs.VerifyMap(digest, absent, nil, proof) {
+			t.Errorf("Non-membership failed")
+		}
+		if vcs.VerifyMap(digest, absent, value(0), proof) {
+			t.Errorf("Membership of an absent key verified")
+		}
+
+		// Hiding the key in its slot does not turn a membership proof into a non-membership proof.
+		proof = m.Prove(address(3))
+		for j := range proof.Slots {
+			if string(proof.Slots[j].Key) == string(address(3)) {
+				proof.Slots[j].Key = address(4)
+			}
+		}
+		if vcs.VerifyMap(digest, address(3), nil, proof) {
+			t.Errorf("Tampered proof verified")
+		}
+		proof.Slots = proof.Slots[1:]
+		if _, err := vcs.TryVerifyMap(digest, address(3), nil, proof); !errors.Is(err, ErrBadProofLength) {
+			t.Errorf("Short proof: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Update;", L), func(t *testing.T) {
+		if err := m.Put(address(5), []byte("updated")); err != nil {
+			t.Fatal(err)
+		}
+		m.Delete(address(6))
+		m.Delete(address(count + 1))
+		digest := m.Digest()
+		if !vcs.VerifyMap(digest, address(5), []byte("updated"), m.Prove(address(5))) {
+			t.Errorf("Updated value does not verify")
+		}
+		if !vcs.VerifyMap(digest, address(6), nil, m.Prove(address(6))) {
+			t.Errorf("Deleted key is still in the map")
+		}
+		if m.Len() != count-1 {
+			t.Errorf("Map holds %d keys, want %d", m.Len(), count-1)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Full;", L), func(t *testing.T) {
+		var err error
+		i := count
+		for ; err == nil && i < 2*int(vcs.N); i++ {
+			err = m.Put(address(i), value(i))
+		}
+		if !errors.Is(err, ErrMapFull) {
+			t.Fatalf("Filling the map: got %v", err)
+		}
+		// The failed insertion left the map as it was.
+		if _, ok := m.Get(address(i - 1)); ok {
+			t.Errorf("Key of the failed insertion is in the map")
+		}
+		want := vcs.Commit(m.vector, uint64(L))
+		digest := m.Digest()
+		if !digest.IsEqual(&want) {
+			t.Errorf("Digest does not match the vector")
+		}
+		for k := 0; k < i-1; k++ {
+			if k == 6 {
+				continue
+			}
+			if _, ok := m.Get(address(k)); !ok {
+				t.Errorf("Key %d was lost", k)
+			}
+		}
+		if !vcs.VerifyMap(digest, address(0), value(0), m.Prove(address(0))) {
+			t.Errorf("Membership failed after a failed insertion")
+		}
+	})
+}
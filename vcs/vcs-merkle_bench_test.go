@@ -3,6 +3,7 @@ package vcs
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/alinush/go-mcl"
 	"github.com/hyperproofs/gipa-go/batch"
@@ -67,3 +68,49 @@ func BenchmarkVCSAgg(b *testing.B) {
 		}
 	}
 }
+
+// Benchmark AggProveParallel against AggProve, and report the speedup.
+// Both provers run in the same sub-benchmark, so the speedup does not depend on which sub-benchmarks -bench selects.
+// The keys come from a seed, so this does not need the files of ../pkvk-30.
+func BenchmarkVCSAggParallel(b *testing.B) {
+
+	L := uint8(10)
+	ncores := uint8(16) // Change the number of workers here
+	txns := []uint64{1024, 4096}
+
+	vcs := VCS{}
+	vcs.KeyGenSeededInMemory(ncores, L, txns[len(txns)-1], []byte(b.Name()))
+	aFr := GenerateVectorSeeded(vcs.N, []byte(b.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAllParallel(aFr)
+
+	for _, txn := range txns {
+		indexVec := make([]uint64, txn)
+		valueVec := make([]mcl.Fr, txn)
+		proofVec := make([][]mcl.G1, txn)
+		for t := range indexVec {
+			indexVec[t] = uint64(t) % vcs.N
+			valueVec[t] = aFr[indexVec[t]]
+			proofVec[t] = vcs.GetProofPath(vcs.ProofTree, indexVec[t], L)
+		}
+
+		var aggProof batch.Proof
+		b.Run(fmt.Sprintf("%d/AggregateProveParallel;%d;%d", L, txn, ncores), func(b *testing.B) {
+			var seq, par time.Duration
+			for bn := 0; bn < b.N; bn++ {
+				start := time.Now()
+				aggProof = vcs.AggProve(indexVec, proofVec)
+				seq += time.Since(start)
+				start = time.Now()
+				aggProof = vcs.AggProveParallel(indexVec, proofVec, int(ncores))
+				par += time.Since(start)
+			}
+			b.ReportMetric(float64(seq.Nanoseconds())/float64(b.N), "seq-ns/op")
+			b.ReportMetric(float64(par.Nanoseconds())/float64(b.N), "par-ns/op")
+			b.ReportMetric(float64(seq)/float64(par), "speedup")
+			if !vcs.AggVerify(aggProof, digest, indexVec, valueVec) {
+				b.Errorf("Aggregation failed")
+			}
+		})
+	}
+}
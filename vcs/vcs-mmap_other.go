@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package vcs
+
+import (
+	"os"
+)
+
+// No mmap on this platform. Every node is read from the file with ReadAt.
+func mapFile(fileName string) (shardReader, error) {
+	return os.Open(fileName)
+}
@@ -0,0 +1,111 @@
+package vcs
+
+import (
+	"sync"
+
+	"github.com/alinush/go-mcl"
+)
+
+// Below this size a multi-exponentiation is not split, as the split costs more than it saves.
+const PARALLEL_MSM_MIN = 1 << 12
+
+// Same as Commit, but the multi-exponentiation is split in NCORES chunks that run in parallel.
+// The partial results are added in order, so the digest is the same as the one of Commit.
+func (vcs *VCS) CommitParallel(a []mcl.Fr, L uint64) mcl.G1 {
+	var digest mcl.G1
+	n := uint64(len(a))
+	if n < PARALLEL_MSM_MIN || NCORES <= 1 {
+		mcl.G1MulVec(&digest, vcs.UPK[L], a)
+		return digest
+	}
+
+	g1MulVecParallel(&digest, vcs.UPK[L], a, int(NCORES))
+	return digest
+}
+
+// out = sum bases[i] * scalars[i], with the multi-exponentiation split in at most workers chunks.
+// The partial results are added in order.
+func g1MulVecParallel(out *mcl.G1, bases []mcl.G1, scalars []mcl.Fr, workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	partial := make([]mcl.G1, workers) // Chunks past the last one stay zero
+	for i := range partial {
+		partial[i].Clear()
+	}
+	parallelChunks(uint64(len(scalars)), workers, func(chunk int, start, stop uint64) {
+		mcl.G1MulVec(&partial[chunk], bases[start:stop], scalars[start:stop])
+	})
+	out.Clear()
+	for i := range partial {
+		mcl.G1Add(out, out, &partial[i])
+	}
+}
+
+// Same as g1MulVecParallel, in G2.
+func g2MulVecParallel(out *mcl.G2, bases []mcl.G2, scalars []mcl.Fr, workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	partial := make([]mcl.G2, workers) // Chunks past the last one stay zero
+	for i := range partial {
+		partial[i].Clear()
+	}
+	parallelChunks(uint64(len(scalars)), workers, func(chunk int, start, stop uint64) {
+		mcl.G2MulVec(&partial[chunk], bases[start:stop], scalars[start:stop])
+	})
+	out.Clear()
+	for i := range partial {
+		mcl.G2Add(out, out, &partial[i])
+	}
+}
+
+// Same as OpenAll, but uses NCORES cores.
+// The top levels have few nodes over large vectors, so each node there uses CommitParallel.
+// Below them, the subtrees are handed to a pool of NCORES workers that run OpenAllRec.
+func (vcs *VCS) OpenAllParallel(a []mcl.Fr) {
+
+	vcs.ProofTree = make([][]mcl.G1, vcs.L)
+	for i := uint8(0); i < vcs.L; i++ {
+		vcs.ProofTree[i] = make([]mcl.G1, 1<<i)
+	}
+
+	workers := uint64(NCORES)
+	if workers == 0 {
+		workers = 1
+	}
+	depth := uint8(0) // First level with at least as many subtrees as workers
+	for uint64(1)<<depth < workers && depth < vcs.L {
+		depth++
+	}
+
+	for x := uint8(0); x < depth; x++ {
+		bin := vcs.N >> x
+		half := bin / 2
+		aDiff := make([]mcl.Fr, half)
+		for y := uint64(0); y < uint64(1)<<x; y++ {
+			start := y * bin
+			parallelRange(half, func(lo, hi uint64) {
+				for i := lo; i < hi; i++ {
+					mcl.FrSub(&aDiff[i], &a[start+half+i], &a[start+i])
+				}
+			})
+			vcs.ProofTree[x][y] = vcs.CommitParallel(aDiff, uint64(vcs.L-x-1))
+		}
+	}
+
+	// Every subtree writes to its own nodes of the proof tree.
+	var wg sync.WaitGroup
+	pool := make(chan struct{}, workers)
+	bin := vcs.N >> depth
+	for y := uint64(0); y < uint64(1)<<depth; y++ {
+		wg.Add(1)
+		pool <- struct{}{}
+		go func(start uint64) {
+			defer wg.Done()
+			vcs.OpenAllRec(a, start, start+bin, vcs.L-depth)
+			<-pool
+		}(y * bin)
+	}
+	wg.Wait()
+}
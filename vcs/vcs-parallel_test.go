@@ -0,0 +1,66 @@
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestVCSParallel(t *testing.T) {
+
+	L := uint8(13)
+	vcs := newTestAggVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+	proofTree := vcs.ProofTree
+
+	indexVec := make([]uint64, 6)
+	valueVec := make([]mcl.Fr, len(indexVec))
+	proofVec := make([][]mcl.G1, len(indexVec))
+	for t := range indexVec {
+		indexVec[t] = uint64(977*t+5) % vcs.N
+		valueVec[t] = aFr[indexVec[t]]
+		proofVec[t] = vcs.GetProofPath(proofTree, indexVec[t], L)
+	}
+
+	for _, ncores := range []uint8{1, 3, 16} {
+		NCORES = ncores
+
+		t.Run(fmt.Sprintf("%d/Commit;%d", L, ncores), func(t *testing.T) {
+			got := vcs.CommitParallel(aFr, uint64(L))
+			if !bytes.Equal(got.Serialize(), digest.Serialize()) {
+				t.Errorf("CommitParallel does not match Commit")
+			}
+		})
+
+		t.Run(fmt.Sprintf("%d/OpenAll;%d", L, ncores), func(t *testing.T) {
+			vcs.OpenAllParallel(aFr)
+			for x := range proofTree {
+				for y := range proofTree[x] {
+					if !bytes.Equal(vcs.ProofTree[x][y].Serialize(), proofTree[x][y].Serialize()) {
+						t.Fatalf("Node %d of level %d does not match", y, x)
+					}
+				}
+			}
+		})
+
+		for _, n := range []int{1, 3, 6} {
+			t.Run(fmt.Sprintf("%d/AggProve;%d;%d", L, ncores, n), func(t *testing.T) {
+				want, err := EncodeAggProof(vcs.AggProve(indexVec[:n], proofVec[:n]), COMPRESSED)
+				check(err)
+				proof := vcs.AggProveParallel(indexVec[:n], proofVec[:n], int(ncores))
+				got, err := EncodeAggProof(proof, COMPRESSED)
+				check(err)
+				if !bytes.Equal(got, want) {
+					t.Errorf("AggProveParallel of %d entries does not match AggProve", n)
+				}
+				if !vcs.AggVerify(proof, digest, indexVec[:n], valueVec[:n]) {
+					t.Errorf("AggProveParallel of %d entries failed", n)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package vcs
+
+import (
+	"fmt"
+
+	"github.com/alinush/go-mcl"
+)
+
+// Domain separation tag of HashPayload. Bump the version if the hash changes.
+const PAYLOAD_DST = "hyperproofs-go payload v1"
+
+// An entry of the vector holds one Fr, so an account with more data than an AccountCodec packs
+// (balances, storage roots, code hashes, ...) is committed by the hash to field of its bytes.
+// The vector is HashPayloads of the payloads, and a payload is opened with the usual proof path:
+//
+//	aFr := vc.HashPayloads(payloads)
+//	digest := vcs.Commit(aFr, uint64(L))
+//	vcs.OpenAll(aFr)
+//	proof, _ := vcs.ProvePayload(i)
+//	vcs.VerifyPayload(digest, i, payloads[i], proof)
+//
+// A nil payload is an empty entry, whose Fr is zero. An empty, non nil payload is hashed like any other.
+func HashPayload(payload []byte) mcl.Fr {
+	if payload == nil {
+		var zero mcl.Fr
+		zero.Clear()
+		return zero
+	}
+	return hashToFr(PAYLOAD_DST, payload, "payload", 0)
+}
+
+// Entries of the vector for payloads, one per index.
+func HashPayloads(payloads [][]byte) []mcl.Fr {
+	aFr := make([]mcl.Fr, len(payloads))
+	parallelRange(uint64(len(payloads)), func(start, stop uint64) {
+		for i := start; i < stop; i++ {
+			aFr[i] = HashPayload(payloads[i])
+		}
+	})
+	return aFr
+}
+
+// Digest of the vector of payloads, and the vector for OpenAll. There must be N payloads.
+func (vcs *VCS) CommitPayloads(payloads [][]byte) (mcl.G1, []mcl.Fr, error) {
+	var digest mcl.G1
+	if uint64(len(payloads)) != vcs.N {
+		return digest, nil, fmt.Errorf("%w: %d payloads, want %d", ErrInvalidParam, len(payloads), vcs.N)
+	}
+	aFr := HashPayloads(payloads)
+	digest = vcs.Commit(aFr, uint64(vcs.L))
+	return digest, aFr, nil
+}
+
+// Proof path of the payload at index, from the proof tree OpenAll computed for HashPayloads.
+func (vcs *VCS) ProvePayload(index uint64) ([]mcl.G1, error) {
+	if index >= vcs.N {
+		return nil, fmt.Errorf("%w: index %d, vector size %d", ErrIndexOutOfRange, index, vcs.N)
+	}
+	if len(vcs.ProofTree) != int(vcs.L) {
+		return nil, fmt.Errorf("%w: ProvePayload: the proof tree is not computed", ErrParamMismatch)
+	}
+	return vcs.GetProofPath(vcs.ProofTree, index, vcs.L), nil
+}
+
+// Same as Verify, for the payload committed at index.
+func (vcs *VCS) VerifyPayload(digest mcl.G1, index uint64, payload []byte, proof []mcl.G1) bool {
+	return vcs.Verify(digest, index, HashPayload(payload), proof)
+}
+
+// Same as TryVerify, for the payload committed at index.
+func (vcs *VCS) TryVerifyPayload(digest mcl.G1, index uint64, payload []byte, proof []mcl.G1) (bool, error) {
+	return vcs.TryVerify(digest, index, HashPayload(payload), proof)
+}
+
+// Delta that turns the entry of oldPayload into the one of newPayload, for UpdateCom and UpdateProofTree.
+// oldPayload is nil to fill an empty entry, and newPayload is nil to empty it.
+func PayloadDelta(oldPayload []byte, newPayload []byte) mcl.Fr {
+	var delta mcl.Fr
+	oldFr := HashPayload(oldPayload)
+	newFr := HashPayload(newPayload)
+	mcl.FrSub(&delta, &newFr, &oldFr)
+	return delta
+}
+
+// Same as PayloadDelta, for UpdateComVec and UpdateProofTreeBulk.
+func PayloadDeltaVec(oldPayloads [][]byte, newPayloads [][]byte) ([]mcl.Fr, error) {
+	if len(oldPayloads) != len(newPayloads) {
+		return nil, fmt.Errorf("%w: %d old and %d new payloads", ErrInvalidParam, len(oldPayloads), len(newPayloads))
+	}
+	deltaVec := make([]mcl.Fr, len(newPayloads))
+	for t := range newPayloads {
+		deltaVec[t] = PayloadDelta(oldPayloads[t], newPayloads[t])
+	}
+	return deltaVec, nil
+}
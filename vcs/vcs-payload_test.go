@@ -0,0 +1,86 @@
+package vcs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPayload(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestVCS(t, L, 2)
+
+	// Payloads of every size, and empty entries.
+	payloads := make([][]byte, vcs.N)
+	for i := range payloads {
+		if i%5 != 4 {
+			payloads[i] = bytes.Repeat([]byte{byte(i)}, i*100)
+		}
+	}
+	digest, aFr, err := vcs.CommitPayloads(payloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vcs.OpenAll(aFr)
+
+	t.Run(fmt.Sprintf("%d/Verify;", L), func(t *testing.T) {
+		if !aFr[4].IsZero() || aFr[0].IsZero() {
+			t.Errorf("Only nil payloads are empty entries")
+		}
+		for i := range payloads {
+			proof, err := vcs.ProvePayload(uint64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !vcs.VerifyPayload(digest, uint64(i), payloads[i], proof) {
+				t.Errorf("Verification failed for payload %d", i)
+			}
+		}
+		proof, _ := vcs.ProvePayload(3)
+		tampered := append([]byte{}, payloads[3]...)
+		tampered[len(tampered)-1] ^= 1
+		if vcs.VerifyPayload(digest, 3, tampered, proof) {
+			t.Errorf("Tampered payload verified")
+		}
+		if vcs.VerifyPayload(digest, 4, []byte{}, proof) {
+			t.Errorf("Empty payload verified for an empty entry")
+		}
+		if _, err := vcs.ProvePayload(vcs.N); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Out of range index: got %v", err)
+		}
+		if _, _, err := vcs.CommitPayloads(payloads[1:]); !errors.Is(err, ErrInvalidParam) {
+			t.Errorf("Short vector: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Update;", L), func(t *testing.T) {
+		indexVec := []uint64{2, 4, 9}
+		newPayloads := [][]byte{[]byte("storage root"), []byte("new account"), nil}
+		oldPayloads := [][]byte{payloads[2], payloads[4], payloads[9]}
+		deltaVec, err := PayloadDeltaVec(oldPayloads, newPayloads)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest = vcs.UpdateComVec(digest, indexVec, deltaVec)
+		vcs.ProofTree, _ = vcs.UpdateProofTreeBulk(vcs.ProofTree, indexVec, deltaVec)
+		for t := range indexVec {
+			payloads[indexVec[t]] = newPayloads[t]
+		}
+
+		want, _, _ := vcs.CommitPayloads(payloads)
+		if !digest.IsEqual(&want) {
+			t.Errorf("Updated digest does not match")
+		}
+		for _, i := range []uint64{0, 2, 4, 9} {
+			proof, _ := vcs.ProvePayload(i)
+			if !vcs.VerifyPayload(digest, i, payloads[i], proof) {
+				t.Errorf("Verification failed for payload %d after the update", i)
+			}
+		}
+		if _, err := PayloadDeltaVec(oldPayloads, newPayloads[1:]); !errors.Is(err, ErrInvalidParam) {
+			t.Errorf("Length mismatch: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,341 @@
+package vcs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alinush/go-mcl"
+)
+
+// Number of proof tree nodes OpenAllToDisk computes before writing them out.
+const PROOFTREE_BATCH = 1 << 14
+
+// Proof tree on disk, for ell where vcs.ProofTree does not fit in memory.
+// The nodes are flattened level by level, root first, and split in NFILES chunks like the UPK tree.
+// Node y of level x is at index 2^x - 1 + y. Each chunk is a key file of kind FILE_PROOFTREE.
+// Set writes through to the file, as described in chunkFiles.
+type DiskProofTree struct {
+	L      uint8
+	folder string
+	chunkFiles
+}
+
+// Number of nodes in the proof tree of a vector of size 2^L.
+func proofTreeSize(L uint8) uint64 {
+	return (uint64(1) << L) - 1
+}
+
+// Same as OpenAll, but the proof tree is written to chunked files in folder instead of vcs.ProofTree.
+// Besides a and the UPK, it keeps at most N/2 field elements and PROOFTREE_BATCH nodes in memory.
+// a and vcs.UPK still have to be in memory: at ell 30 that is 32 GB for a and about 300 GB for the UPK.
+// OpenAllStoreToDisk reads both from disk instead.
+func (vcs *VCS) OpenAllToDisk(a []mcl.Fr, folder string) (*DiskProofTree, error) {
+
+	if uint64(len(a)) != vcs.N {
+		return nil, fmt.Errorf("%w: OpenAllToDisk: vector has %d entries, want %d", ErrInvalidParam, len(a), vcs.N)
+	}
+	if len(vcs.UPK) != int(vcs.L)+1 {
+		return nil, fmt.Errorf("%w: OpenAllToDisk: UPK is not loaded", ErrParamMismatch)
+	}
+	return vcs.writeProofTree(folder, func(x uint8, b uint64, e uint64, nodes []mcl.G1) error {
+		bin := vcs.N >> x
+		half := bin / 2
+		upk := vcs.UPK[vcs.L-x-1]
+		parallelRange(e-b, func(start, stop uint64) {
+			aDiff := make([]mcl.Fr, half)
+			for y := b + start; y < b+stop; y++ {
+				lo := y * bin
+				for i := uint64(0); i < half; i++ {
+					mcl.FrSub(&aDiff[i], &a[lo+half+i], &a[lo+i])
+				}
+				mcl.G1MulVec(&nodes[y-b], upk, aDiff)
+			}
+		})
+		return nil
+	})
+}
+
+// Same as OpenAllToDisk, but the vector is read from store, and the UPK from the UPK store if one is set (see SetUpkStore),
+// PROOFTREE_WINDOW entries at a time. Besides PROOFTREE_BATCH nodes, each of the NCORES workers keeps about
+// 2 * PROOFTREE_WINDOW entries of the vector and PROOFTREE_WINDOW nodes of the UPK in memory, a few tens of MB,
+// so neither the vector nor the UPK has to fit in memory.
+func (vcs *VCS) OpenAllStoreToDisk(store *VectorStore, folder string) (*DiskProofTree, error) {
+
+	if store.N != vcs.N {
+		return nil, fmt.Errorf("%w: OpenAllStoreToDisk: vector has %d entries, want %d", ErrInvalidParam, store.N, vcs.N)
+	}
+	if vcs.upkStore == nil && len(vcs.UPK) != int(vcs.L)+1 {
+		return nil, fmt.Errorf("%w: OpenAllStoreToDisk: UPK is not loaded", ErrParamMismatch)
+	}
+	workers := int(NCORES)
+	if workers <= 0 {
+		workers = 1
+	}
+	var upk []mcl.G1 // The UPK level of the nodes, when it fits in a window
+	return vcs.writeProofTree(folder, func(x uint8, b uint64, e uint64, nodes []mcl.G1) error {
+		bin := vcs.N >> x
+		half := bin / 2
+		level := vcs.L - x - 1
+		errs := make([]error, workers)
+
+		if half > PROOFTREE_WINDOW {
+			// Few nodes of many entries: split every node in windows, and add up the windows of each node.
+			windows := half / PROOFTREE_WINDOW
+			partial := make([]mcl.G1, (e-b)*windows)
+			parallelChunks(uint64(len(partial)), workers, func(chunk int, start, stop uint64) {
+				for w := start; w < stop && errs[chunk] == nil; w++ {
+					lo := (b+w/windows)*bin + (w%windows)*PROOFTREE_WINDOW
+					errs[chunk] = vcs.proofTreeWindow(store, level, lo, half, (w%windows)*PROOFTREE_WINDOW, PROOFTREE_WINDOW, &partial[w])
+				}
+			})
+			if err := firstError(errs); err != nil {
+				return err
+			}
+			for y := b; y < e; y++ {
+				nodes[y-b].Clear()
+				for w := uint64(0); w < windows; w++ {
+					mcl.G1Add(&nodes[y-b], &nodes[y-b], &partial[(y-b)*windows+w])
+				}
+			}
+			return nil
+		}
+
+		// Many nodes of few entries: the UPK level fits in a window, and each read of the vector covers several nodes.
+		if uint64(len(upk)) != half {
+			var err error
+			if upk, err = vcs.upkRange(level, 0, half); err != nil {
+				return err
+			}
+		}
+		group := maxUint64(PROOFTREE_WINDOW/bin, 1)
+		parallelChunks((e-b+group-1)/group, workers, func(chunk int, start, stop uint64) {
+			aDiff := make([]mcl.Fr, half)
+			for g := start; g < stop && errs[chunk] == nil; g++ {
+				y0 := b + g*group
+				y1 := minUint64(y0+group, e)
+				a, err := store.ReadRange(y0*bin, y1*bin)
+				if err != nil {
+					errs[chunk] = err
+					return
+				}
+				for y := y0; y < y1; y++ {
+					lo := (y - y0) * bin
+					for i := uint64(0); i < half; i++ {
+						mcl.FrSub(&aDiff[i], &a[lo+half+i], &a[lo+i])
+					}
+					mcl.G1MulVec(&nodes[y-b], upk, aDiff)
+				}
+			}
+		})
+		return firstError(errs)
+	})
+}
+
+// Entries of the vector and nodes of the UPK that OpenAllStoreToDisk reads at a time, per worker. A power of 2.
+var PROOFTREE_WINDOW = uint64(1 << 16)
+
+// Window [k, k + w) of a node of the proof tree whose left half starts at lo - k:
+// the sum over i in [0, w) of UPK[level][k + i] * (a[lo + half + i] - a[lo + i]).
+func (vcs *VCS) proofTreeWindow(store *VectorStore, level uint8, lo uint64, half uint64, k uint64, w uint64, out *mcl.G1) error {
+	left, err := store.ReadRange(lo, lo+w)
+	if err != nil {
+		return err
+	}
+	right, err := store.ReadRange(lo+half, lo+half+w)
+	if err != nil {
+		return err
+	}
+	upk, err := vcs.upkRange(level, k, k+w)
+	if err != nil {
+		return err
+	}
+	for i := range right {
+		mcl.FrSub(&right[i], &right[i], &left[i])
+	}
+	mcl.G1MulVec(out, upk, right)
+	return nil
+}
+
+// Writes the proof tree to chunked files in folder. compute gives nodes [b, e) of level x,
+// at most PROOFTREE_BATCH at a time, and the nodes are written in the order they are stored.
+func (vcs *VCS) writeProofTree(folder string, compute func(x uint8, b uint64, e uint64, nodes []mcl.G1) error) (*DiskProofTree, error) {
+
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	total := proofTreeSize(vcs.L)
+	step := (total + uint64(NFILES) - 1) / uint64(NFILES)
+
+	// Nodes are computed in the order they are stored, so the chunks are written one after the other.
+	var f *keyFileWriter
+	chunk := uint8(0)
+	chunkStop := uint64(0)
+	j := uint64(0) // Index of the next node
+	nextChunk := func() error {
+		if f != nil {
+			if err := f.Close(); err != nil {
+				return err
+			}
+			fmt.Println("Dumped ", f.f.Name(), BoundsPrint(f.header.Start, f.header.Stop))
+		}
+		fileName := folder + fmt.Sprintf(PROOFTREENAME, chunk)
+		chunkStop = minUint64(j+step, total)
+		var err error
+		f, err = createKeyFile(fileName, vcs.newFileHeader(FILE_PROOFTREE, NFILES, chunk, j, chunkStop))
+		chunk++
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.f.Close()
+		}
+	}()
+
+	nodes := make([]mcl.G1, PROOFTREE_BATCH)
+	for x := uint8(0); x < vcs.L; x++ {
+		width := uint64(1) << x
+		for b := uint64(0); b < width; b += PROOFTREE_BATCH {
+			e := minUint64(b+PROOFTREE_BATCH, width)
+			if err := compute(x, b, e, nodes); err != nil {
+				return nil, err
+			}
+
+			for y := b; y < e; y++ {
+				if j == chunkStop {
+					if err := nextChunk(); err != nil {
+						return nil, err
+					}
+				}
+				if _, err := f.Write(nodes[y-b].Serialize()); err != nil {
+					return nil, err
+				}
+				j++
+			}
+		}
+	}
+	// Empty chunks at the end, as UpkGenDriver writes them.
+	for chunk < NFILES {
+		if err := nextChunk(); err != nil {
+			return nil, err
+		}
+	}
+	err := f.Close()
+	f = nil
+	if err != nil {
+		return nil, err
+	}
+	return vcs.openDiskProofTree(folder, false)
+}
+
+// Opens a proof tree written by OpenAllToDisk for the loaded setup and checks its checksums.
+func (vcs *VCS) OpenDiskProofTree(folder string) (*DiskProofTree, error) {
+	return vcs.openDiskProofTree(folder, true)
+}
+
+func (vcs *VCS) openDiskProofTree(folder string, verify bool) (*DiskProofTree, error) {
+
+	chunks, next, err := vcs.openChunks(folder, FILE_PROOFTREE, PROOFTREENAME, false)
+	if err != nil {
+		return nil, err
+	}
+	if next != proofTreeSize(vcs.L) {
+		for i := range chunks {
+			chunks[i].Close()
+		}
+		return nil, fmt.Errorf("%w: %s holds %d nodes, want %d for ell %d", ErrParamMismatch, folder, next, proofTreeSize(vcs.L), vcs.L)
+	}
+
+	tree := DiskProofTree{L: vcs.L, folder: folder}
+	tree.elemSize = GetG1ByteSize()
+	for i := range chunks {
+		if err = tree.reopen(chunks[i], verify); err != nil {
+			for _, c := range chunks[i+1:] {
+				c.Close()
+			}
+			tree.Close()
+			return nil, err
+		}
+	}
+	return &tree, nil
+}
+
+// Chunk of a node and the offset of the node in it.
+func (tree *DiskProofTree) locate(level uint8, index uint64) (int, int64, error) {
+	if level >= tree.L || index >= uint64(1)<<level {
+		return 0, 0, fmt.Errorf("%w: node %d of level %d, proof tree of ell %d", ErrIndexOutOfRange, index, level, tree.L)
+	}
+	i, offset := tree.chunkOf((uint64(1) << level) - 1 + index)
+	return i, offset, nil
+}
+
+// Node index of level level. Level 0 is the root.
+func (tree *DiskProofTree) Get(level uint8, index uint64) (mcl.G1, error) {
+	var node mcl.G1
+	i, offset, err := tree.locate(level, index)
+	if err != nil {
+		return node, err
+	}
+	buf := make([]byte, GetG1ByteSize())
+	if _, err = tree.files[i].ReadAt(buf, offset); err != nil {
+		return node, fmt.Errorf("%w: %s: %v", ErrCorruptKeyFile, tree.files[i].Name(), err)
+	}
+	if err = node.Deserialize(buf); err != nil {
+		return node, fmt.Errorf("%w: %s: node %d of level %d: %v", ErrCorruptKeyFile, tree.files[i].Name(), index, level, err)
+	}
+	return node, nil
+}
+
+func (tree *DiskProofTree) Set(level uint8, index uint64, node mcl.G1) error {
+	i, offset, err := tree.locate(level, index)
+	if err != nil {
+		return err
+	}
+	return tree.writeAt(i, node.Serialize(), offset)
+}
+
+// Same as GetProofPath, but reads the proof from a proof tree on disk.
+func (vcs *VCS) GetProofPathDisk(tree *DiskProofTree, index uint64) ([]mcl.G1, error) {
+	if index >= uint64(1)<<tree.L {
+		return nil, fmt.Errorf("%w: index %d, vector size %d", ErrIndexOutOfRange, index, uint64(1)<<tree.L)
+	}
+	proof := make([]mcl.G1, tree.L)
+	id := index
+	var err error
+	for j := uint8(0); j < tree.L; j++ {
+		id = id >> 1
+		if proof[j], err = tree.Get(tree.L-j-1, id); err != nil {
+			return nil, err
+		}
+	}
+	return proof, nil
+}
+
+// Same as UpdateProofTreeBulkInPlace, but on a proof tree on disk.
+// Returns the number of nodes that were updated.
+func (vcs *VCS) UpdateProofTreeBulkDisk(tree *DiskProofTree, updateindexVec []uint64, deltaVec []mcl.Fr) (int, error) {
+	if tree.L != vcs.L {
+		return 0, fmt.Errorf("%w: proof tree of ell %d, want %d", ErrParamMismatch, tree.L, vcs.L)
+	}
+	if len(updateindexVec) != len(deltaVec) {
+		return 0, fmt.Errorf("%w: %d indices and %d deltas", ErrInvalidParam, len(updateindexVec), len(deltaVec))
+	}
+	for t := range updateindexVec {
+		if updateindexVec[t] >= vcs.N {
+			return 0, fmt.Errorf("%w: entry %d has index %d, vector size %d", ErrIndexOutOfRange, t, updateindexVec[t], vcs.N)
+		}
+	}
+
+	deltas := vcs.proofTreeDeltas(updateindexVec, deltaVec)
+	for key, q_i := range deltas {
+		node, err := tree.Get(key.level, key.index)
+		if err != nil {
+			return 0, err
+		}
+		mcl.G1Add(&node, &node, &q_i)
+		if err = tree.Set(key.level, key.index, node); err != nil {
+			return 0, err
+		}
+	}
+	return len(deltas), nil
+}
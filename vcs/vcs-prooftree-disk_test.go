@@ -0,0 +1,154 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestDiskProofTree(t *testing.T) {
+
+	L := uint8(5)
+	vcs := newTestVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+	folder := t.TempDir()
+
+	tree, err := vcs.OpenAllToDisk(aFr, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run(fmt.Sprintf("%d/OpenAll;", L), func(t *testing.T) {
+		for x := range vcs.ProofTree {
+			for y := range vcs.ProofTree[x] {
+				node, err := tree.Get(uint8(x), uint64(y))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !node.IsEqual(&vcs.ProofTree[x][y]) {
+					t.Errorf("Node %d of level %d does not match", y, x)
+				}
+			}
+		}
+		for _, index := range []uint64{0, 7, vcs.N - 1} {
+			proof, err := vcs.GetProofPathDisk(tree, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !vcs.Verify(digest, index, aFr[index], proof) {
+				t.Errorf("Verification failed for %d", index)
+			}
+		}
+	})
+
+	// A small window, so the upper levels are split in windows and the lower ones read several nodes at a time.
+	t.Run(fmt.Sprintf("%d/Store;", L), func(t *testing.T) {
+		store, err := CreateVectorStore(t.TempDir(), aFr, 3)
+		check(err)
+		defer store.Close()
+		upk, err := vcs.OpenUpkStore()
+		check(err)
+		vcs.SetUpkStore(upk)
+		window := PROOFTREE_WINDOW
+		defer func() {
+			PROOFTREE_WINDOW = window
+			vcs.SetUpkStore(nil)
+			upk.Close()
+		}()
+		PROOFTREE_WINDOW = 4
+
+		streamed, err := vcs.OpenAllStoreToDisk(store, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer streamed.Close()
+		for x := range vcs.ProofTree {
+			for y := range vcs.ProofTree[x] {
+				node, err := streamed.Get(uint8(x), uint64(y))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !node.IsEqual(&vcs.ProofTree[x][y]) {
+					t.Errorf("Node %d of level %d does not match", y, x)
+				}
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/UpdateBulk;", L), func(t *testing.T) {
+		indexVec := []uint64{3, 17, 18, 31}
+		deltaVec := GenerateVectorSeeded(uint64(len(indexVec)), []byte("deltas"))
+		newTree, touched := vcs.UpdateProofTreeBulk(vcs.ProofTree, indexVec, deltaVec)
+		n, err := vcs.UpdateProofTreeBulkDisk(tree, indexVec, deltaVec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != touched {
+			t.Errorf("Updated %d nodes, want %d", n, touched)
+		}
+		vcs.ProofTree = newTree
+		digest = vcs.UpdateComVec(digest, indexVec, deltaVec)
+		for k := range indexVec {
+			mcl.FrAdd(&aFr[indexVec[k]], &aFr[indexVec[k]], &deltaVec[k])
+		}
+
+		// The checksums are updated on Close, so the tree opens again.
+		if err = tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+		tree, err = vcs.OpenDiskProofTree(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, index := range indexVec {
+			proof, err := vcs.GetProofPathDisk(tree, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := vcs.GetProofPath(vcs.ProofTree, index, L)
+			for i := range proof {
+				if !proof[i].IsEqual(&want[i]) {
+					t.Errorf("Proof of %d does not match at %d", index, i)
+				}
+			}
+			if !vcs.Verify(digest, index, aFr[index], proof) {
+				t.Errorf("Verification failed for %d", index)
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Errors;", L), func(t *testing.T) {
+		if _, err := vcs.GetProofPathDisk(tree, vcs.N); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Expected ErrIndexOutOfRange, got %v", err)
+		}
+		if _, err := tree.Get(L, 0); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Expected ErrIndexOutOfRange, got %v", err)
+		}
+		check(tree.Close())
+
+		fileName := folder + fmt.Sprintf(PROOFTREENAME, 3)
+		data, err := os.ReadFile(fileName)
+		check(err)
+		data[len(data)-1] ^= 1
+		check(os.WriteFile(fileName, data, 0644))
+		if _, err := vcs.OpenDiskProofTree(folder); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Expected ErrCorruptKeyFile, got %v", err)
+		}
+
+		// A proof tree of a smaller vector.
+		small := VCS{}
+		small.KeyGenSeededInMemory(4, L-1, 2, []byte(t.Name()))
+		smallFolder := t.TempDir()
+		smallTree, err := small.OpenAllToDisk(GenerateVectorSeeded(small.N, []byte(t.Name())), smallFolder)
+		check(err)
+		check(smallTree.Close())
+		if _, err := vcs.OpenDiskProofTree(smallFolder); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Expected ErrParamMismatch, got %v", err)
+		}
+	})
+}
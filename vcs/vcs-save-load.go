@@ -1,11 +1,8 @@
 package vcs
 
 import (
-	"encoding/binary"
 	"fmt"
-	"math"
 	"os"
-	"path/filepath"
 	"sync"
 
 	"github.com/alinush/go-mcl"
@@ -16,13 +13,7 @@ func (vcs *VCS) SaveTrapdoor() {
 	fmt.Println(SEP, "Saving data to:", vcs.folderPath, SEP)
 
 	os.MkdirAll(vcs.folderPath, os.ModePerm)
-	f, err := os.Create(vcs.folderPath + TRAPDOORNAME)
-	check(err)
-
-	// Report the size.
-	LBytes := make([]byte, 8) // Enough space for 64 bits of interger
-	binary.LittleEndian.PutUint64(LBytes, uint64(vcs.L))
-	_, err = f.Write(LBytes)
+	f, err := createKeyFile(vcs.folderPath+TRAPDOORNAME, vcs.newFileHeader(FILE_TRAPDOOR, 1, 0, 0, uint64(vcs.L)))
 	check(err)
 
 	// Write KZG stuff first, as it not related to VCS or size of the VCS.
@@ -47,243 +38,421 @@ func (vcs *VCS) SaveTrapdoor() {
 		check(err)
 	}
 
-	f.Close()
+	check(f.Close())
 	fmt.Println(SEP, "Saved trapdoors", SEP)
 
-	// Create a new file for VRK and write it.
-	f, err = os.Create(vcs.folderPath + VRKNAME)
-	check(err)
+	check(vcs.saveVrk())
+	fmt.Println(SEP, "Saved VRK", SEP)
 
+}
+
+// Writes G, H and the VRK.
+func (vcs *VCS) saveVrk() error {
+
+	// The generators go in the VRK file too, so that the public parameters can be loaded without the trapdoor file.
+	f, err := createKeyFile(vcs.folderPath+VRKNAME, vcs.newFileHeader(FILE_VRK, 1, 0, 0, uint64(vcs.L)))
+	if err != nil {
+		return err
+	}
+	f.Write(vcs.G.Serialize())
+	f.Write(vcs.H.Serialize())
 	for i := range vcs.VRK {
-		_, err = f.Write(vcs.VRK[i].Serialize())
-		check(err)
-		_, err = f.Write(vcs.VRKSubOne[i].Serialize())
-		check(err)
-		_, err = f.Write(vcs.VRKSubOneRev[i].Serialize())
-		check(err)
+		f.Write(vcs.VRK[i].Serialize())
+		f.Write(vcs.VRKSubOne[i].Serialize())
+		f.Write(vcs.VRKSubOneRev[i].Serialize())
 	}
-	f.Close()
-	fmt.Println(SEP, "Saved VRK", SEP)
-
+	return f.Close() // Errors of the buffered writes show up here.
 }
 
-func (vcs *VCS) LoadTrapdoor(L uint8) {
+// Writes the UPK tree in memory to the UPK files, split in NFILES chunks like UpkGenDriver does.
+func (vcs *VCS) saveUpk() error {
 
-	f, err := os.Open(vcs.folderPath + TRAPDOORNAME)
-	check(err)
+	numUPK := (uint64(1) << (vcs.L + 1)) - 1 // Number of nodes in the UPK tree
+	step := (numUPK + uint64(NFILES) - 1) / uint64(NFILES)
+	for i := uint8(0); i < NFILES; i++ {
+		start := minUint64(uint64(i)*step, numUPK)
+		stop := minUint64(start+step, numUPK)
+		fileName := vcs.folderPath + fmt.Sprintf(UPKNAME, i)
+		f, err := createKeyFile(fileName, vcs.newFileHeader(FILE_UPK, NFILES, i, start, stop))
+		if err != nil {
+			return err
+		}
+		for j := start; j < stop; j++ {
+			l, k := IndexInTheLevel(j)
+			f.Write(vcs.UPK[l][k].Serialize())
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		fmt.Println("Dumped ", fileName, BoundsPrint(start, stop))
+	}
+	return nil
+}
 
-	// fileinfo, err := f.Stat()
-	// check(err)
-	// filesize := fileinfo.Size() // In Bytes
-	// estimatedEll := (filesize - int64(GetG1ByteSize()+GetG2ByteSize())) / int64(GetFrByteSize()) / 2
+func (vcs *VCS) LoadTrapdoor(L uint8) {
+	check(vcs.TryLoadTrapdoor(L))
+}
 
-	var data []byte
+// Same as LoadTrapdoor, but a missing, truncated or corrupt file is reported as an error.
+// The trapdoor file fixes the setup: VRK and UPK files of a different setup are rejected afterwards.
+func (vcs *VCS) TryLoadTrapdoor(L uint8) error {
 
-	data = make([]byte, 8)
-	_, err = f.Read(data)
-	check(err)
-	reportedEll := uint8(binary.LittleEndian.Uint64(data))
+	f, err := openKeyFile(vcs.folderPath+TRAPDOORNAME, FILE_TRAPDOOR)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	if reportedEll < L {
-		// Assumes SaveTrapdoor is honest
-		panic(fmt.Sprintf("There is not enough to read! Found: %d, Wants: %d", reportedEll, L))
+	h := f.Header
+	if err = checkSingleFile(f); err != nil {
+		return err
+	}
+	if h.L < L {
+		return fmt.Errorf("%w: %s: There is not enough to read! Found: %d, Wants: %d", ErrParamMismatch, f.f.Name(), h.L, L)
+	}
+	frSize := int64(GetFrByteSize())
+	if err = f.expectPayload(2*frSize + int64(GetG1ByteSize()+GetG2ByteSize()) + 3*int64(h.L)*frSize); err != nil {
+		return err
 	}
 
 	// Load KZG related stuff
-	data = make([]byte, GetFrByteSize())
-	_, err = f.Read(data)
-	check(err)
-	vcs.alpha.Deserialize(data)
-
-	data = make([]byte, GetFrByteSize())
-	_, err = f.Read(data)
-	check(err)
-	vcs.beta.Deserialize(data)
+	if err = readFr(f, &vcs.alpha); err != nil {
+		return fmt.Errorf("%s: alpha: %w", f.f.Name(), err)
+	}
+	if err = readFr(f, &vcs.beta); err != nil {
+		return fmt.Errorf("%s: beta: %w", f.f.Name(), err)
+	}
 
 	// Load the VCS related stuff
-	data = make([]byte, GetG1ByteSize())
-	_, err = f.Read(data)
-	check(err)
-	vcs.G.Deserialize(data)
-
-	data = make([]byte, GetG2ByteSize())
-	_, err = f.Read(data)
-	check(err)
-	vcs.H.Deserialize(data)
+	if err = readG1(f, &vcs.G); err != nil {
+		return fmt.Errorf("%s: G: %w", f.f.Name(), err)
+	}
+	if err = readG2(f, &vcs.H); err != nil {
+		return fmt.Errorf("%s: H: %w", f.f.Name(), err)
+	}
 
 	vcs.L = uint8(L)
 
 	fmt.Println("Loading trapdoors:", L)
 	for i := uint8(0); i < L; i++ {
+		if err = readFr(f, &vcs.trapdoors[i]); err != nil {
+			return fmt.Errorf("%s: trapdoor %d: %w", f.f.Name(), i, err)
+		}
+		if err = readFr(f, &vcs.trapdoorsSubOne[i]); err != nil {
+			return fmt.Errorf("%s: trapdoor %d: %w", f.f.Name(), i, err)
+		}
+		if err = readFr(f, &vcs.trapdoorsSubOneRev[i]); err != nil {
+			return fmt.Errorf("%s: trapdoor %d: %w", f.f.Name(), i, err)
+		}
+	}
+	if err = f.Verify(); err != nil {
+		return err
+	}
 
-		data = make([]byte, GetFrByteSize())
-		_, err = f.Read(data)
-		check(err)
-		vcs.trapdoors[i].Deserialize(data)
-
-		data = make([]byte, GetFrByteSize())
-		_, err = f.Read(data)
-		check(err)
-		vcs.trapdoorsSubOne[i].Deserialize(data)
+	vcs.setupID = h.SetupID
+	vcs.setupL = h.L
+	vcs.setupFile = f.f.Name()
+	return vcs.TryLoadVrk(L)
+}
 
-		data = make([]byte, GetFrByteSize())
-		_, err = f.Read(data)
-		check(err)
-		vcs.trapdoorsSubOneRev[i].Deserialize(data)
+// Trapdoor and VRK files are not split.
+func checkSingleFile(f *keyFileReader) error {
+	h := f.Header
+	if h.NFiles != 1 || h.Index != 0 || h.Start != 0 || h.Stop != uint64(h.L) {
+		return fmt.Errorf("%w: %s: chunk %d of %d with range %s, want the whole range [0, %d)", ErrCorruptKeyFile, f.f.Name(), h.Index, h.NFiles, BoundsPrint(h.Start, h.Stop), h.L)
 	}
+	return nil
+}
 
-	f.Close()
+// Loads the generators and the first L entries of VRK, VRKSubOne and VRKSubOneRev.
+// These are public, no secret is read.
+func (vcs *VCS) LoadVrk(L uint8) {
+	check(vcs.TryLoadVrk(L))
+}
 
-	// Load VRKs
-	f, err = os.Open(vcs.folderPath + VRKNAME)
-	check(err)
+// Same as LoadVrk, but a missing, truncated or corrupt file is reported as an error.
+func (vcs *VCS) TryLoadVrk(L uint8) error {
 
-	data = make([]byte, GetG2ByteSize())
-	for i := uint8(0); i < L; i++ {
-		_, err = f.Read(data)
-		check(err)
-		vcs.VRK[i].Deserialize(data)
+	f, err := openKeyFile(vcs.folderPath+VRKNAME, FILE_VRK)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-		_, err = f.Read(data)
-		check(err)
-		vcs.VRKSubOne[i].Deserialize(data)
+	h := f.Header
+	if err = checkSingleFile(f); err != nil {
+		return err
+	}
+	if vcs.setupFile == "" {
+		vcs.setupID = h.SetupID
+		vcs.setupL = h.L
+		vcs.setupFile = f.f.Name()
+	}
+	if err = vcs.checkSameSetup(f.f.Name(), h); err != nil {
+		return err
+	}
+	if h.L < L {
+		return fmt.Errorf("%w: %s: There is not enough to read! Found: %d, Wants: %d", ErrParamMismatch, f.f.Name(), h.L, L)
+	}
+	if err = f.expectPayload(int64(GetG1ByteSize()+GetG2ByteSize()) + 3*int64(h.L)*int64(GetG2ByteSize())); err != nil {
+		return err
+	}
 
-		_, err = f.Read(data)
-		check(err)
-		vcs.VRKSubOneRev[i].Deserialize(data)
+	if err = readG1(f, &vcs.G); err != nil {
+		return fmt.Errorf("%s: G: %w", f.f.Name(), err)
+	}
+	if err = readG2(f, &vcs.H); err != nil {
+		return fmt.Errorf("%s: H: %w", f.f.Name(), err)
 	}
-	f.Close()
-}
 
-func (vcs *VCS) UpkLoad(fileName string, index uint8, start uint64, stop uint64, wg *sync.WaitGroup) {
-	f, err := os.Open(fileName)
-	check(err)
+	vrk := make([]mcl.G2, h.L)
+	vrkSubOne := make([]mcl.G2, h.L)
+	vrkSubOneRev := make([]mcl.G2, h.L)
+	for i := uint8(0); i < h.L; i++ {
+		if err = readG2(f, &vrk[i]); err != nil {
+			return fmt.Errorf("%s: VRK %d: %w", f.f.Name(), i, err)
+		}
+		if err = readG2(f, &vrkSubOne[i]); err != nil {
+			return fmt.Errorf("%s: VRK %d: %w", f.f.Name(), i, err)
+		}
+		if err = readG2(f, &vrkSubOneRev[i]); err != nil {
+			return fmt.Errorf("%s: VRK %d: %w", f.f.Name(), i, err)
+		}
+	}
+	if err = f.Verify(); err != nil {
+		return err
+	}
+	if setupIDOf(vcs.G, vcs.H, vrk) != h.SetupID {
+		return fmt.Errorf("%w: %s: VRK and generators do not match the setup id", ErrCorruptKeyFile, f.f.Name())
+	}
+	if err = checkGenerators(&vcs.G, &vcs.H); err != nil {
+		return fmt.Errorf("%s: %w", f.f.Name(), err)
+	}
 
-	data := make([]byte, GetG1ByteSize())
+	copy(vcs.VRK, vrk[:L])
+	copy(vcs.VRKSubOne, vrkSubOne[:L])
+	copy(vcs.VRKSubOneRev, vrkSubOneRev[:L])
+	return nil
+}
 
-	var result mcl.G1
-	for j := start; j < stop; j++ {
-		i, k := IndexInTheLevel(j)
-		_, err = f.Read(data)
-		check(err)
-		result.Deserialize(data)
+// Opens a chunk of UPK or PRK and checks that it starts at start and holds the keys up to stop.
+func openChunk(fileName string, kind uint8, start uint64, stop uint64) (*keyFileReader, error) {
+	f, err := openKeyFile(fileName, kind)
+	if err != nil {
+		return nil, err
+	}
+	h := f.Header
+	if h.Start != start || h.Stop < stop {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s holds %s, want %s", ErrParamMismatch, fileName, BoundsPrint(h.Start, h.Stop), BoundsPrint(start, stop))
+	}
+	if err = f.expectPayload(int64(h.Stop-h.Start) * int64(GetG1ByteSize())); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
 
-		vcs.UPK[i][k] = result
+// Opens the chunks of one kind in folder and checks that they belong to the loaded setup and follow each other.
+// Returns the chunks and the end of the last one. With adopt, the setup is taken from the first chunk
+// if no trapdoor or VRK file has been loaded.
+func (vcs *VCS) openChunks(folder string, kind uint8, nameFormat string, adopt bool) ([]*keyFileReader, uint64, error) {
+
+	files := make([]*keyFileReader, 0, NFILES)
+	fail := func(err error) ([]*keyFileReader, uint64, error) {
+		for i := range files {
+			files[i].Close()
+		}
+		return nil, 0, err
+	}
 
+	next := uint64(0)
+	for i := 0; ; i++ {
+		fileName := folder + fmt.Sprintf(nameFormat, i)
+		f, err := openKeyFile(fileName, kind)
+		if err != nil {
+			return fail(err)
+		}
+		files = append(files, f)
+		h := f.Header
+
+		if adopt && vcs.setupFile == "" {
+			vcs.setupID = h.SetupID
+			vcs.setupL = h.L
+			vcs.setupFile = fileName
+		}
+		if err = vcs.checkSameSetup(fileName, h); err != nil {
+			return fail(err)
+		}
+		if h.NFiles == 0 || int(h.Index) != i || h.NFiles != files[0].Header.NFiles {
+			return fail(fmt.Errorf("%w: %s is chunk %d of %d, want chunk %d of %d", ErrParamMismatch, fileName, h.Index, h.NFiles, i, files[0].Header.NFiles))
+		}
+		if h.Start != next {
+			return fail(fmt.Errorf("%w: %s holds %s, previous chunk ends at %d", ErrParamMismatch, fileName, BoundsPrint(h.Start, h.Stop), next))
+		}
+		if err = f.expectPayload(int64(h.Stop-h.Start) * int64(GetG1ByteSize())); err != nil {
+			return fail(err)
+		}
+		next = h.Stop
+		if i+1 == int(h.NFiles) {
+			break
+		}
 	}
-	fmt.Println("Read ", fileName, BoundsPrint(start, stop))
-	defer f.Close()
-	defer wg.Done()
+	return files, next, nil
 }
 
-func (vcs *VCS) UpkLoadDriver() {
+// Opens the chunks of one kind of key, checks that they belong to the loaded setup
+// and cover [0, total(ell)) without gaps, and reads the keys in [0, want) in parallel.
+// The setup is taken from the first chunk if no trapdoor or VRK file has been loaded.
+func (vcs *VCS) loadChunks(kind uint8, nameFormat string, total func(ell uint8) uint64, want uint64, read func(f *keyFileReader, start, stop uint64) error) error {
 
-	// Allocate space for UPK
-	vcs.MallocUpk()
+	files, next, err := vcs.openChunks(vcs.folderPath, kind, nameFormat, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for i := range files {
+			files[i].Close()
+		}
+	}()
+
+	if next != total(vcs.setupL) {
+		return fmt.Errorf("%w: %s holds %d keys, want %d for ell %d", ErrCorruptKeyFile, vcs.folderPath+fmt.Sprintf(nameFormat, len(files)-1), next, total(vcs.setupL), vcs.setupL)
+	}
+	if want > next {
+		return fmt.Errorf("%w: There is not enough to read! Found ell: %d, Wants: %d", ErrParamMismatch, vcs.setupL, vcs.L)
+	}
 
 	var wg sync.WaitGroup
-	var step, start, stop uint64
-	var total, totalBytes int64
-	var i uint8
-
-	var files []string
-	var err error
-
-	files, err = filepath.Glob(vcs.folderPath + "/upk*")
-	check(err)
-	totalBytes = int64(0)
+	errs := make([]error, 0, len(files))
+	var mu sync.Mutex
 	for i := range files {
-		totalBytes += fileSize(files[i])
+		h := files[i].Header
+		if h.Start >= want && h.Start != 0 {
+			break
+		}
+		wg.Add(1)
+		go func(f *keyFileReader, start, stop uint64) {
+			defer wg.Done()
+			err := read(f, start, stop)
+			if err == nil {
+				err = f.Verify()
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(files[i], h.Start, minUint64(h.Stop, want))
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs[0]
 	}
-	total = totalBytes / int64(GetG1ByteSize())
-	step = uint64(math.Ceil(float64(total) / float64(NFILES)))
+	return nil
+}
 
-	numUPK := (uint64(1) << (vcs.L + 1)) - 1
-	start = uint64(0)
-	stop = step
-	stop = minUint64(stop, numUPK)
+func (vcs *VCS) UpkLoad(fileName string, index uint8, start uint64, stop uint64, wg *sync.WaitGroup) {
+	defer wg.Done()
+	check(vcs.upkLoad(fileName, start, stop))
+}
 
-	i = uint8(0)
-	for start < numUPK {
-		wg.Add(1)
-		fileName := vcs.folderPath + fmt.Sprintf(UPKNAME, i)
-		go vcs.UpkLoad(fileName, i, start, stop, &wg)
-		// fmt.Println(i)
-		// fmt.Println("Reading chuck range:", i, BoundsPrint(start, stop))
-		start += step
-		stop += step
-		stop = minUint64(stop, numUPK)
-		i++
+func (vcs *VCS) upkLoad(fileName string, start uint64, stop uint64) error {
+	f, err := openChunk(fileName, FILE_UPK, start, stop)
+	if err != nil {
+		return err
 	}
-	wg.Wait()
+	defer f.Close()
+	if err = vcs.readUpk(f, start, stop); err != nil {
+		return err
+	}
+	return f.Verify()
 }
 
-func (vcs *VCS) PrkLoad(fileName string, index uint8, start uint64, stop uint64, wg *sync.WaitGroup) {
+func (vcs *VCS) readUpk(f *keyFileReader, start uint64, stop uint64) error {
+	for j := start; j < stop; j++ {
+		i, k := IndexInTheLevel(j)
+		if err := readG1(f, &vcs.UPK[i][k]); err != nil {
+			return fmt.Errorf("%s: UPK %d: %w", f.f.Name(), j, err)
+		}
+	}
+	fmt.Println("Read ", f.f.Name(), BoundsPrint(start, stop))
+	return nil
+}
 
-	f, err := os.Open(fileName)
-	check(err)
+func (vcs *VCS) UpkLoadDriver() {
+	check(vcs.TryUpkLoadDriver())
+}
 
-	var data []byte
-	data = make([]byte, GetG1ByteSize())
+// Same as UpkLoadDriver, but a missing, truncated or corrupt UPK file is reported as an error,
+// and so are UPK files that belong to a different setup.
+func (vcs *VCS) TryUpkLoadDriver() error {
 
-	var result mcl.G1
-	for i := start; i < stop; i++ {
-		_, err = f.Read(data)
-		check(err)
-		result.Deserialize(data)
-		vcs.PRK[i] = result
-	}
+	numUPK := func(ell uint8) uint64 { return (uint64(1) << (ell + 1)) - 1 } // Number of nodes in the UPK tree
 
-	fmt.Println("Read ", fileName, BoundsPrint(start, stop))
-	defer f.Close()
-	defer wg.Done()
+	// Allocate space for UPK
+	vcs.MallocUpk()
+	return vcs.loadChunks(FILE_UPK, UPKNAME, numUPK, numUPK(vcs.L), vcs.readUpk)
 }
 
-func (vcs *VCS) PrkLoadDriver() {
-	// Allocate space for PRK
-	vcs.PRK = make([]mcl.G1, vcs.N)
-	var wg sync.WaitGroup
-	var step, start, stop uint64
-	var total, totalBytes int64
-	var i uint8
+func (vcs *VCS) PrkLoad(fileName string, index uint8, start uint64, stop uint64, wg *sync.WaitGroup) {
+	defer wg.Done()
+	check(vcs.prkLoad(fileName, start, stop))
+}
 
-	var files []string
-	var err error
+func (vcs *VCS) prkLoad(fileName string, start uint64, stop uint64) error {
+	f, err := openChunk(fileName, FILE_PRK, start, stop)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = vcs.readPrk(f, start, stop); err != nil {
+		return err
+	}
+	return f.Verify()
+}
 
-	files, err = filepath.Glob(vcs.folderPath + "/prk*")
-	check(err)
-	totalBytes = int64(0)
-	for i := range files {
-		totalBytes += fileSize(files[i])
+func (vcs *VCS) readPrk(f *keyFileReader, start uint64, stop uint64) error {
+	for i := start; i < stop; i++ {
+		if err := readG1(f, &vcs.PRK[i]); err != nil {
+			return fmt.Errorf("%s: PRK %d: %w", f.f.Name(), i, err)
+		}
 	}
-	total = totalBytes / int64(GetG1ByteSize())
-	step = uint64(math.Ceil(float64(total) / float64(NFILES)))
+	fmt.Println("Read ", f.f.Name(), BoundsPrint(start, stop))
+	return nil
+}
 
-	upperBound := (uint64(1) << vcs.L)
+func (vcs *VCS) PrkLoadDriver() {
+	check(vcs.TryPrkLoadDriver())
+}
 
-	i = uint8(0)
-	start = uint64(0)
-	stop = minUint64(step, upperBound)
-	for start < upperBound {
-		wg.Add(1)
-		fileName := vcs.folderPath + fmt.Sprintf(PRKNAME, i)
-		go vcs.PrkLoad(fileName, i, start, stop, &wg)
+// Same as PrkLoadDriver, but a missing, truncated or corrupt PRK file is reported as an error,
+// and so are PRK files that belong to a different setup.
+func (vcs *VCS) TryPrkLoadDriver() error {
 
-		// fmt.Println(i, fmt.Sprintf("%05d %05d", start, stop))
-		start += step
-		stop += step
-		stop = minUint64(stop, upperBound)
-		i++
-	}
-	wg.Wait()
+	numPRK := func(ell uint8) uint64 { return uint64(1) << ell }
+
+	// Allocate space for PRK
+	vcs.PRK = make([]mcl.G1, vcs.N)
+	return vcs.loadChunks(FILE_PRK, PRKNAME, numPRK, vcs.N, vcs.readPrk)
 }
 
 func (vcs *VCS) PrkUpkLoad() {
+	check(vcs.TryPrkUpkLoad())
+}
+
+// Same as PrkUpkLoad, but returns an error instead of panicking.
+func (vcs *VCS) TryPrkUpkLoad() error {
 	fmt.Println(SEP)
-	vcs.UpkLoadDriver()
+	if err := vcs.TryUpkLoadDriver(); err != nil {
+		return err
+	}
 	fmt.Println(SEP)
 	if !vcs.DISCARD_PRK {
-		vcs.PrkLoadDriver()
+		if err := vcs.TryPrkLoadDriver(); err != nil {
+			return err
+		}
 		fmt.Println(SEP)
 	}
+	return nil
 }
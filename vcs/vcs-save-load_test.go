@@ -0,0 +1,160 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/cm"
+)
+
+func TestVCSSaveLoad(t *testing.T) {
+
+	L := uint8(5)
+	vcs := newTestVCS(t, L, 2)
+
+	t.Run(fmt.Sprintf("%d/RoundTrip;", L), func(t *testing.T) {
+		other := VCS{}
+		if err := other.TryInit(L, vcs.folderPath, 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.TryLoadTrapdoor(L); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.TryPrkUpkLoad(); err != nil {
+			t.Fatal(err)
+		}
+		if !other.G.IsEqual(&vcs.G) || !other.H.IsEqual(&vcs.H) {
+			t.Errorf("Generators do not match")
+		}
+		for i := range vcs.VRK {
+			if !other.VRK[i].IsEqual(&vcs.VRK[i]) || !other.trapdoors[i].IsEqual(&vcs.trapdoors[i]) {
+				t.Errorf("VRK or trapdoor %d does not match", i)
+			}
+		}
+		for i := range vcs.UPK {
+			for k := range vcs.UPK[i] {
+				if !other.UPK[i][k].IsEqual(&vcs.UPK[i][k]) {
+					t.Errorf("UPK[%d][%d] does not match", i, k)
+				}
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/SmallerEll;", L), func(t *testing.T) {
+		other := VCS{}
+		if err := other.TryInit(L-2, vcs.folderPath, 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.TryLoadTrapdoor(L - 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.TryUpkLoadDriver(); err != nil {
+			t.Fatal(err)
+		}
+		for i := range other.UPK {
+			for k := range other.UPK[i] {
+				if !other.UPK[i][k].IsEqual(&vcs.UPK[i][k]) {
+					t.Errorf("UPK[%d][%d] does not match", i, k)
+				}
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/MixedSetups;", L), func(t *testing.T) {
+		// Swap in a UPK chunk from a different setup of the same ell.
+		fresh := newTestVCS(t, L, 2)
+		fileName := vcs.folderPath + fmt.Sprintf(UPKNAME, 2)
+		original, err := os.ReadFile(fileName)
+		check(err)
+		data, err := os.ReadFile(fresh.folderPath + fmt.Sprintf(UPKNAME, 2))
+		check(err)
+		check(os.WriteFile(fileName, data, 0644))
+		defer func() { check(os.WriteFile(fileName, original, 0644)) }()
+
+		other := VCS{}
+		other.Init(L, vcs.folderPath, 2)
+		if err := other.TryLoadTrapdoor(L); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.TryUpkLoadDriver(); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Expected ErrParamMismatch, got %v", err)
+		}
+
+		// UPK files of a larger ell.
+		fresh = newTestVCS(t, L+1, 2)
+		other = VCS{}
+		other.Init(L, vcs.folderPath, 2)
+		check(other.TryLoadTrapdoor(L))
+		other.folderPath = fresh.folderPath
+		if err := other.TryUpkLoadDriver(); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("Expected ErrParamMismatch, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Checksum;", L), func(t *testing.T) {
+		fileName := vcs.folderPath + fmt.Sprintf(UPKNAME, 1)
+		data, err := os.ReadFile(fileName)
+		check(err)
+		data[len(data)-1] ^= 1
+		check(os.WriteFile(fileName, data, 0644))
+
+		other := VCS{}
+		other.Init(L, vcs.folderPath, 2)
+		if err := other.TryUpkLoadDriver(); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Expected ErrCorruptKeyFile, got %v", err)
+		}
+	})
+}
+
+// Provers and verifiers only need the public parameters.
+func TestVCSPublicParams(t *testing.T) {
+
+	L := uint8(4)
+	txnLimit := uint64(2)
+	setup := newTestVCS(t, L, txnLimit)
+	mn := setup.aggSize(int(txnLimit))
+	ck, kzg1, kzg2 := cm.IPPSetupKZG(mn, setup.alpha, setup.beta, setup.G, setup.H)
+	cm.IPPSaveCmKzg(ck, kzg1, kzg2, setup.folderPath)
+	if err := setup.DestroyTrapdoors(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(setup.folderPath + TRAPDOORNAME); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Trapdoor file was not removed: %v", err)
+	}
+	if !setup.alpha.IsZero() || !setup.trapdoors[0].IsZero() {
+		t.Errorf("Trapdoors are still in memory")
+	}
+
+	vcs := VCS{}
+	if err := vcs.TryKeyGenLoad(4, L, setup.folderPath, txnLimit); err != nil {
+		t.Fatal(err)
+	}
+	for i := range vcs.trapdoors {
+		if !vcs.trapdoors[i].IsZero() {
+			t.Errorf("Trapdoor %d was loaded", i)
+		}
+	}
+
+	aFr := GenerateVector(vcs.N)
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+	indexVec := []uint64{3, 12}
+	valueVec := []mcl.Fr{aFr[3], aFr[12]}
+	proofVec := [][]mcl.G1{vcs.GetProofPath(vcs.ProofTree, 3, L), vcs.GetProofPath(vcs.ProofTree, 12, L)}
+
+	t.Run(fmt.Sprintf("%d/Verify;", L), func(t *testing.T) {
+		if status, _ := vcs.VerifyMemoized(digest, indexVec, valueVec, proofVec); !status {
+			t.Errorf("Verification failed")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/AggVerify;", L), func(t *testing.T) {
+		proof := vcs.AggProve(indexVec, proofVec)
+		if !vcs.AggVerify(proof, digest, indexVec, valueVec) {
+			t.Errorf("Aggregation failed")
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package vcs
+
+import (
+	"encoding/binary"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/cm"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Domain separation tag of the seeded setup. Bump the version if the derivation changes.
+const SEEDED_SETUP_DST = "hyperproofs-go seeded setup v1"
+
+// Only for tests and benchmarks: anyone who knows the seed knows the trapdoors.
+//
+// Every value is derived from the length prefixed encoding of (dst, seed, label, counter).
+func seededInput(dst string, seed []byte, label string, counter uint64) []byte {
+	var buf []byte
+	n := make([]byte, 8)
+	for _, part := range [][]byte{[]byte(dst), seed, []byte(label)} {
+		binary.LittleEndian.PutUint64(n, uint64(len(part)))
+		buf = append(buf, n...)
+		buf = append(buf, part...)
+	}
+	binary.LittleEndian.PutUint64(n, counter)
+	return append(buf, n...)
+}
+
+// Hash to field: blake2b-512 of the input reduced mod r.
+// The 512 bit digest makes the bias of the reduction negligible.
+func hashToFr(dst string, seed []byte, label string, counter uint64) mcl.Fr {
+	digest := blake2b.Sum512(seededInput(dst, seed, label, counter))
+	var x mcl.Fr
+	check(x.SetLittleEndianMod(digest[:]))
+	return x
+}
+
+// Same as TrapdoorsGen, but the trapdoors, alpha and beta are derived from seed.
+// The same seed and ell give the same keys on every machine.
+func (vcs *VCS) TrapdoorsGenSeeded(seed []byte) {
+	vcs.trapdoorsFromSeed(seed)
+	vcs.SaveTrapdoor()
+}
+
+func (vcs *VCS) trapdoorsFromSeed(seed []byte) {
+
+	vcs.G, vcs.H = StandardGenerators()
+
+	for i := range vcs.trapdoors {
+		vcs.trapdoors[i] = hashToFr(SEEDED_SETUP_DST, seed, "trapdoor", uint64(i))
+	}
+	vcs.alpha = hashToFr(SEEDED_SETUP_DST, seed, "alpha", 0)
+	vcs.beta = hashToFr(SEEDED_SETUP_DST, seed, "beta", 0)
+	vcs.deriveVrk()
+}
+
+// Same as KeyGen, but the keys are derived from seed.
+func (vcs *VCS) KeyGenSeeded(ncores uint8, L uint8, folder string, txnLimit uint64, seed []byte) {
+
+	NCORES = ncores
+	vcs.Init(L, folder, txnLimit)
+	vcs.TrapdoorsGenSeeded(seed)
+	vcs.PrkUpkGen()
+	vcs.GenAggGipa()
+}
+
+// Same as KeyGenSeeded, but nothing is written to disk.
+// The aggregation keys are only as large as L * txnLimit needs, so this is cheap for small ell.
+func (vcs *VCS) KeyGenSeededInMemory(ncores uint8, L uint8, txnLimit uint64, seed []byte) {
+
+	NCORES = ncores
+	vcs.Init(L, "", txnLimit)
+	vcs.trapdoorsFromSeed(seed)
+	vcs.setupFile = "" // Nothing on disk
+
+	// UPK
+	vcs.MallocUpk()
+	numUPK := (uint64(1) << (vcs.L + 1)) - 1
+	parallelRange(numUPK, func(start, stop uint64) {
+		for j := start; j < stop; j++ {
+			i, k := IndexInTheLevel(j)
+			exponent := vcs.SelectUPK(i, k)
+			mcl.G1Mul(&vcs.UPK[i][k], &vcs.G, &exponent)
+		}
+	})
+
+	// Aggregation keys
+	mn := vcs.aggSize(int(txnLimit))
+	ck, kzg1, kzg2 := cm.IPPSetupKZG(mn, vcs.alpha, vcs.beta, vcs.G, vcs.H)
+	vcs.setAggKeys(mn, *ck, *kzg1, *kzg2)
+}
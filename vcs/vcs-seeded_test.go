@@ -0,0 +1,56 @@
+package vcs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestKeyGenSeeded(t *testing.T) {
+
+	mcl.InitFromString("bls12-381")
+	L := uint8(4)
+	txnLimit := uint64(2)
+	a, b, c := VCS{}, VCS{}, VCS{}
+	a.KeyGenSeededInMemory(4, L, txnLimit, []byte("seed"))
+	b.KeyGenSeededInMemory(4, L, txnLimit, []byte("seed"))
+	c.KeyGenSeededInMemory(4, L, txnLimit, []byte("other seed"))
+
+	t.Run(fmt.Sprintf("%d/Reproducible;", L), func(t *testing.T) {
+		if !IsEqual(&a, &b) || a.setupID != b.setupID {
+			t.Errorf("Same seed gave different keys")
+		}
+		if a.setupID == c.setupID || a.VRK[0].IsEqual(&c.VRK[0]) {
+			t.Errorf("Different seeds gave the same keys")
+		}
+		u, v := GenerateVectorSeeded(a.N, []byte("vector")), GenerateVectorSeeded(a.N, []byte("vector"))
+		for i := range u {
+			if !u[i].IsEqual(&v[i]) {
+				t.Fatalf("Same seed gave different vectors at %d", i)
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/OnDisk;", L), func(t *testing.T) {
+		d := VCS{}
+		d.Init(L, t.TempDir(), txnLimit)
+		d.TrapdoorsGenSeeded([]byte("seed"))
+		d.PrkUpkGen()
+		if !IsEqual(&a, &d) {
+			t.Errorf("Keys on disk differ from keys in memory")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/AggregateVerify;%d", L, txnLimit), func(t *testing.T) {
+		aFr := GenerateVectorSeeded(a.N, []byte("vector"))
+		digest := a.Commit(aFr, uint64(L))
+		a.OpenAll(aFr)
+		indexVec := []uint64{1, 14}
+		proofVec := [][]mcl.G1{a.GetProofPath(a.ProofTree, 1, L), a.GetProofPath(a.ProofTree, 14, L)}
+		proof := a.AggProve(indexVec, proofVec)
+		if !a.AggVerify(proof, digest, indexVec, []mcl.Fr{aFr[1], aFr[14]}) {
+			t.Errorf("Aggregation failed")
+		}
+	})
+}
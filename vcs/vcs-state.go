@@ -0,0 +1,231 @@
+package vcs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/alinush/go-mcl"
+)
+
+const STATENAME = "/state.data"
+const STATELOGNAME = "/state.log"
+
+// Keeps the vector, the digest and the proof tree of a node consistent across crashes.
+//
+// Every block is journaled in the write-ahead log (a DeltaLog in STATELOGNAME) and synced before it is applied,
+// and it is applied to the vector, the digest and the proof tree under one lock.
+// Checkpoint writes the three together to STATENAME and starts the log over.
+// On restart, OpenStateManager loads the checkpoint and replays the journaled blocks, which completes a block
+// the crash interrupted. A block whose journal record was not complete was never applied, and its record is dropped.
+// A checkpoint that was being written is dropped as well, so the previous one stays in use.
+type StateManager struct {
+	vcs       *VCS
+	folder    string
+	mu        sync.RWMutex
+	vector    []mcl.Fr
+	digest    mcl.G1
+	proofTree [][]mcl.G1
+	seq       uint64 // Number of blocks applied
+	log       *DeltaLog
+	recovered uint64 // Number of blocks replayed by OpenStateManager
+}
+
+// Starts a state manager for the vector a in folder, and writes its first checkpoint.
+// The proof tree is computed with OpenAll, so vcs.ProofTree is the proof tree of the state manager afterwards.
+func (vcs *VCS) NewStateManager(folder string, a []mcl.Fr) (*StateManager, error) {
+	if uint64(len(a)) != vcs.N {
+		return nil, fmt.Errorf("%w: vector of size %d, want %d", ErrInvalidParam, len(a), vcs.N)
+	}
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	sm := StateManager{vcs: vcs, folder: folder}
+	sm.vector = make([]mcl.Fr, len(a))
+	copy(sm.vector, a)
+	sm.digest = vcs.Commit(sm.vector, uint64(vcs.L))
+	vcs.OpenAll(sm.vector)
+	sm.proofTree = vcs.ProofTree
+
+	if err := vcs.saveStateFile(folder+STATENAME, sm.seq, sm.digest, sm.vector, sm.proofTree); err != nil {
+		return nil, err
+	}
+	log, err := vcs.createDeltaLog(folder+STATELOGNAME, 0)
+	if err != nil {
+		return nil, err
+	}
+	sm.log = log
+	return &sm, nil
+}
+
+// Restores the state manager in folder from its last checkpoint and the blocks journaled after it.
+func (vcs *VCS) OpenStateManager(folder string) (*StateManager, error) {
+
+	// Leftovers of a checkpoint or of a log rotation that did not finish.
+	for _, name := range []string{STATENAME, STATELOGNAME} {
+		if err := os.Remove(folder + name + ".tmp"); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	sm := StateManager{vcs: vcs, folder: folder}
+	var err error
+	sm.seq, sm.digest, sm.vector, sm.proofTree, err = vcs.loadStateFile(folder + STATENAME)
+	if err != nil {
+		return nil, err
+	}
+	log, err := vcs.OpenDeltaLog(folder + STATELOGNAME)
+	if err != nil {
+		return nil, err
+	}
+	if log.first > sm.seq {
+		log.Close()
+		return nil, fmt.Errorf("%w: %s starts at block %d, the checkpoint is at %d", ErrCorruptKeyFile, folder+STATELOGNAME, log.first, sm.seq)
+	}
+	err = log.Replay(sm.seq, func(seq uint64, updateindexVec []uint64, deltaVec []mcl.Fr) error {
+		sm.apply(updateindexVec, deltaVec)
+		sm.recovered++
+		return nil
+	})
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	sm.log = log
+	return &sm, nil
+}
+
+// Applies a block of updates: aFr[updateindexVec[t]] += deltaVec[t] for every t.
+// The block is in the log on disk before any of the state changes. If it cannot be journaled, the state is unchanged.
+func (sm *StateManager) ApplyBlock(updateindexVec []uint64, deltaVec []mcl.Fr) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	// Append checks the block, so apply does not fail halfway.
+	if _, err := sm.log.Append(updateindexVec, deltaVec); err != nil {
+		return err
+	}
+	sm.apply(updateindexVec, deltaVec)
+	return nil
+}
+
+func (sm *StateManager) apply(updateindexVec []uint64, deltaVec []mcl.Fr) {
+	for t := range updateindexVec {
+		mcl.FrAdd(&sm.vector[updateindexVec[t]], &sm.vector[updateindexVec[t]], &deltaVec[t])
+	}
+	sm.digest = sm.vcs.UpdateComVec(sm.digest, updateindexVec, deltaVec)
+	sm.vcs.UpdateProofTreeBulkInPlace(sm.proofTree, updateindexVec, deltaVec)
+	sm.seq++
+}
+
+// Writes the vector, the digest and the proof tree to disk and starts the log over.
+// Restarts are faster after a checkpoint, as fewer blocks are replayed.
+func (sm *StateManager) Checkpoint() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if err := sm.vcs.saveStateFile(sm.folder+STATENAME, sm.seq, sm.digest, sm.vector, sm.proofTree); err != nil {
+		return err
+	}
+	// A crash here leaves the old log, whose blocks are all in the checkpoint and are not replayed.
+	log, err := sm.vcs.createDeltaLog(sm.folder+STATELOGNAME, sm.seq)
+	if err != nil {
+		return err
+	}
+	sm.log.Close()
+	sm.log = log
+	return nil
+}
+
+// Payload: seq, the digest, the vector and the proof tree level by level from the root.
+func (vcs *VCS) saveStateFile(fileName string, seq uint64, digest mcl.G1, vector []mcl.Fr, proofTree [][]mcl.G1) error {
+	header := vcs.newFileHeader(FILE_STATE, 1, 0, 0, vcs.N)
+	return vcs.writeCheckpoint(fileName, header, func(w io.Writer) error {
+		if err := writeUint64(w, seq); err != nil {
+			return err
+		}
+		if _, err := w.Write(digest.Serialize()); err != nil {
+			return err
+		}
+		for i := range vector {
+			if _, err := w.Write(vector[i].Serialize()); err != nil {
+				return err
+			}
+		}
+		return writeProofTree(w, proofTree)
+	})
+}
+
+func (vcs *VCS) loadStateFile(fileName string) (uint64, mcl.G1, []mcl.Fr, [][]mcl.G1, error) {
+	var seq uint64
+	var digest mcl.G1
+	f, err := openKeyFile(fileName, FILE_STATE)
+	if err != nil {
+		return seq, digest, nil, nil, err
+	}
+	defer f.Close()
+	if err = vcs.checkStateFile(fileName, f.Header); err != nil {
+		return seq, digest, nil, nil, err
+	}
+	if f.Header.Stop != vcs.N {
+		return seq, digest, nil, nil, fmt.Errorf("%w: %s holds a vector of size %d, want %d", ErrParamMismatch, fileName, f.Header.Stop, vcs.N)
+	}
+	size := 8 + int64(vcs.N)*int64(GetFrByteSize()) + int64(proofTreeSize(vcs.L)+1)*int64(GetG1ByteSize())
+	if err = f.expectPayload(size); err != nil {
+		return seq, digest, nil, nil, err
+	}
+
+	er := elementReader{r: f}
+	er.Uint64(&seq)
+	er.G1(&digest)
+	vector := make([]mcl.Fr, vcs.N)
+	for i := range vector {
+		er.Fr(&vector[i])
+	}
+	proofTree := vcs.readProofTree(&er)
+	if er.err != nil {
+		return seq, digest, nil, nil, fmt.Errorf("%s: %w", fileName, er.err)
+	}
+	if err = f.Verify(); err != nil {
+		return seq, digest, nil, nil, err
+	}
+	return seq, digest, vector, proofTree, nil
+}
+
+// Number of blocks applied since NewStateManager.
+func (sm *StateManager) Seq() uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.seq
+}
+
+// Number of blocks OpenStateManager replayed from the log.
+func (sm *StateManager) Recovered() uint64 {
+	return sm.recovered
+}
+
+func (sm *StateManager) Digest() mcl.G1 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.digest
+}
+
+// Value at index and its proof, against Digest at the same block.
+func (sm *StateManager) Open(index uint64) (mcl.G1, mcl.Fr, []mcl.G1, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if index >= sm.vcs.N {
+		var value mcl.Fr
+		return sm.digest, value, nil, fmt.Errorf("%w: index %d, vector size %d", ErrIndexOutOfRange, index, sm.vcs.N)
+	}
+	return sm.digest, sm.vector[index], sm.vcs.GetProofPath(sm.proofTree, index, sm.vcs.L), nil
+}
+
+// Closes the log. Blocks applied since the last checkpoint are replayed by the next OpenStateManager.
+func (sm *StateManager) Close() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.log.Close()
+}
@@ -0,0 +1,141 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestStateManager(t *testing.T) {
+
+	L := uint8(5)
+	vcs := newTestVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	folder := t.TempDir()
+
+	batches := [][]uint64{{3, 17}, {17, 31, 0}, {8}}
+	deltas := make([][]mcl.Fr, len(batches))
+	for i := range batches {
+		deltas[i] = GenerateVectorSeeded(uint64(len(batches[i])), []byte(fmt.Sprintf("deltas-%d", i)))
+	}
+	// Vector after the first i blocks.
+	expected := func(i int) []mcl.Fr {
+		b := make([]mcl.Fr, len(aFr))
+		copy(b, aFr)
+		for j := 0; j < i; j++ {
+			for t := range batches[j] {
+				mcl.FrAdd(&b[batches[j][t]], &b[batches[j][t]], &deltas[j][t])
+			}
+		}
+		return b
+	}
+	checkState := func(t *testing.T, sm *StateManager, blocks int) {
+		b := expected(blocks)
+		want := vcs.Commit(b, uint64(L))
+		if sm.Seq() != uint64(blocks) {
+			t.Errorf("State is at block %d, want %d", sm.Seq(), blocks)
+		}
+		for _, index := range []uint64{0, 3, 8, 17, 20, 31} {
+			digest, value, proof, err := sm.Open(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !digest.IsEqual(&want) {
+				t.Fatalf("Digest does not match the vector")
+			}
+			if !value.IsEqual(&b[index]) || !vcs.Verify(digest, index, value, proof) {
+				t.Errorf("Opening of %d does not match", index)
+			}
+		}
+	}
+
+	sm, err := vcs.NewStateManager(folder, aFr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run(fmt.Sprintf("%d/Replay;", L), func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if err := sm.ApplyBlock(batches[i], deltas[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkState(t, sm, 2)
+		sm.Close() // Crash: no checkpoint since NewStateManager
+
+		sm, err = vcs.OpenStateManager(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sm.Recovered() != 2 {
+			t.Errorf("Replayed %d blocks, want 2", sm.Recovered())
+		}
+		checkState(t, sm, 2)
+	})
+
+	t.Run(fmt.Sprintf("%d/Checkpoint;", L), func(t *testing.T) {
+		if err := sm.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.ApplyBlock(batches[2], deltas[2]); err != nil {
+			t.Fatal(err)
+		}
+		sm.Close()
+
+		sm, err = vcs.OpenStateManager(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sm.Recovered() != 1 {
+			t.Errorf("Replayed %d blocks, want 1", sm.Recovered())
+		}
+		checkState(t, sm, 3)
+	})
+
+	t.Run(fmt.Sprintf("%d/Crash;", L), func(t *testing.T) {
+		if err := sm.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.ApplyBlock(batches[0], deltas[0]); err != nil {
+			t.Fatal(err)
+		}
+		sm.Close()
+
+		// The journal record of the last block is torn, and a checkpoint was being written.
+		logName := folder + STATELOGNAME
+		fi, _ := os.Stat(logName)
+		os.Truncate(logName, fi.Size()-1)
+		os.WriteFile(folder+STATENAME+".tmp", []byte("partial"), 0644)
+
+		sm, err = vcs.OpenStateManager(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sm.Recovered() != 0 {
+			t.Errorf("Replayed %d blocks, want 0", sm.Recovered())
+		}
+		checkState(t, sm, 3)
+		if _, err := os.Stat(folder + STATENAME + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("Partial checkpoint was not removed: %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Errors;", L), func(t *testing.T) {
+		if err := sm.ApplyBlock([]uint64{vcs.N}, deltas[2]); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Out of range index: got %v", err)
+		}
+		if err := sm.ApplyBlock(batches[0], deltas[2]); !errors.Is(err, ErrInvalidParam) {
+			t.Errorf("Length mismatch: got %v", err)
+		}
+		checkState(t, sm, 3)
+		sm.Close()
+
+		other := newTestVCS(t, L, 2)
+		if _, err := other.OpenStateManager(folder); !errors.Is(err, ErrParamMismatch) {
+			t.Errorf("State of another setup: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,276 @@
+package vcs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+)
+
+// Text and JSON forms of digests, proofs, aggregated proofs and values, for logs and JSON APIs.
+// The text is the hex of the compressed binary encoding, see EncodeDigest and friends,
+// so external tools can decode it with the same rules. Values are the 32 byte mcl serialization of Fr.
+// MarshalBase64 and UnmarshalBase64 give the same bytes in standard base64 with padding, for APIs that want it.
+//
+//	digest := vcs.Commit(aFr, uint64(L))
+//	out, _ := json.Marshal(vcs.Opening{Digest: vcs.Digest(digest), Index: i, Value: vcs.Value(aFr[i]), Proof: proof})
+type Digest mcl.G1
+type ProofPath []mcl.G1
+type AggProof batch.Proof
+type Value mcl.Fr
+
+// One opening of the vector commitment, as a verifier gets it.
+type Opening struct {
+	Digest Digest    `json:"digest"`
+	Index  uint64    `json:"index"`
+	Value  Value     `json:"value"`
+	Proof  ProofPath `json:"proof"`
+}
+
+// Openings of several indices with one aggregated proof.
+type AggOpening struct {
+	Digest  Digest   `json:"digest"`
+	Indices []uint64 `json:"indices"`
+	Values  []Value  `json:"values"`
+	Proof   AggProof `json:"proof"`
+}
+
+func encodeHex(buf []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, hex.EncodedLen(len(buf)))
+	hex.Encode(out, buf)
+	return out, nil
+}
+
+func decodeHex(text []byte) ([]byte, error) {
+	buf := make([]byte, hex.DecodedLen(len(text)))
+	n, err := hex.Decode(buf, text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	return buf[:n], nil
+}
+
+func encodeBase64(buf []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(buf)))
+	base64.StdEncoding.Encode(out, buf)
+	return out, nil
+}
+
+func decodeBase64(text []byte) ([]byte, error) {
+	buf := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Strict().Decode(buf, text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	return buf[:n], nil
+}
+
+// JSON strings of the hex text, as encoding/json does for a TextMarshaler.
+func marshalJSON(text []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+func unmarshalJSON(data []byte) ([]byte, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	return []byte(s), nil
+}
+
+func (d Digest) binary() ([]byte, error) {
+	return EncodeDigest(mcl.G1(d), COMPRESSED), nil
+}
+
+func (d *Digest) setBinary(buf []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	digest, err := DecodeDigest(buf)
+	if err != nil {
+		return err
+	}
+	*d = Digest(digest)
+	return nil
+}
+
+func (d Digest) MarshalText() ([]byte, error) {
+	return encodeHex(d.binary())
+}
+
+func (d *Digest) UnmarshalText(text []byte) error {
+	return d.setBinary(decodeHex(text))
+}
+
+func (d Digest) MarshalBase64() ([]byte, error) {
+	return encodeBase64(d.binary())
+}
+
+func (d *Digest) UnmarshalBase64(text []byte) error {
+	return d.setBinary(decodeBase64(text))
+}
+
+func (d Digest) MarshalJSON() ([]byte, error) {
+	return marshalJSON(d.MarshalText())
+}
+
+func (d *Digest) UnmarshalJSON(data []byte) error {
+	text, err := unmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	return d.UnmarshalText(text)
+}
+
+func (p ProofPath) binary() ([]byte, error) {
+	return EncodeProof(p, COMPRESSED)
+}
+
+func (p *ProofPath) setBinary(buf []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	proof, err := DecodeProof(buf)
+	if err != nil {
+		return err
+	}
+	*p = proof
+	return nil
+}
+
+func (p ProofPath) MarshalText() ([]byte, error) {
+	return encodeHex(p.binary())
+}
+
+func (p *ProofPath) UnmarshalText(text []byte) error {
+	return p.setBinary(decodeHex(text))
+}
+
+func (p ProofPath) MarshalBase64() ([]byte, error) {
+	return encodeBase64(p.binary())
+}
+
+func (p *ProofPath) UnmarshalBase64(text []byte) error {
+	return p.setBinary(decodeBase64(text))
+}
+
+func (p ProofPath) MarshalJSON() ([]byte, error) {
+	return marshalJSON(p.MarshalText())
+}
+
+func (p *ProofPath) UnmarshalJSON(data []byte) error {
+	text, err := unmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	return p.UnmarshalText(text)
+}
+
+func (p AggProof) binary() ([]byte, error) {
+	return EncodeAggProof(batch.Proof(p), COMPRESSED)
+}
+
+func (p *AggProof) setBinary(buf []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	proof, err := DecodeAggProof(buf)
+	if err != nil {
+		return err
+	}
+	*p = AggProof(proof)
+	return nil
+}
+
+func (p AggProof) MarshalText() ([]byte, error) {
+	return encodeHex(p.binary())
+}
+
+func (p *AggProof) UnmarshalText(text []byte) error {
+	return p.setBinary(decodeHex(text))
+}
+
+func (p AggProof) MarshalBase64() ([]byte, error) {
+	return encodeBase64(p.binary())
+}
+
+func (p *AggProof) UnmarshalBase64(text []byte) error {
+	return p.setBinary(decodeBase64(text))
+}
+
+func (p AggProof) MarshalJSON() ([]byte, error) {
+	return marshalJSON(p.MarshalText())
+}
+
+func (p *AggProof) UnmarshalJSON(data []byte) error {
+	text, err := unmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	return p.UnmarshalText(text)
+}
+
+func (v Value) binary() ([]byte, error) {
+	x := mcl.Fr(v)
+	return x.Serialize(), nil
+}
+
+// Only the canonical serialization of an element of Fr is accepted.
+func (v *Value) setBinary(buf []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	var x mcl.Fr
+	if len(buf) != GetFrByteSize() {
+		return fmt.Errorf("%w: value is %d bytes, want %d", ErrInvalidEncoding, len(buf), GetFrByteSize())
+	}
+	if err = x.Deserialize(buf); err != nil {
+		return fmt.Errorf("%w: not an element of Fr: %v", ErrInvalidEncoding, err)
+	}
+	if !bytes.Equal(x.Serialize(), buf) {
+		return fmt.Errorf("%w: value is not canonical", ErrInvalidEncoding)
+	}
+	*v = Value(x)
+	return nil
+}
+
+func (v Value) MarshalText() ([]byte, error) {
+	return encodeHex(v.binary())
+}
+
+func (v *Value) UnmarshalText(text []byte) error {
+	return v.setBinary(decodeHex(text))
+}
+
+func (v Value) MarshalBase64() ([]byte, error) {
+	return encodeBase64(v.binary())
+}
+
+func (v *Value) UnmarshalBase64(text []byte) error {
+	return v.setBinary(decodeBase64(text))
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	return marshalJSON(v.MarshalText())
+}
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	text, err := unmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	return v.UnmarshalText(text)
+}
@@ -0,0 +1,118 @@
+package vcs
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+	"github.com/hyperproofs/gipa-go/batch"
+)
+
+func TestTextEncoding(t *testing.T) {
+
+	L := uint8(4)
+	vcs := newTestAggVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+	indexVec := []uint64{4, 11}
+	valueVec := []mcl.Fr{aFr[4], aFr[11]}
+	proofVec := [][]mcl.G1{vcs.GetProofPath(vcs.ProofTree, 4, L), vcs.GetProofPath(vcs.ProofTree, 11, L)}
+
+	t.Run(fmt.Sprintf("%d/Hex;", L), func(t *testing.T) {
+		text, err := Digest(digest).MarshalText()
+		check(err)
+		if string(text) != hex.EncodeToString(EncodeDigest(digest, COMPRESSED)) {
+			t.Errorf("Text is not the hex of the binary encoding")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Base64;", L), func(t *testing.T) {
+		text, err := Digest(digest).MarshalBase64()
+		check(err)
+		if string(text) != base64.StdEncoding.EncodeToString(EncodeDigest(digest, COMPRESSED)) {
+			t.Errorf("Text is not the base64 of the binary encoding")
+		}
+		var d Digest
+		var v Value
+		var p ProofPath
+		var agg AggProof
+		check(d.UnmarshalBase64(text))
+		text, err = Value(valueVec[0]).MarshalBase64()
+		check(err)
+		check(v.UnmarshalBase64(text))
+		text, err = ProofPath(proofVec[0]).MarshalBase64()
+		check(err)
+		check(p.UnmarshalBase64(text))
+		if !vcs.Verify(mcl.G1(d), indexVec[0], mcl.Fr(v), p) {
+			t.Errorf("Opening decoded from base64 does not verify")
+		}
+		text, err = AggProof(vcs.AggProve(indexVec, proofVec)).MarshalBase64()
+		check(err)
+		check(agg.UnmarshalBase64(text))
+		if !vcs.AggVerify(batch.Proof(agg), digest, indexVec, valueVec) {
+			t.Errorf("Aggregated proof decoded from base64 does not verify")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/JSON;", L), func(t *testing.T) {
+		opening := Opening{Digest: Digest(digest), Index: indexVec[0], Value: Value(valueVec[0]), Proof: proofVec[0]}
+		data, err := json.Marshal(opening)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Opening
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !vcs.Verify(mcl.G1(decoded.Digest), decoded.Index, mcl.Fr(decoded.Value), decoded.Proof) {
+			t.Errorf("Decoded opening does not verify")
+		}
+
+		agg := AggOpening{Digest: Digest(digest), Indices: indexVec, Proof: AggProof(vcs.AggProve(indexVec, proofVec))}
+		for i := range valueVec {
+			agg.Values = append(agg.Values, Value(valueVec[i]))
+		}
+		data, err = json.Marshal(agg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decodedAgg AggOpening
+		if err := json.Unmarshal(data, &decodedAgg); err != nil {
+			t.Fatal(err)
+		}
+		values := make([]mcl.Fr, len(decodedAgg.Values))
+		for i := range values {
+			values[i] = mcl.Fr(decodedAgg.Values[i])
+		}
+		if !vcs.AggVerify(batch.Proof(decodedAgg.Proof), mcl.G1(decodedAgg.Digest), decodedAgg.Indices, values) {
+			t.Errorf("Decoded aggregated opening does not verify")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Invalid;", L), func(t *testing.T) {
+		var d Digest
+		if err := json.Unmarshal([]byte(`"zz"`), &d); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("Expected ErrInvalidEncoding, got %v", err)
+		}
+		if err := json.Unmarshal([]byte(`42`), &d); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("Expected ErrInvalidEncoding, got %v", err)
+		}
+		var v Value
+		// Larger than the order of Fr.
+		large := make([]byte, 32)
+		for i := range large {
+			large[i] = 0xff
+		}
+		if err := v.UnmarshalText([]byte(hex.EncodeToString(large))); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("Expected ErrInvalidEncoding, got %v", err)
+		}
+		if err := d.UnmarshalBase64([]byte("not base64!")); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("Expected ErrInvalidEncoding, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/alinush/go-mcl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Read access to the UPK tree.
+// vcs.UPK keeps the whole tree in memory, which takes tens of GB beyond ell 26.
+// A DiskUpkStore maps the UPK files instead, so only the nodes that are used are read.
+type UpkStore interface {
+	// Node k of level l of the UPK tree. Level 0 is g.
+	Upk(level uint8, k uint64) (mcl.G1, error)
+	Close() error
+}
+
+// UPK tree in memory.
+type MemUpkStore struct {
+	UPK [][]mcl.G1
+}
+
+func (s MemUpkStore) Upk(level uint8, k uint64) (mcl.G1, error) {
+	if int(level) >= len(s.UPK) || k >= uint64(len(s.UPK[level])) {
+		var zero mcl.G1
+		return zero, fmt.Errorf("%w: UPK node %d of level %d", ErrIndexOutOfRange, k, level)
+	}
+	return s.UPK[level][k], nil
+}
+
+func (s MemUpkStore) Close() error {
+	return nil
+}
+
+// UPK tree in the upk-XX.data files. The files are memory mapped where the platform supports it,
+// and read with ReadAt otherwise, so memory use follows the nodes that are accessed.
+type DiskUpkStore struct {
+	L      uint8
+	shards []shardReader
+	header []FileHeader
+}
+
+// Payload of a shard, readable at any offset. See mapFile.
+type shardReader interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Maps the UPK files of the folder given to Init and uses them for GetUpk, UpdateCom and UpdateProofTree.
+// vcs.UPK is not allocated.
+func (vcs *VCS) UpkMapDriver() {
+	check(vcs.TryUpkMapDriver())
+}
+
+// Same as UpkMapDriver, but a missing or truncated UPK file or files of a different setup are reported as an error.
+// The checksums are not checked, as that reads every node. See DiskUpkStore.Verify.
+func (vcs *VCS) TryUpkMapDriver() error {
+	store, err := vcs.OpenUpkStore()
+	if err != nil {
+		return err
+	}
+	vcs.SetUpkStore(store)
+	return nil
+}
+
+// Opens the UPK files of the folder given to Init without reading them.
+func (vcs *VCS) OpenUpkStore() (*DiskUpkStore, error) {
+
+	chunks, next, err := vcs.openChunks(vcs.folderPath, FILE_UPK, UPKNAME, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := range chunks {
+		chunks[i].Close()
+	}
+
+	numUPK := (uint64(1) << (vcs.setupL + 1)) - 1
+	if next != numUPK {
+		return nil, fmt.Errorf("%w: %s holds %d keys, want %d for ell %d", ErrCorruptKeyFile, vcs.folderPath+fmt.Sprintf(UPKNAME, len(chunks)-1), next, numUPK, vcs.setupL)
+	}
+	if vcs.L > vcs.setupL {
+		return nil, fmt.Errorf("%w: There is not enough to read! Found ell: %d, Wants: %d", ErrParamMismatch, vcs.setupL, vcs.L)
+	}
+
+	store := DiskUpkStore{L: vcs.L}
+	for i := range chunks {
+		shard, err := mapFile(chunks[i].f.Name())
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store.shards = append(store.shards, shard)
+		store.header = append(store.header, chunks[i].Header)
+	}
+	return &store, nil
+}
+
+func (s *DiskUpkStore) Upk(level uint8, k uint64) (mcl.G1, error) {
+	var node mcl.G1
+	if level > s.L || k >= uint64(1)<<level {
+		return node, fmt.Errorf("%w: UPK node %d of level %d", ErrIndexOutOfRange, k, level)
+	}
+	j := (uint64(1) << level) - 1 + k
+	i := sort.Search(len(s.header), func(i int) bool { return j < s.header[i].Stop })
+	buf := make([]byte, GetG1ByteSize())
+	offset := HEADER_SIZE + int64(j-s.header[i].Start)*int64(GetG1ByteSize())
+	if _, err := s.shards[i].ReadAt(buf, offset); err != nil {
+		return node, fmt.Errorf("%w: UPK chunk %d: %v", ErrCorruptKeyFile, i, err)
+	}
+	if err := node.Deserialize(buf); err != nil {
+		return node, fmt.Errorf("%w: UPK chunk %d: node %d of level %d: %v", ErrCorruptKeyFile, i, k, level, err)
+	}
+	return node, nil
+}
+
+// Checks the checksums of the UPK files. Reads every node once, without keeping them in memory.
+func (s *DiskUpkStore) Verify() error {
+	for i := range s.shards {
+		h := s.header[i]
+		hasher, _ := blake2b.New256(nil)
+		size := int64(h.Stop-h.Start) * int64(GetG1ByteSize())
+		if _, err := io.Copy(hasher, io.NewSectionReader(s.shards[i], HEADER_SIZE, size)); err != nil {
+			return fmt.Errorf("%w: UPK chunk %d: %v", ErrCorruptKeyFile, i, err)
+		}
+		if !bytes.Equal(hasher.Sum(nil), h.Checksum[:]) {
+			return fmt.Errorf("%w: UPK chunk %d: checksum mismatch", ErrCorruptKeyFile, i)
+		}
+	}
+	return nil
+}
+
+func (s *DiskUpkStore) Close() error {
+	var err error
+	for i := range s.shards {
+		if cerr := s.shards[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	s.shards = nil
+	return err
+}
+
+// Reads the UPK from store instead of vcs.UPK. With nil, vcs.UPK is used again.
+func (vcs *VCS) SetUpkStore(store UpkStore) {
+	vcs.upkStore = store
+}
+
+// Node k of level l of the UPK tree, from the UPK store if one is set.
+func (vcs *VCS) upkAt(level uint8, k uint64) mcl.G1 {
+	if vcs.upkStore == nil {
+		return vcs.UPK[level][k]
+	}
+	node, err := vcs.upkStore.Upk(level, k)
+	check(err)
+	return node
+}
+
+// Nodes [start, stop) of a level of the UPK tree, from the UPK store if one is set.
+func (vcs *VCS) upkRange(level uint8, start uint64, stop uint64) ([]mcl.G1, error) {
+	if vcs.upkStore == nil {
+		return vcs.UPK[level][start:stop], nil
+	}
+	upk := make([]mcl.G1, stop-start)
+	for k := start; k < stop; k++ {
+		node, err := vcs.upkStore.Upk(level, k)
+		if err != nil {
+			return nil, err
+		}
+		upk[k-start] = node
+	}
+	return upk, nil
+}
@@ -0,0 +1,114 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestUpkStore(t *testing.T) {
+
+	L := uint8(5)
+	vcs := newTestVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	digest := vcs.Commit(aFr, uint64(L))
+	vcs.OpenAll(aFr)
+
+	mapped := VCS{}
+	mapped.Init(L, vcs.folderPath, 2)
+	check(mapped.TryLoadVrk(L))
+	if err := mapped.TryUpkMapDriver(); err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.upkStore.Close()
+	if mapped.UPK != nil {
+		t.Fatalf("UPK was loaded in memory")
+	}
+
+	t.Run(fmt.Sprintf("%d/GetUpk;", L), func(t *testing.T) {
+		for i := uint64(0); i < vcs.N; i++ {
+			if !SliceIsEqual(mapped.GetUpk(i), vcs.GetUpk(i)) {
+				t.Errorf("UPK of %d does not match", i)
+			}
+		}
+		mem := VCS{L: L, N: vcs.N}
+		mem.SetUpkStore(MemUpkStore{UPK: vcs.UPK})
+		if !SliceIsEqual(mem.GetUpk(9), vcs.GetUpk(9)) {
+			t.Errorf("UPK of the memory store does not match")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Update;", L), func(t *testing.T) {
+		indexVec := []uint64{2, 19}
+		deltaVec := GenerateVectorSeeded(2, []byte("deltas"))
+		want := vcs.UpdateComVec(digest, indexVec, deltaVec)
+		got := mapped.UpdateComVec(digest, indexVec, deltaVec)
+		if !got.IsEqual(&want) {
+			t.Errorf("UpdateComVec does not match")
+		}
+		want = vcs.UpdateCom(digest, indexVec[0], deltaVec[0])
+		got = mapped.UpdateCom(digest, indexVec[0], deltaVec[0])
+		if !got.IsEqual(&want) {
+			t.Errorf("UpdateCom does not match")
+		}
+
+		mapped.ProofTree = make([][]mcl.G1, L)
+		for i := range vcs.ProofTree {
+			mapped.ProofTree[i] = append([]mcl.G1{}, vcs.ProofTree[i]...)
+		}
+		vcs.UpdateProofTree(indexVec[1], deltaVec[1])
+		mapped.UpdateProofTree(indexVec[1], deltaVec[1])
+		for i := range vcs.ProofTree {
+			if !SliceIsEqual(mapped.ProofTree[i], vcs.ProofTree[i]) {
+				t.Errorf("Level %d of the proof tree does not match", i)
+			}
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/SmallerEll;", L), func(t *testing.T) {
+		other := VCS{}
+		other.Init(L-2, vcs.folderPath, 2)
+		store, err := other.OpenUpkStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		node, err := store.Upk(L-2, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !node.IsEqual(&vcs.UPK[L-2][3]) {
+			t.Errorf("UPK node does not match")
+		}
+		if _, err := store.Upk(L-1, 0); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Expected ErrIndexOutOfRange, got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Verify;", L), func(t *testing.T) {
+		store := mapped.upkStore.(*DiskUpkStore)
+		if err := store.Verify(); err != nil {
+			t.Fatal(err)
+		}
+
+		fileName := vcs.folderPath + fmt.Sprintf(UPKNAME, 4)
+		data, err := os.ReadFile(fileName)
+		check(err)
+		data[len(data)-1] ^= 1
+		check(os.WriteFile(fileName, data, 0644))
+
+		other := VCS{}
+		other.Init(L, vcs.folderPath, 2)
+		store, err = other.OpenUpkStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if err := store.Verify(); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Expected ErrCorruptKeyFile, got %v", err)
+		}
+	})
+}
@@ -2,8 +2,7 @@ package vcs
 
 import (
 	"fmt"
-	"log"
-	"os"
+	"sync"
 
 	"github.com/alinush/go-mcl"
 )
@@ -84,12 +83,21 @@ func minUint64(a uint64, b uint64) uint64 {
 	return b
 }
 
-func fileSize(path string) int64 {
-	fi, err := os.Stat(path)
-	if err != nil {
-		log.Fatal(err)
+func maxUint64(a uint64, b uint64) uint64 {
+	if a > b {
+		return a
 	}
-	return fi.Size()
+	return b
+}
+
+// First error that is not nil, e.g. of the chunks of parallelChunks.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func BoundsPrint(start, stop uint64) string {
@@ -137,3 +145,46 @@ func SliceIsEqual(a, b []mcl.G1) bool {
 	}
 	return true
 }
+
+// Splits [0, n) in at most NCORES contiguous chunks and runs f on each chunk in its own goroutine.
+func parallelRange(n uint64, f func(start, stop uint64)) {
+	parallelChunks(n, int(NCORES), func(chunk int, start, stop uint64) {
+		f(start, stop)
+	})
+}
+
+// Splits [0, n) in at most workers contiguous chunks and runs f on each chunk in its own goroutine.
+// Chunk i is [i * step, (i + 1) * step) with step = ceil(n / workers), so results can be kept per chunk and combined in order.
+func parallelChunks(n uint64, workers int, f func(chunk int, start, stop uint64)) {
+	if workers <= 0 {
+		workers = 1
+	}
+	step := (n + uint64(workers) - 1) / uint64(workers)
+	if step == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for start := uint64(0); start < n; start += step {
+		wg.Add(1)
+		go func(chunk int, start, stop uint64) {
+			defer wg.Done()
+			f(chunk, start, stop)
+		}(int(start/step), start, minUint64(start+step, n))
+	}
+	wg.Wait()
+}
+
+// Checks prod e(P[i], Q[i]) == 1.
+func pairingProductIsOne(P []mcl.G1, Q []mcl.G2) bool {
+	var out mcl.GT
+	mcl.MillerLoopVec(&out, P, Q)
+	mcl.FinalExp(&out, &out)
+	return out.IsOne()
+}
+
+// Checks e(p1, q1) == e(p2, q2).
+func pairingIsEqual(p1 *mcl.G1, q1 *mcl.G2, p2 *mcl.G1, q2 *mcl.G2) bool {
+	var neg mcl.G1
+	mcl.G1Neg(&neg, p2)
+	return pairingProductIsOne([]mcl.G1{*p1, neg}, []mcl.G2{*q1, *q2})
+}
@@ -0,0 +1,263 @@
+package vcs
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alinush/go-mcl"
+)
+
+const VECNAME = "/vec-%02d.data"
+
+// Number of entries CommitStore reads at a time.
+const VECTOR_BATCH = 1 << 16
+
+// Vector on disk, split in chunks of consecutive entries. Each chunk is a key file of kind FILE_VECTOR
+// whose payload is the entries in [Start, Stop) as 32 byte Fr.
+// A vector does not depend on the setup, so the headers carry no ell or setup id.
+// Get reads single entries, Set and Add write batches of entries through to the files, as described in chunkFiles.
+type VectorStore struct {
+	N      uint64
+	folder string
+	chunkFiles
+}
+
+// Writes a to nfiles chunks in folder and opens them.
+func CreateVectorStore(folder string, a []mcl.Fr, nfiles uint8) (*VectorStore, error) {
+	if nfiles == 0 {
+		return nil, fmt.Errorf("%w: vector store with no files", ErrInvalidParam)
+	}
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	n := uint64(len(a))
+	step := (n + uint64(nfiles) - 1) / uint64(nfiles)
+	for i := uint8(0); i < nfiles; i++ {
+		start := minUint64(uint64(i)*step, n)
+		stop := minUint64(start+step, n)
+		fileName := folder + fmt.Sprintf(VECNAME, i)
+		f, err := createKeyFile(fileName, vectorHeader(nfiles, i, start, stop))
+		if err != nil {
+			return nil, err
+		}
+		for j := start; j < stop && err == nil; j++ {
+			_, err = f.Write(a[j].Serialize())
+		}
+		if err != nil {
+			f.f.Close()
+			return nil, err
+		}
+		if err = f.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return openVectorStore(folder, false)
+}
+
+func vectorHeader(nfiles uint8, index uint8, start uint64, stop uint64) FileHeader {
+	return FileHeader{
+		Version: FORMAT_VERSION,
+		Curve:   uint16(mcl.BLS12_381),
+		Kind:    FILE_VECTOR,
+		NFiles:  nfiles,
+		Index:   index,
+		Start:   start,
+		Stop:    stop,
+	}
+}
+
+// Opens a vector written by CreateVectorStore and checks its checksums.
+func OpenVectorStore(folder string) (*VectorStore, error) {
+	return openVectorStore(folder, true)
+}
+
+func openVectorStore(folder string, verify bool) (*VectorStore, error) {
+
+	store := VectorStore{folder: folder}
+	store.elemSize = GetFrByteSize()
+	fail := func(err error) (*VectorStore, error) {
+		store.Close()
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		fileName := folder + fmt.Sprintf(VECNAME, i)
+		f, err := openKeyFile(fileName, FILE_VECTOR)
+		if err != nil {
+			return fail(err)
+		}
+		h := f.Header
+		if h.NFiles == 0 || int(h.Index) != i || (i > 0 && h.NFiles != store.header[0].NFiles) {
+			f.Close()
+			return fail(fmt.Errorf("%w: %s is chunk %d of %d, want chunk %d", ErrParamMismatch, fileName, h.Index, h.NFiles, i))
+		}
+		if h.Start != store.N {
+			f.Close()
+			return fail(fmt.Errorf("%w: %s holds %s, previous chunk ends at %d", ErrParamMismatch, fileName, BoundsPrint(h.Start, h.Stop), store.N))
+		}
+		if err = f.expectPayload(int64(h.Stop-h.Start) * int64(GetFrByteSize())); err != nil {
+			f.Close()
+			return fail(err)
+		}
+		if err = store.reopen(f, verify); err != nil {
+			return fail(err)
+		}
+		store.N = h.Stop
+		if i+1 == int(h.NFiles) {
+			break
+		}
+	}
+	return &store, nil
+}
+
+// Chunk of an entry and the offset of the entry in it.
+func (store *VectorStore) locate(index uint64) (int, int64, error) {
+	if index >= store.N {
+		return 0, 0, fmt.Errorf("%w: index %d, vector size %d", ErrIndexOutOfRange, index, store.N)
+	}
+	i, offset := store.chunkOf(index)
+	return i, offset, nil
+}
+
+func (store *VectorStore) Get(index uint64) (mcl.Fr, error) {
+	var value mcl.Fr
+	i, offset, err := store.locate(index)
+	if err != nil {
+		return value, err
+	}
+	buf := make([]byte, GetFrByteSize())
+	if _, err = store.files[i].ReadAt(buf, offset); err != nil {
+		return value, fmt.Errorf("%w: %s: %v", ErrCorruptKeyFile, store.files[i].Name(), err)
+	}
+	if err = value.Deserialize(buf); err != nil {
+		return value, fmt.Errorf("%w: %s: entry %d: %v", ErrCorruptKeyFile, store.files[i].Name(), index, err)
+	}
+	return value, nil
+}
+
+// Entries at indexVec, in the same order.
+func (store *VectorStore) GetVec(indexVec []uint64) ([]mcl.Fr, error) {
+	valueVec := make([]mcl.Fr, len(indexVec))
+	for t := range indexVec {
+		var err error
+		if valueVec[t], err = store.Get(indexVec[t]); err != nil {
+			return nil, err
+		}
+	}
+	return valueVec, nil
+}
+
+// Entries in [start, stop).
+func (store *VectorStore) ReadRange(start uint64, stop uint64) ([]mcl.Fr, error) {
+	if start > stop || stop > store.N {
+		return nil, fmt.Errorf("%w: range %s, vector size %d", ErrIndexOutOfRange, BoundsPrint(start, stop), store.N)
+	}
+	a := make([]mcl.Fr, stop-start)
+	for j := start; j < stop; {
+		i, offset, _ := store.locate(j)
+		e := minUint64(stop, store.header[i].Stop)
+		r := io.NewSectionReader(store.files[i], offset, int64(e-j)*int64(GetFrByteSize()))
+		er := elementReader{r: r}
+		for ; j < e; j++ {
+			er.Fr(&a[j-start])
+		}
+		if er.err != nil {
+			return nil, fmt.Errorf("%s: %w", store.files[i].Name(), er.err)
+		}
+	}
+	return a, nil
+}
+
+// The whole vector, as LoadVector returns it.
+func (store *VectorStore) ReadAll() ([]mcl.Fr, error) {
+	return store.ReadRange(0, store.N)
+}
+
+// Sets the entries at indexVec to valueVec. All indices are checked before anything is written.
+func (store *VectorStore) Set(indexVec []uint64, valueVec []mcl.Fr) error {
+	if len(indexVec) != len(valueVec) {
+		return fmt.Errorf("%w: %d indices and %d values", ErrInvalidParam, len(indexVec), len(valueVec))
+	}
+	for t := range indexVec {
+		if indexVec[t] >= store.N {
+			return fmt.Errorf("%w: entry %d has index %d, vector size %d", ErrIndexOutOfRange, t, indexVec[t], store.N)
+		}
+	}
+	for t := range indexVec {
+		i, offset, _ := store.locate(indexVec[t])
+		if err := store.writeAt(i, valueVec[t].Serialize(), offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds deltaVec to the entries at indexVec, as UpdateComVec does to the digest.
+// An index may appear more than once, its deltas are summed.
+func (store *VectorStore) Add(indexVec []uint64, deltaVec []mcl.Fr) error {
+	if len(indexVec) != len(deltaVec) {
+		return fmt.Errorf("%w: %d indices and %d deltas", ErrInvalidParam, len(indexVec), len(deltaVec))
+	}
+	updates := make(map[uint64]mcl.Fr)
+	for t := range indexVec {
+		value, ok := updates[indexVec[t]]
+		if !ok {
+			var err error
+			if value, err = store.Get(indexVec[t]); err != nil {
+				return err
+			}
+		}
+		mcl.FrAdd(&value, &value, &deltaVec[t])
+		updates[indexVec[t]] = value
+	}
+	keys := make([]uint64, 0, len(updates))
+	values := make([]mcl.Fr, 0, len(updates))
+	for k, v := range updates {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	return store.Set(keys, values)
+}
+
+// Same as Commit, but reads the vector from store, and the UPK from the UPK store if one is set (see SetUpkStore),
+// VECTOR_BATCH entries at a time.
+func (vcs *VCS) CommitStore(store *VectorStore) (mcl.G1, error) {
+	var digest mcl.G1
+	if store.N > vcs.N {
+		return digest, fmt.Errorf("%w: vector of size %d, at most %d", ErrInvalidParam, store.N, vcs.N)
+	}
+	if vcs.upkStore == nil && len(vcs.UPK) != int(vcs.L)+1 {
+		return digest, fmt.Errorf("%w: CommitStore: UPK is not loaded", ErrParamMismatch)
+	}
+	digest.Clear()
+	for start := uint64(0); start < store.N; start += VECTOR_BATCH {
+		stop := minUint64(start+VECTOR_BATCH, store.N)
+		a, err := store.ReadRange(start, stop)
+		if err != nil {
+			return digest, err
+		}
+		upk, err := vcs.upkRange(vcs.L, start, stop)
+		if err != nil {
+			return digest, err
+		}
+		var partial mcl.G1
+		mcl.G1MulVec(&partial, upk, a)
+		mcl.G1Add(&digest, &digest, &partial)
+	}
+	return digest, nil
+}
+
+// Same as OpenAll, with the vector in store.
+func (vcs *VCS) OpenAllStore(store *VectorStore) error {
+	if store.N != vcs.N {
+		return fmt.Errorf("%w: vector of size %d, want %d", ErrInvalidParam, store.N, vcs.N)
+	}
+	a, err := store.ReadAll()
+	if err != nil {
+		return err
+	}
+	vcs.OpenAll(a)
+	return nil
+}
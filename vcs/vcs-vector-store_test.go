@@ -0,0 +1,133 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/alinush/go-mcl"
+)
+
+func TestVectorStore(t *testing.T) {
+
+	L := uint8(5)
+	vcs := newTestVCS(t, L, 2)
+	aFr := GenerateVectorSeeded(vcs.N, []byte(t.Name()))
+	folder := t.TempDir()
+
+	store, err := CreateVectorStore(folder, aFr, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run(fmt.Sprintf("%d/Read;", L), func(t *testing.T) {
+		if store.N != vcs.N {
+			t.Fatalf("Store holds %d entries, want %d", store.N, vcs.N)
+		}
+		for i := range aFr {
+			value, err := store.Get(uint64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !value.IsEqual(&aFr[i]) {
+				t.Errorf("Entry %d does not match", i)
+			}
+		}
+		b, err := store.ReadRange(9, 23)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range b {
+			if !b[i].IsEqual(&aFr[9+i]) {
+				t.Errorf("Entry %d of the range does not match", 9+i)
+			}
+		}
+		if _, err := store.Get(vcs.N); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Out of range index: got %v", err)
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Commit;", L), func(t *testing.T) {
+		digest, err := vcs.CommitStore(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := vcs.Commit(aFr, uint64(L))
+		if !digest.IsEqual(&want) {
+			t.Errorf("CommitStore does not match Commit")
+		}
+		if err := vcs.OpenAllStore(store); err != nil {
+			t.Fatal(err)
+		}
+		proof := vcs.GetProofPath(vcs.ProofTree, 12, L)
+		if !vcs.Verify(want, 12, aFr[12], proof) {
+			t.Errorf("Verification failed after OpenAllStore")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/MappedUpk;", L), func(t *testing.T) {
+		mapped := VCS{}
+		mapped.Init(L, vcs.folderPath, 2)
+		check(mapped.TryLoadVrk(L))
+		check(mapped.TryUpkMapDriver())
+		defer mapped.upkStore.Close()
+		digest, err := mapped.CommitStore(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := vcs.Commit(aFr, uint64(L))
+		if !digest.IsEqual(&want) {
+			t.Errorf("CommitStore with a mapped UPK does not match Commit")
+		}
+	})
+
+	t.Run(fmt.Sprintf("%d/Update;", L), func(t *testing.T) {
+		indexVec := []uint64{3, 17, 3, 31}
+		deltaVec := GenerateVectorSeeded(uint64(len(indexVec)), []byte("deltas"))
+		if err := store.Add(indexVec, deltaVec); err != nil {
+			t.Fatal(err)
+		}
+		for t := range indexVec {
+			mcl.FrAdd(&aFr[indexVec[t]], &aFr[indexVec[t]], &deltaVec[t])
+		}
+		if err := store.Set([]uint64{0}, aFr[1:2]); err != nil {
+			t.Fatal(err)
+		}
+		aFr[0] = aFr[1]
+		if err := store.Add([]uint64{5, vcs.N}, deltaVec[:2]); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("Out of range index: got %v", err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err = OpenVectorStore(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := store.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range b {
+			if !b[i].IsEqual(&aFr[i]) {
+				t.Errorf("Entry %d does not match after reopening", i)
+			}
+		}
+		store.Close()
+	})
+
+	t.Run(fmt.Sprintf("%d/Corrupt;", L), func(t *testing.T) {
+		f, _ := os.OpenFile(folder+fmt.Sprintf(VECNAME, 1), os.O_RDWR, 0)
+		f.WriteAt([]byte{0xff}, HEADER_SIZE+40)
+		f.Close()
+		if _, err := OpenVectorStore(folder); !errors.Is(err, ErrCorruptKeyFile) {
+			t.Errorf("Corrupt chunk: got %v", err)
+		}
+		os.Remove(folder + fmt.Sprintf(VECNAME, 2))
+		if _, err := OpenVectorStore(folder); err == nil {
+			t.Errorf("Missing chunk was not reported")
+		}
+	})
+}
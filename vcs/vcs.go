@@ -1,10 +1,12 @@
 package vcs
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 
 	"github.com/alinush/go-mcl"
-	"github.com/hyperproofs/gipa-go/batch"
 	"github.com/hyperproofs/gipa-go/cm"
 	"github.com/hyperproofs/kzg-go/kzg"
 )
@@ -33,20 +35,22 @@ type VCS struct {
 	// Proof serving node saves this proof tree all the time. Unable to fit beyond 2^26 in memory.
 
 	// GIPA Stuff
-	MN       uint64 // Power of 2 which is nearest to TxnLimit * L. In GIPA notation let M = L, n = TxnLimit = 1024
+	MN       uint64 // Size of the aggregation keys. Power of 2 which is nearest to (TxnLimit + 1) * L (see aggSize), grown for larger batches. In GIPA notation let M = L, n = TxnLimit = 1024
 	ck       cm.Ck  // KZG + GIPA
-	TxnLimit uint64 // Number of txns in a block that needs to be aggregated
-	nDiff    int64  // These many variables are used to pad the GIPA instance if L * TxnLimit is not a power of 2.
-	mnDiff   int64  // These many variables are used to pad the GIPA instance if L * TxnLimit is not a power of 2.
+	TxnLimit uint64 // Number of txns in a block the aggregation keys are loaded for. Batches of any size up to MAX_AGG_SIZE / L - 1 can be aggregated.
 
 	// KZG Stuff
 	kzg1 kzg.KZG1Settings // KZG + GIPA
 	kzg2 kzg.KZG2Settings // KZG + GIPA
 
 	folderPath string
+	setupID    [32]byte // Identifies the setup the key files belong to. See computeSetupID.
+	setupL     uint8    // ell of the setup that wrote the key files. Can be larger than L.
+	setupFile  string   // File the setup id was first read from. Used in error messages.
 
-	aggProver   batch.Prover
-	aggVerifier batch.Verifier
+	upkStore UpkStore // When set, GetUpk, UpdateCom and UpdateProofTree read the UPK from it instead of vcs.UPK.
+
+	aggMu *sync.RWMutex // Guards MN, ck, kzg1 and kzg2, which are grown while proofs are aggregated. Set by Init and shared by copies of the VCS.
 
 	DISCARD_PRK bool // We do not use: g, g^{s_1}, g^{s_2}, g^{s_1}{s_2}, g^{s_3}.....
 	// Thus, PRK is discarded by default
@@ -58,17 +62,30 @@ type VCS struct {
 // Space for UPK and PRK will be created when keys are created and saved.
 // This reduces the memory footprint.
 func (vcs *VCS) Init(L uint8, folder string, txnLimit uint64) {
+	check(vcs.TryInit(L, folder, txnLimit))
+}
+
+// Same as Init, but returns an error for an unsupported ell or block size.
+func (vcs *VCS) TryInit(L uint8, folder string, txnLimit uint64) error {
 	// Note this has been modified from 16 
 	NFILES = 16
 	PRKNAME = "/prk-%02d.data"
 	VRKNAME = "/vrk.data"
 	TRAPDOORNAME = "/trapdoors.data"
 	UPKNAME = "/upk-%02d.data"
+	PROOFTREENAME = "/prooftree-%02d.data"
 	if L == 0 || L >= 32 {
-		panic("KeyGen: Error. Either ell is 0 or >= 32")
+		return fmt.Errorf("%w: KeyGen: Either ell is 0 or >= 32", ErrInvalidParam)
+	}
+	if (txnLimit+1)*uint64(L) > MAX_AGG_SIZE {
+		return fmt.Errorf("%w: Try with smaller block size", ErrBatchTooLarge)
 	}
 
+	if vcs.aggMu == nil {
+		vcs.aggMu = new(sync.RWMutex)
+	}
 	vcs.folderPath = folder
+	vcs.setupFile = "" // The setup is fixed by the first key file that is generated or loaded.
 
 	vcs.L = L
 	vcs.N = uint64(1) << L
@@ -92,14 +109,11 @@ func (vcs *VCS) Init(L uint8, folder string, txnLimit uint64) {
 
 	vcs.TxnLimit = txnLimit
 
-	if txnLimit*uint64(L) > MAX_AGG_SIZE {
-		panic("Try with smaller block size")
-	}
-
 	vcs.DISCARD_PRK = true // It is assumed true by default.
 	if L > 24 {
 		vcs.PARAM_TOO_LARGE = true // When UPK and PRK is large, keys are just flushed to files without keeping it in memory.
 	}
+	return nil
 }
 
 // Generate trapdoors for the VCS. Be sure to run this after ```Init```.
@@ -107,16 +121,31 @@ func (vcs *VCS) TrapdoorsGen() {
 
 	// Need to find a source of randomness and generate trapdoors
 	// Need to seed the randomness
+	// See TrapdoorsGenSeeded for a reproducible variant.
 
-	// Sample generators
-	vcs.G.Random()
-	vcs.H.Random()
+	// Generators are public, see StandardGenerators
+	vcs.G, vcs.H = StandardGenerators()
 
 	// Generate trapdoors
+	for i := range vcs.trapdoors {
+		vcs.trapdoors[i].Random()
+	}
+
+	// Generate alpha and beta for KZG
+	vcs.alpha.Random()
+
+	vcs.beta.Random()
+
+	vcs.deriveVrk()
+	vcs.SaveTrapdoor()
+}
+
+// Computes (1-s_i), (s_i-1), the VRK and the setup id from G, H and the trapdoors.
+func (vcs *VCS) deriveVrk() {
+
 	var frOne mcl.Fr
 	frOne.SetInt64(1)
 	for i := range vcs.trapdoors {
-		vcs.trapdoors[i].Random()
 		mcl.FrSub(&vcs.trapdoorsSubOne[i], &frOne, &vcs.trapdoors[i])
 		mcl.FrSub(&vcs.trapdoorsSubOneRev[i], &vcs.trapdoors[i], &frOne)
 	}
@@ -130,22 +159,20 @@ func (vcs *VCS) TrapdoorsGen() {
 	for i := range vcs.trapdoorsSubOne {
 		mcl.G2Mul(&vcs.VRKSubOne[i], &vcs.H, &vcs.trapdoorsSubOne[i])
 	}
-	
+
 	// Generate VRKSubOneRev: h^(s_1-1), h^(s_2-1), ....
 	for i := range vcs.trapdoorsSubOneRev {
 		mcl.G2Mul(&vcs.VRKSubOneRev[i], &vcs.H, &vcs.trapdoorsSubOneRev[i])
 	}
-	
-	// Generate alpha and beta for KZG
-	vcs.alpha.Random()
-	
-	vcs.beta.Random()
 
-	vcs.SaveTrapdoor()
+	vcs.setupID = vcs.computeSetupID()
+	vcs.setupL = vcs.L
+	vcs.setupFile = vcs.folderPath + TRAPDOORNAME
 }
 
 // Generates PRK VRK UPK etc
 // Use this only once to generate the parameters.
+// The trapdoors stay in memory and in the trapdoor file. Use Setup to get rid of them.
 func (vcs *VCS) KeyGen(ncores uint8, L uint8, folder string, txnLimit uint64) {
 
 	NCORES = ncores               // Maximum number threads created. Set this to number of available cores.
@@ -155,14 +182,70 @@ func (vcs *VCS) KeyGen(ncores uint8, L uint8, folder string, txnLimit uint64) {
 	vcs.GenAggGipa()
 }
 
+// One-time trusted setup: generates all the keys and then destroys the trapdoors.
+// Afterwards the folder only holds public parameters, to be loaded with KeyGenLoad.
+func (vcs *VCS) Setup(ncores uint8, L uint8, folder string, txnLimit uint64) error {
+	vcs.KeyGen(ncores, L, folder, txnLimit)
+	return vcs.DestroyTrapdoors()
+}
+
+// Wipes the trapdoors, alpha and beta from memory and overwrites and deletes the trapdoor file.
+// The public parameters are left untouched. Fake keys (KeyGenFake, GenUpkFake) cannot be used afterwards.
+func (vcs *VCS) DestroyTrapdoors() error {
+
+	for i := range vcs.trapdoors {
+		vcs.trapdoors[i].Clear()
+		vcs.trapdoorsSubOne[i].Clear()
+		vcs.trapdoorsSubOneRev[i].Clear()
+	}
+	vcs.alpha.Clear()
+	vcs.beta.Clear()
+
+	fileName := vcs.folderPath + TRAPDOORNAME
+	f, err := os.OpenFile(fileName, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err == nil {
+		_, err = f.Write(make([]byte, fi.Size()))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(SEP, "Destroyed trapdoors", SEP)
+	return os.Remove(fileName)
+}
+
 // Defacto entry to VCS.
 // Use this to load the files always
+// Only public parameters are loaded: G, H, VRK, UPK and the aggregation keys. The trapdoor file is not needed.
 func (vcs *VCS) KeyGenLoad(ncores uint8, L uint8, folder string, txnLimit uint64) {
+	check(vcs.TryKeyGenLoad(ncores, L, folder, txnLimit))
+}
+
+// Same as KeyGenLoad, but a missing, truncated or corrupt key file is reported as an error.
+func (vcs *VCS) TryKeyGenLoad(ncores uint8, L uint8, folder string, txnLimit uint64) error {
 	NCORES = ncores
-	vcs.Init(L, folder, txnLimit)
-	vcs.LoadTrapdoor(L)
-	vcs.PrkUpkLoad()
-	vcs.LoadAggGipa()
+	if err := vcs.TryInit(L, folder, txnLimit); err != nil {
+		return err
+	}
+	if err := vcs.TryLoadVrk(L); err != nil {
+		return err
+	}
+	if err := vcs.TryPrkUpkLoad(); err != nil {
+		return err
+	}
+	return vcs.TryLoadAggGipa()
 }
 
 // Do not remove L from the parameters. I am using it OpenAll
@@ -315,7 +398,8 @@ func (vcs *VCS) VerifyMemoized(digest mcl.G1, indexVec []uint64, a_i []mcl.Fr, p
 func (vcs *VCS) UpdateCom(digest mcl.G1, updateindex uint64, delta mcl.Fr) mcl.G1 {
 	var temp mcl.G1
 	var result mcl.G1
-	mcl.G1Mul(&temp, &vcs.UPK[vcs.L][updateindex], &delta)
+	upk := vcs.upkAt(vcs.L, updateindex)
+	mcl.G1Mul(&temp, &upk, &delta)
 	mcl.G1Add(&result, &digest, &temp)
 	return result
 }
@@ -329,7 +413,7 @@ func (vcs *VCS) UpdateComVec(digest mcl.G1, updateindex []uint64, delta []mcl.Fr
 	var temp mcl.G1
 	upks := make([]mcl.G1, N)
 	for i := 0; i < N; i++ {
-		upks[i] = vcs.UPK[vcs.L][updateindex[i]]
+		upks[i] = vcs.upkAt(vcs.L, updateindex[i])
 	}
 
 	mcl.G1MulVec(&temp, upks, delta)
@@ -427,116 +511,88 @@ func (vcs *VCS) UpdateProofTree(updateindex uint64, delta mcl.Fr) {
 }
 
 func (vcs *VCS) UpdateProofTreeBulkInPlace(proofTree [][]mcl.G1, updateindexVec []uint64, deltaVec []mcl.Fr) {
-    var q_i mcl.G1
-    var x, upk_i uint8
-    var y uint64
-  
-    g1Db := make(map[TreeGPS][]mcl.G1)
-    frDb := make(map[TreeGPS][]mcl.Fr)
- 
-    for t := range updateindexVec {
-        updateindex := updateindexVec[t]
-        delta := deltaVec[t]
- 
-        updateindexBinary := ToBinary(updateindex, vcs.L)       // LSB first
-        updateindexBinary = ReverseSliceBool(updateindexBinary) // MSB first
- 
-        upk := vcs.GetUpk(updateindex)                     // Pop upk_{u,l} as it containts ell variables.
-        upk = append([]mcl.G1{vcs.G}, upk[:len(upk)-1]...) // Since the root of the proof tree contains only ell - 1 variables, we need to pop the upk.
- 
-        L := vcs.L
-        Y := FindTreeGPS(updateindex, int(L))
-        // Start from the top of the prooftree (which implies start from the bottom of the UPK tree)
-        for i := uint8(0); i < L; i++ {
-            x = i
-            y = Y[i]
-            upk_i = L - i - 1
-            loc := TreeGPS{x, y}
- 
-            q_i = upk[upk_i]
- 
-            if !updateindexBinary[x] {
-                mcl.G1Neg(&q_i, &q_i)
-            }
-            g1Db[loc] = append(g1Db[loc], q_i)
-            frDb[loc] = append(frDb[loc], delta)
-        }
-    }
- 
-    for key := range g1Db {
-        A := g1Db[key]
-        B := frDb[key]
-        mcl.G1MulVec(&q_i, A, B)
-        // Ensure the dimensions exist in the new proof tree
-        if int(key.level) >= len(proofTree) {
-            continue // Skip if level is out of bounds
-        }
-        if int(key.index) >= len(proofTree[key.level]) {
-            continue // Skip if index is out of bounds
-        }
-        mcl.G1Add(&proofTree[key.level][key.index], &proofTree[key.level][key.index], &q_i)
-    }
+
+	for key, q_i := range vcs.proofTreeDeltas(updateindexVec, deltaVec) {
+		// Ensure the dimensions exist in the proof tree
+		if int(key.level) >= len(proofTree) {
+			continue // Skip if level is out of bounds
+		}
+		if int(key.index) >= len(proofTree[key.level]) {
+			continue // Skip if index is out of bounds
+		}
+		mcl.G1Add(&proofTree[key.level][key.index], &proofTree[key.level][key.index], &q_i)
+	}
 }
 
 // Modified function that takes a proof tree as a parameter
 func (vcs *VCS) UpdateProofTreeBulk(proofTree [][]mcl.G1, updateindexVec []uint64, deltaVec []mcl.Fr) ([][]mcl.G1, int) {
-    var q_i mcl.G1
-    var x, upk_i uint8
-    var y uint64
- 
-    // Make a deep copy of the proof tree to avoid modifying the original
-    newProofTree := make([][]mcl.G1, len(proofTree))
-    for i := range proofTree {
-        newProofTree[i] = make([]mcl.G1, len(proofTree[i]))
-        copy(newProofTree[i], proofTree[i])
-    }
- 
-    g1Db := make(map[TreeGPS][]mcl.G1)
-    frDb := make(map[TreeGPS][]mcl.Fr)
- 
-    for t := range updateindexVec {
-        updateindex := updateindexVec[t]
-        delta := deltaVec[t]
- 
-        updateindexBinary := ToBinary(updateindex, vcs.L)       // LSB first
-        updateindexBinary = ReverseSliceBool(updateindexBinary) // MSB first
- 
-        upk := vcs.GetUpk(updateindex)                     // Pop upk_{u,l} as it containts ell variables.
-        upk = append([]mcl.G1{vcs.G}, upk[:len(upk)-1]...) // Since the root of the proof tree contains only ell - 1 variables, we need to pop the upk.
- 
-        L := vcs.L
-        Y := FindTreeGPS(updateindex, int(L))
-        // Start from the top of the prooftree (which implies start from the bottom of the UPK tree)
-        for i := uint8(0); i < L; i++ {
-            x = i
-            y = Y[i]
-            upk_i = L - i - 1
-            loc := TreeGPS{x, y}
- 
-            q_i = upk[upk_i]
- 
-            if !updateindexBinary[x] {
-                mcl.G1Neg(&q_i, &q_i)
-            }
-            g1Db[loc] = append(g1Db[loc], q_i)
-            frDb[loc] = append(frDb[loc], delta)
-        }
-    }
- 
-    for key := range g1Db {
-        A := g1Db[key]
-        B := frDb[key]
-        mcl.G1MulVec(&q_i, A, B)
-        // Ensure the dimensions exist in the new proof tree
-        if int(key.level) >= len(newProofTree) {
-            continue // Skip if level is out of bounds
-        }
-        if int(key.index) >= len(newProofTree[key.level]) {
-            continue // Skip if index is out of bounds
-        }
-        mcl.G1Add(&newProofTree[key.level][key.index], &newProofTree[key.level][key.index], &q_i)
-    }
-    return newProofTree, len(g1Db)
+
+	// Make a deep copy of the proof tree to avoid modifying the original
+	newProofTree := make([][]mcl.G1, len(proofTree))
+	for i := range proofTree {
+		newProofTree[i] = make([]mcl.G1, len(proofTree[i]))
+		copy(newProofTree[i], proofTree[i])
+	}
+
+	deltas := vcs.proofTreeDeltas(updateindexVec, deltaVec)
+	for key, q_i := range deltas {
+		// Ensure the dimensions exist in the new proof tree
+		if int(key.level) >= len(newProofTree) {
+			continue // Skip if level is out of bounds
+		}
+		if int(key.index) >= len(newProofTree[key.level]) {
+			continue // Skip if index is out of bounds
+		}
+		mcl.G1Add(&newProofTree[key.level][key.index], &newProofTree[key.level][key.index], &q_i)
+	}
+	return newProofTree, len(deltas)
+}
+
+// What a batch of updates adds to every node of the proof tree it touches.
+// The contributions to a node are combined with one multi-exponentiation.
+func (vcs *VCS) proofTreeDeltas(updateindexVec []uint64, deltaVec []mcl.Fr) map[TreeGPS]mcl.G1 {
+	var q_i mcl.G1
+	var x, upk_i uint8
+	var y uint64
+
+	g1Db := make(map[TreeGPS][]mcl.G1)
+	frDb := make(map[TreeGPS][]mcl.Fr)
+
+	for t := range updateindexVec {
+		updateindex := updateindexVec[t]
+		delta := deltaVec[t]
+
+		updateindexBinary := ToBinary(updateindex, vcs.L)       // LSB first
+		updateindexBinary = ReverseSliceBool(updateindexBinary) // MSB first
+
+		upk := vcs.GetUpk(updateindex)                     // Pop upk_{u,l} as it containts ell variables.
+		upk = append([]mcl.G1{vcs.G}, upk[:len(upk)-1]...) // Since the root of the proof tree contains only ell - 1 variables, we need to pop the upk.
+
+		L := vcs.L
+		Y := FindTreeGPS(updateindex, int(L))
+		// Start from the top of the prooftree (which implies start from the bottom of the UPK tree)
+		for i := uint8(0); i < L; i++ {
+			x = i
+			y = Y[i]
+			upk_i = L - i - 1
+			loc := TreeGPS{x, y}
+
+			q_i = upk[upk_i]
+
+			if !updateindexBinary[x] {
+				mcl.G1Neg(&q_i, &q_i)
+			}
+			g1Db[loc] = append(g1Db[loc], q_i)
+			frDb[loc] = append(frDb[loc], delta)
+		}
+	}
+
+	deltas := make(map[TreeGPS]mcl.G1, len(g1Db))
+	for key := range g1Db {
+		mcl.G1MulVec(&q_i, g1Db[key], frDb[key])
+		deltas[key] = q_i
+	}
+	return deltas
 }
 
 // g^{(1-s_1)}, g^{(1-s_2)(1-s_1)}, g^{(1-s_3)(1-s_2)(1-s_1)}
@@ -546,7 +602,7 @@ func (vcs *VCS) GetUpk(i uint64) []mcl.G1 {
 	upk := make([]mcl.G1, vcs.L)
 	for j := uint8(vcs.L); j > 0; j-- {
 		k = k & (^(1 << j)) // Clears the jth bit of k. Technically everything before jth and before has to be cleared.
-		upk[j-1] = vcs.UPK[j][k]
+		upk[j-1] = vcs.upkAt(j, k)
 	}
 	return upk
 }
@@ -12,8 +12,7 @@ import (
 // Basic unit test cases for testing VCS functionality.
 func TestVCS(t *testing.T) {
 
-	// This method assumes that VRK, UPK are already computed and saved on disk.
-	// Be sure to run the run vcs.KeyGen if PRK, VRK, UPK keys are not computed.
+	// Keys are derived from a fixed seed in memory, no key folder is needed.
 
 	mcl.InitFromString("bls12-381")
 	fmt.Println("Curve order", mcl.GetCurveOrder())
@@ -28,7 +27,7 @@ func TestVCS(t *testing.T) {
 		N := uint64(1) << L
 
 		vcs := VCS{}
-		vcs.KeyGenLoad(16, L, "../pkvk-17", txnLimit)
+		vcs.KeyGenSeededInMemory(16, L, txnLimit, []byte("TestVCS"))
 
 		indexVec := make([]uint64, K)   // List of indices that chanaged (there can be duplicates.)
 		proofVec := make([][]mcl.G1, K) // Proofs of the changed indices.
@@ -58,7 +57,7 @@ func TestVCS(t *testing.T) {
 			for k := 0; k < K; k++ {
 				indexVec[k] = uint64(rand.Intn(int(N))) // Can contain duplicates
 				// fmt.Println("indexVec: ", indexVec[k])
-				// proofVec[k] = vcs.GetProofPath(indexVec[k])
+				proofVec[k] = vcs.GetProofPath(vcs.ProofTree, indexVec[k], L)
 				deltaVec[k].Random()
 				valueVec[k] = aFr[indexVec[k]]
 			}
@@ -103,7 +102,7 @@ func TestVCS(t *testing.T) {
 
 		// Get the updated proofs
 		for k := 0; k < K; k++ {
-			// proofVec[k] = vcs.GetProofPath(indexVec[k])
+			proofVec[k] = vcs.GetProofPath(vcs.ProofTree, indexVec[k], L)
 		}
 
 		//Update commitment C => C'
@@ -124,7 +123,7 @@ func TestVCS(t *testing.T) {
 		// 2. It can batch operations at each level of the tree
 		// 3. It minimizes redundant computations by grouping updates to the same tree nodes
 		// The function returns the number of unique tree nodes that were updated.
-		// vcs.UpdateProofTreeBulk(indexVec, deltaVec)
+		vcs.ProofTree, _ = vcs.UpdateProofTreeBulk(vcs.ProofTree, indexVec, deltaVec)
 
 		//================== END OF SECOND ITERATION ================//
 
@@ -135,7 +134,7 @@ func TestVCS(t *testing.T) {
 
 		// Get \pi' ==> \pi''
 		for k := 0; k < K; k++ {
-			// proofVec[k] = vcs.GetProofPath(indexVec[k])
+			proofVec[k] = vcs.GetProofPath(vcs.ProofTree, indexVec[k], L)
 		}
 
 		// Update C' ==> C"
@@ -161,12 +160,12 @@ func TestVCS(t *testing.T) {
 		})
 
 		// Simple do another round of updates to check if aggregated succeeded
-		// vcs.UpdateProofTreeBulk(indexVec, deltaVec)
+		vcs.ProofTree, _ = vcs.UpdateProofTreeBulk(vcs.ProofTree, indexVec, deltaVec)
 		//================== END OF THIRD ITERATION ================//
 
 		valueVec = SecondaryStateUpdate(indexVec, deltaVec, valueVec)
 		for k := 0; k < K; k++ {
-			// proofVec[k] = vcs.GetProofPath(indexVec[k])
+			proofVec[k] = vcs.GetProofPath(vcs.ProofTree, indexVec[k], L)
 		}
 		digest = vcs.UpdateComVec(digest, indexVec, deltaVec)
 